@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"time"
+
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"gopkg.in/validator.v2"
+)
+
+/*
+ * Admin Password Login
+ */
+func AdminPasswordLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var login mod.AdminPasswordLogin
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(login); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	loginAdmin(ctx, w, r, login.Phone, login.Password)
+}
+
+func loginAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request, phone, password string) {
+	account := accountKey(mod.ADMIN, "", phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	user, err := store.Admins.FindByPhone(ctx, phone)
+	if err != nil {
+		util.Log.Printf("Unable to find admin : %v", err)
+		checkDummyPassword(password)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
+		return
+	}
+
+	//validate Password
+	if match, _ := util.CheckPassword(user.Password, password); !match {
+		util.Log.Printf("Password did not match for : %v", phone)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
+		return
+	}
+	attempt.refund(ctx)
+	loginSucceeded(ctx, account)
+
+	writeLoginToken(w, r, &mod.AdminTokenData{
+		UserType: user.UserType,
+		Phone:    user.Phone,
+		Name:     user.Name,
+	})
+}
+
+/*
+ * List all tenents ( proprietors ) on the platform.
+ */
+func GetAllTenents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Proprietors.List(ctx)
+	if err != nil {
+		util.Log.Printf("Unable to find proprietors: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range c {
+		c[i].Password = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Proprietors{Proprietors: c})
+}
+
+func SuspendTenent(w http.ResponseWriter, r *http.Request) {
+	setTenentActive(w, r, false)
+}
+
+func ReactivateTenent(w http.ResponseWriter, r *http.Request) {
+	setTenentActive(w, r, true)
+}
+
+func setTenentActive(w http.ResponseWriter, r *http.Request, active bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	params := mux.Vars(r)
+	tenent := params["Tenent"]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := store.Proprietors.SetActive(ctx, tenent, active)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tenent not found: " + tenent})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to update tenent: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	status := "Tenent suspended."
+	if active {
+		status = "Tenent reactivated."
+	}
+	util.Log.Printf("%v %v", status, tenent)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: status})
+}
+
+/*
+ * Per tenent counts of guards, companies, patrols and incidents.
+ */
+func GetTenentStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var proprietors []mod.Proprietor
+	var err error
+	if tenent, ok := mux.Vars(r)["Tenent"]; ok {
+		var p mod.Proprietor
+		p, err = store.Proprietors.FindByTenent(ctx, tenent)
+		if err == db.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tenent not found: " + tenent})
+			return
+		}
+		proprietors = []mod.Proprietor{p}
+	} else {
+		proprietors, err = store.Proprietors.List(ctx)
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find proprietors: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c := []mod.TenentStats{}
+	for _, p := range proprietors {
+		stats, err := tenentStats(ctx, p)
+		if err != nil {
+			util.Log.Printf("Unable to count tenent data: %v", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		c = append(c, stats)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.TenentsStats{Tenents: c})
+}
+
+func tenentStats(ctx context.Context, p mod.Proprietor) (mod.TenentStats, error) {
+	var err error
+	stats := mod.TenentStats{Tenent: p.Tenent, Group: p.Group, Active: p.Active}
+
+	if stats.Guards, err = store.Guards.Count(ctx, p.Tenent); err != nil {
+		return stats, err
+	}
+	if stats.Companies, err = store.Companies.Count(ctx, p.Tenent); err != nil {
+		return stats, err
+	}
+	if stats.Patrols, err = store.Patrols.Count(ctx, p.Tenent); err != nil {
+		return stats, err
+	}
+	if stats.Incidents, err = store.Incidents.Count(ctx, p.Tenent); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+)
+
+func adminToken(t *testing.T, s *testServer) string {
+	t.Helper()
+	if err := db.SeedAdmin(s.store, mod.Admin{Name: "root", Phone: "9000000000", Password: testPassword}); err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+	rec := s.do("POST", "/v1/auth/login-admin-password", mod.AdminPasswordLogin{
+		Phone: "9000000000", Password: testPassword, UserType: mod.ADMIN,
+	}, "")
+	s.expect(rec, http.StatusOK)
+	return tokenCookie(t, rec)
+}
+
+func TestAdminTenentManagement(t *testing.T) {
+	s := newTestServer(t)
+	admin := adminToken(t, s)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	s.company(owner, "acme")
+
+	var tenents mod.Proprietors
+	rec := s.do("GET", "/v1/admin/tenents", nil, admin)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tenents)
+	if len(tenents.Proprietors) != 1 || tenents.Proprietors[0].Password != "" {
+		t.Fatalf("unexpected tenents %+v", tenents)
+	}
+
+	var stats mod.TenentsStats
+	rec = s.do("GET", "/v1/admin/tenent/"+tenent+"/stats", nil, admin)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &stats)
+	if len(stats.Tenents) != 1 || stats.Tenents[0].Guards != 1 || stats.Tenents[0].Companies != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	s.expect(s.do("GET", "/v1/admin/tenents/stats", nil, admin), http.StatusOK)
+	s.expect(s.do("GET", "/v1/admin/tenent/unknown/stats", nil, admin), http.StatusNotFound)
+
+	//suspended tenent is locked out
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+tenent+"/suspend", nil, admin), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, owner), http.StatusUnauthorized)
+	s.expect(s.do("GET", "/v1/companies", nil, guard), http.StatusUnauthorized)
+	rec = s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusForbidden)
+
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+tenent+"/reactivate", nil, admin), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, owner), http.StatusOK)
+	s.expect(s.do("PUT", "/v1/admin/tenent/unknown/suspend", nil, admin), http.StatusNotFound)
+}
+
+func TestSeedAdminOnlyOnce(t *testing.T) {
+	s := newTestServer(t)
+	adminToken(t, s)
+	if err := db.SeedAdmin(s.store, mod.Admin{Name: "other", Phone: "9000000001", Password: testPassword}); err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+	rec := s.do("POST", "/v1/auth/login-admin-password", mod.AdminPasswordLogin{
+		Phone: "9000000001", Password: testPassword, UserType: mod.ADMIN,
+	}, "")
+	s.expect(rec, http.StatusUnauthorized)
+}
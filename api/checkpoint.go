@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+/*
+ * Resolve the scanned tag of a patrol to a checkpoint of the company. Companies without
+ * checkpoints accept any tag, otherwise an unknown tag is flagged, or rejected with a
+ * response written and false returned when the company asks for it.
+ */
+func resolveCheckpoint(ctx context.Context, w http.ResponseWriter, company mod.Company, patrol *mod.Patrol) bool {
+	//set only from the company checkpoints, never kept from the request.
+	patrol.CheckpointId, patrol.Checkpoint, patrol.UnknownTag = "", "", false
+	companyId := company.Id.Hex()
+	checkpoint, err := store.Checkpoints.FindByTag(ctx, company.Tenent, companyId, patrol.RFData)
+	if err == nil {
+		patrol.CheckpointId = checkpoint.Id.Hex()
+		patrol.Checkpoint = checkpoint.Name
+		return true
+	}
+	count, cerr := store.Checkpoints.Count(ctx, company.Tenent, companyId)
+	if err != db.ErrNotFound || cerr != nil {
+		util.Log.Printf("Unable to resolve checkpoint: %v %v", err, cerr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if count == 0 {
+		return true
+	}
+	if company.RejectUnknownTags {
+		util.Log.Printf("Unknown tag %q at %v", patrol.RFData, company.Name)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tag is not a checkpoint of " + company.Name})
+		return false
+	}
+	patrol.UnknownTag = true
+	return true
+}
+
+/*
+ * Register a tag at a company site.
+ */
+func AddCheckpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.Checkpoint
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	req.Location = nil
+	if req.GPS != "" {
+		lat, lng, err := util.ParseLatLng(req.GPS)
+		if err != nil {
+			util.Log.Printf("Invalid gps %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "gps: " + err.Error()})
+			return
+		}
+		req.Location = util.GeoPoint(lat, lng)
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := store.Companies.FindById(ctx, tenent, objID); err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + id})
+		return
+	}
+
+	req.Id = primitive.NilObjectID
+	req.Tenent = tenent
+	req.CompanyId = id
+	req.Version = 0
+	checkpoint, err := store.Checkpoints.Create(ctx, req)
+	if err == db.ErrDuplicate {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tag already registered as a checkpoint."})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(checkpoint)
+}
+
+/*
+ * Checkpoints of a company in the expected patrol order.
+ */
+func GetCheckpointsByCompanyId(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Checkpoints.List(ctx, claims["tenent"].(string), id)
+	if err != nil {
+		util.Log.Printf("Unable to find checkpoints: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Checkpoints{Checkpoints: c})
+}
+
+/*
+ * Partial update of a checkpoint, a new tag is registered as a new checkpoint.
+ */
+func UpdateCheckpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	version, fields, err := decodePatch(r, mod.Checkpoint{}, "name", "tagtype", "order")
+	if err != nil {
+		writeBadPatch(w, err)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	checkpoint, err := store.Checkpoints.Update(ctx, claims["tenent"].(string), objID, version, fields)
+	if err != nil {
+		writeUpdateError(w, err, "Checkpoint", id)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(checkpoint)
+}
+
+func DeleteCheckpointById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = store.Checkpoints.DeleteById(ctx, claims["tenent"].(string), objID)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Checkpoint not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to delete checkpoint: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Checkpoint removed."})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func TestCheckpoints(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	acme := s.company(owner, "acme")
+	globex := s.company(owner, "globex")
+
+	//no checkpoints yet, any tag is accepted
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.97,77.59", RFData: "anything"}, guard), http.StatusCreated)
+
+	gate := mod.Checkpoint{Name: "main gate", Tag: "04:A2:2B", TagType: "nfc", GPS: "12.97,77.59", Order: 1}
+	s.expect(s.do("POST", "/v1/company/"+acme+"/checkpoint", gate, guard), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/company/000000000000000000000000/checkpoint", gate, owner), http.StatusNotFound)
+	for _, c := range []mod.Checkpoint{
+		{Name: "ab", Tag: "x", TagType: "nfc"},
+		{Name: "main gate", Tag: "", TagType: "nfc"},
+		{Name: "main gate", Tag: "x", TagType: "barcode"},
+		{Name: "main gate", Tag: "x", TagType: "qr", GPS: "gate"},
+		{Name: "main gate", Tag: "x", TagType: "qr", Order: -1},
+	} {
+		s.expect(s.do("POST", "/v1/company/"+acme+"/checkpoint", c, owner), http.StatusBadRequest)
+	}
+
+	var checkpoint mod.Checkpoint
+	rec := s.do("POST", "/v1/company/"+acme+"/checkpoint", gate, owner)
+	s.expect(rec, http.StatusCreated)
+	decode(t, rec, &checkpoint)
+	if checkpoint.Id.IsZero() || checkpoint.CompanyId != acme || checkpoint.Location == nil {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+	s.expect(s.do("POST", "/v1/company/"+acme+"/checkpoint", mod.Checkpoint{Name: "lobby", Tag: "QR-LOBBY", TagType: "qr", Order: 0}, owner), http.StatusCreated)
+	//a tag is at one site only
+	s.expect(s.do("POST", "/v1/company/"+globex+"/checkpoint", gate, owner), http.StatusConflict)
+
+	var checkpoints mod.Checkpoints
+	rec = s.do("GET", "/v1/company/"+acme+"/checkpoints", nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &checkpoints)
+	if c := checkpoints.Checkpoints; len(c) != 2 || c[0].Name != "lobby" || c[1].Name != "main gate" {
+		t.Fatalf("unexpected checkpoints %+v", c)
+	}
+
+	rec = s.do("PATCH", "/v1/checkpoint/"+checkpoint.Id.Hex(), patch{"version": 0, "name": "north gate"}, owner)
+	s.expect(rec, http.StatusOK)
+	s.expect(s.do("PATCH", "/v1/checkpoint/"+checkpoint.Id.Hex(), patch{"version": 1, "tag": "other"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/checkpoint/"+checkpoint.Id.Hex(), patch{"version": 1, "name": "x"}, auditor), http.StatusUnauthorized)
+
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.97,77.59", RFData: "04:A2:2B"}, guard), http.StatusCreated)
+	//a checkpoint in the body is not taken
+	forged := mod.Patrol{GPS: "12.97,77.59", RFData: "unknown", CheckpointId: checkpoint.Id.Hex(), Checkpoint: "north gate"}
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, forged, guard), http.StatusCreated)
+	//tags of another company are unknown, companies without checkpoints accept any
+	s.expect(s.do("POST", "/v1/patrol/company/"+globex, mod.Patrol{GPS: "12.97,77.59", RFData: "04:A2:2B"}, guard), http.StatusCreated)
+
+	var patrols mod.Patrols
+	rec = s.do("GET", "/v1/patrol/company/"+acme, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	p := patrols.Patrols
+	if len(p) != 3 || p[0].UnknownTag || p[0].Checkpoint != "" ||
+		p[1].Checkpoint != "north gate" || p[1].CheckpointId != checkpoint.Id.Hex() || p[1].UnknownTag ||
+		p[2].Checkpoint != "" || p[2].CheckpointId != "" || !p[2].UnknownTag {
+		t.Fatalf("unexpected patrols %+v", p)
+	}
+
+	var company mod.Company
+	rec = s.do("PATCH", "/v1/company/"+acme, patch{"version": 0, "rejectunknowntags": true}, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if !company.RejectUnknownTags {
+		t.Fatalf("unexpected company %+v", company)
+	}
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.97,77.59", RFData: "unknown"}, guard), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.97,77.59", RFData: "QR-LOBBY"}, guard), http.StatusCreated)
+
+	s.expect(s.do("DELETE", "/v1/checkpoint/"+checkpoint.Id.Hex(), nil, owner), http.StatusOK)
+	s.expect(s.do("DELETE", "/v1/checkpoint/"+checkpoint.Id.Hex(), nil, owner), http.StatusNotFound)
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.97,77.59", RFData: "04:A2:2B"}, guard), http.StatusBadRequest)
+}
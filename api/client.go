@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+//Clients set their password with the reset code sent in the invitation.
+const clientInviteExpiry = 24 * time.Hour
+
+/*
+ * A client token only reads its own company, every other user of the tenent reads any.
+ */
+func companyAllowed(claims jwt.MapClaims, companyId string) bool {
+	if claims["usertype"] != mod.CLIENT {
+		return true
+	}
+	company, _ := claims["company"].(string)
+	return company != "" && company == companyId
+}
+
+/*
+ * The client account is active, its company still exists and the tenent is not suspended.
+ */
+func isClientActive(ctx context.Context, claims jwt.MapClaims) bool {
+	phone, _ := claims["phone"].(string)
+	client, err := store.Clients.FindByPhone(ctx, phone)
+	if err != nil || !client.Active || client.Tenent != claims["tenent"].(string) || !isTenentActive(ctx, client.Tenent) {
+		return false
+	}
+	objID, err := primitive.ObjectIDFromHex(client.CompanyId)
+	if err != nil {
+		return false
+	}
+	_, err = store.Companies.FindById(ctx, client.Tenent, objID)
+	return err == nil
+}
+
+func clientTokenData(c mod.Client) *mod.ClientTokenData {
+	return &mod.ClientTokenData{
+		UserType:  mod.CLIENT,
+		Tenent:    c.Tenent,
+		CompanyId: c.CompanyId,
+		Phone:     c.Phone,
+		Name:      c.Name,
+		Group:     c.Group,
+	}
+}
+
+func loginClient(ctx context.Context, w http.ResponseWriter, r *http.Request, phone, password string) {
+	account := accountKey(mod.CLIENT, "", phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	user, err := store.Clients.FindByPhone(ctx, phone)
+	if err == nil && !user.Active {
+		err = db.ErrNotFound
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find user : %v", err)
+		checkDummyPassword(password)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
+		return
+	}
+
+	if match, _ := util.CheckPassword(user.Password, password); !match {
+		util.Log.Printf("Password did not match for : %v", phone)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
+		return
+	}
+	attempt.refund(ctx)
+
+	//only revealed to someone who knows the password.
+	if !isClientActive(ctx, jwt.MapClaims{"tenent": user.Tenent, "phone": user.Phone}) {
+		util.Log.Printf("Client access suspended : %v", phone)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Account suspended."})
+		return
+	}
+	loginSucceeded(ctx, account)
+	writeLoginToken(w, r, clientTokenData(user))
+}
+
+/*
+ * Proprietor invites a login for a company of the tenent, the client logs in once
+ * the password is set with the code sent to the phone.
+ */
+func AddClient(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.ClientInvite
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+	group, _ := claims["group"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	company, err := store.Companies.FindById(ctx, tenent, objID)
+	if err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + id})
+		return
+	}
+
+	//an empty password never matches, the client can only login after setting it.
+	client := mod.Client{
+		Tenent:      tenent,
+		Group:       group,
+		CompanyId:   id,
+		CompanyName: company.Name,
+		Name:        req.Name,
+		Phone:       req.Phone,
+		UserType:    mod.CLIENT,
+		Active:      true,
+	}
+	err = store.Clients.Create(ctx, client)
+	if err == db.ErrDuplicate {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Phone already registered as a client."})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if client, err = store.Clients.FindByPhone(ctx, req.Phone); err != nil {
+		util.Log.Printf("Unable to find client : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	msg := fmt.Sprintf("%v invites you to view the reports of %v, set your password with the code %%v, valid for %%v minutes.",
+		group, company.Name)
+	if err := sendOtp(ctx, "", req.Phone, mod.CLIENT, mod.OTP_RESET, clientInviteExpiry, 0, msg); err != nil {
+		util.Log.Printf("Unable to send client invitation : %v", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Client added, unable to send invitation, client can use forgot password."})
+		return
+	}
+
+	client.Password = ""
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(client)
+}
+
+func GetAllClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Clients.List(ctx, claims["tenent"].(string))
+	if err != nil {
+		util.Log.Printf("Unable to find clients: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range c {
+		c[i].Password = ""
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Clients{Clients: c})
+}
+
+/*
+ * Remove a client login, all its sessions are revoked.
+ */
+func DeleteClientById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := store.Clients.FindById(ctx, tenent, objID)
+	if err == nil {
+		err = store.Clients.DeleteById(ctx, tenent, objID)
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Client not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to delete client: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllSessions(ctx, mod.CLIENT, tenent, client.Phone); err != nil {
+		util.Log.Printf("Unable to revoke client tokens: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Client removed."})
+}
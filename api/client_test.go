@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+/*
+ * Invite a client for the company, set the password with the code sent and login, returns the token.
+ */
+func (s *testServer) client(ownerToken, companyId, phone string) string {
+	s.t.Helper()
+	s.expect(s.do("POST", "/v1/company/"+companyId+"/client", mod.ClientInvite{Name: "front desk", Phone: phone}, ownerToken), http.StatusCreated)
+
+	reset := mod.PasswordReset{Phone: phone, UserType: mod.CLIENT, Code: s.sms.code(phone), NewPassword: newPassword}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusOK)
+
+	rec := s.do("POST", "/v1/auth/login", mod.PasswordLogin{Phone: phone, Password: newPassword, UserType: mod.CLIENT}, "")
+	s.expect(rec, http.StatusOK)
+	return tokenCookie(s.t, rec)
+}
+
+func TestClientReadsOwnCompany(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	acme := s.company(owner, "acme")
+	globex := s.company(owner, "globex")
+	for _, id := range []string{acme, globex} {
+		s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9,77.5", RFData: "tag-1"}, guard), http.StatusCreated)
+	}
+	mine := s.incident(guard, acme)
+	other := s.incident(guard, globex)
+	s.expect(s.upload("/v1/incident/"+mine, guard, map[string]string{"door.jpg": "jpeg"}), http.StatusOK)
+	s.expect(s.upload("/v1/incident/"+other, guard, map[string]string{"gate.jpg": "jpeg"}), http.StatusOK)
+
+	s.expect(s.do("POST", "/v1/company/"+acme+"/client", mod.ClientInvite{Name: "front desk", Phone: "7777777777"}, guard), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/company/000000000000000000000000/client", mod.ClientInvite{Name: "front desk", Phone: "7777777777"}, owner), http.StatusNotFound)
+	client := s.client(owner, acme, "7777777777")
+	s.expect(s.do("POST", "/v1/company/"+globex+"/client", mod.ClientInvite{Name: "front desk", Phone: "7777777777"}, owner), http.StatusConflict)
+
+	var patrols mod.Patrols
+	rec := s.do("GET", "/v1/patrol/company/"+acme, nil, client)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	if len(patrols.Patrols) != 1 || patrols.Patrols[0].CompanyId != acme {
+		t.Fatalf("unexpected patrols %+v", patrols)
+	}
+	s.expect(s.do("GET", "/v1/patrol/company/"+globex, nil, client), http.StatusUnauthorized)
+
+	var incidents mod.Incidents
+	rec = s.do("GET", "/v1/incidents", nil, client)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &incidents)
+	if len(incidents.Incidents) != 1 || incidents.Incidents[0].Id != mine {
+		t.Fatalf("unexpected incidents %+v", incidents)
+	}
+
+	rec = s.do("GET", "/v1/incident/"+mine+"/media/door.jpg", nil, client)
+	s.expect(rec, http.StatusOK)
+	if rec.Body.String() != "jpeg" {
+		t.Fatalf("unexpected media %q", rec.Body.String())
+	}
+	s.expect(s.do("GET", "/v1/incident/"+mine+"/media/gate.jpg", nil, client), http.StatusNotFound)
+	s.expect(s.do("GET", "/v1/incident/"+other+"/media/gate.jpg", nil, client), http.StatusNotFound)
+
+	//read only
+	s.expect(s.do("GET", "/v1/companies", nil, client), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, mod.Patrol{GPS: "12.9,77.5", RFData: "tag-1"}, client), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/incident/company/"+acme, mod.Incident{Description: "x"}, client), http.StatusUnauthorized)
+	s.expect(s.do("GET", "/v1/guards", nil, client), http.StatusUnauthorized)
+}
+
+func TestClientRemoved(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	acme := s.company(owner, "acme")
+	globex := s.company(owner, "globex")
+	first := s.client(owner, acme, "7777777777")
+	second := s.client(owner, globex, "8888888888")
+
+	var clients mod.Clients
+	rec := s.do("GET", "/v1/clients", nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &clients)
+	if len(clients.Clients) != 2 || clients.Clients[0].Password != "" {
+		t.Fatalf("unexpected clients %+v", clients)
+	}
+
+	c, err := s.store.Clients.FindByPhone(context.Background(), "7777777777")
+	if err != nil {
+		t.Fatalf("find client: %v", err)
+	}
+	s.expect(s.do("DELETE", "/v1/client/"+c.Id.Hex(), nil, owner), http.StatusOK)
+	s.expect(s.do("GET", "/v1/incidents", nil, first), http.StatusUnauthorized)
+	s.expect(s.do("DELETE", "/v1/client/"+c.Id.Hex(), nil, owner), http.StatusNotFound)
+
+	//the client of a deleted company loses access
+	s.expect(s.do("GET", "/v1/incidents", nil, second), http.StatusOK)
+	s.expect(s.do("DELETE", "/v1/company/"+globex, nil, owner), http.StatusOK)
+	s.expect(s.do("GET", "/v1/incidents", nil, second), http.StatusUnauthorized)
+}
@@ -9,13 +9,9 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	db "github.com/monitor_security/db"
 	mod "github.com/monitor_security/model"
 	"github.com/monitor_security/util"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/validator.v2"
 )
 
@@ -38,22 +34,22 @@ func AddCompany(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	//a geofence set on create is held to the same rules as SetCompanyGeofence.
+	if company.Geofence != nil {
+		if err := util.ValidateGeofence(company.Geofence); err != nil {
+			util.Log.Printf("Invalid geofence :%v", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	company.Tenent = claims["tenent"].(string)
-	index := mongo.IndexModel{
-		Keys:    bson.D{{"name", 1}, {"tenent", 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err = db.CompanyDB.Indexes().CreateOne(ctx, index)
-	if err != nil {
-		util.Log.Printf("Unable to create unique index for company : %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	_, err = db.CompanyDB.InsertOne(ctx, company)
+	company.Version = 0
+	err = store.Companies.Create(ctx, company)
 	if err != nil {
 		util.Log.Printf("Unable to insert Company document : %v", err)
 		w.WriteHeader(http.StatusConflict)
@@ -81,16 +77,14 @@ func DeleteCompanyById(w http.ResponseWriter, r *http.Request) {
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	result, err := db.CompanyDB.DeleteOne(ctx, filter)
+	deleted, err := store.Companies.DeleteById(ctx, claims["tenent"].(string), objID)
 	if err != nil {
 		util.Log.Printf("Unable to find company: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(mod.DeleteResult{DeletedCount: deleted})
 }
 
 func DeleteAllCompanies(w http.ResponseWriter, r *http.Request) {
@@ -103,8 +97,7 @@ func DeleteAllCompanies(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"tenent": claims["tenent"].(string)}
-	result, err := db.CompanyDB.DeleteMany(ctx, filter)
+	deleted, err := store.Companies.DeleteAll(ctx, claims["tenent"].(string))
 
 	if err != nil {
 		util.Log.Printf("Unable to delete companies: %v", err.Error())
@@ -113,7 +106,7 @@ func DeleteAllCompanies(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(mod.DeleteResult{DeletedCount: deleted})
 
 }
 
@@ -136,10 +129,7 @@ func GetCompanyById(w http.ResponseWriter, r *http.Request) {
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	var company mod.Company
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	err = db.CompanyDB.FindOne(ctx, filter).Decode(&company)
+	company, err := store.Companies.FindById(ctx, claims["tenent"].(string), objID)
 	if err != nil {
 		util.Log.Printf("Unable to find company: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -149,6 +139,41 @@ func GetCompanyById(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(company)
 }
 
+/*
+ * Partial update of a company, only the fields sent are changed.
+ */
+func UpdateCompany(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	version, fields, err := decodePatch(r, mod.Company{}, "name", "address", "phone", "image", "rejectunknowntags")
+	if err != nil {
+		writeBadPatch(w, err)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	company, err := store.Companies.Update(ctx, claims["tenent"].(string), objID, version, fields)
+	if err != nil {
+		writeUpdateError(w, err, "Company", id)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
+
 func GetAllCompanies(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header()["Date"] = nil
@@ -159,22 +184,12 @@ func GetAllCompanies(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"tenent": claims["tenent"].(string)}
-	cursor, err := db.CompanyDB.Find(ctx, filter)
-	defer cursor.Close(ctx)
-
+	c, err := store.Companies.List(ctx, claims["tenent"].(string))
 	if err != nil {
 		util.Log.Printf("Unable to find companies: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	c := []mod.Company{}
-	for cursor.Next(ctx) {
-		tmp := mod.Company{}
-		cursor.Decode(&tmp)
-		c = append(c, tmp)
-	}
 	var companies mod.Companies
 	companies.Companies = c
 
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func TestCompanyCrud(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+
+	id := s.company(owner, "acme")
+	s.company(owner, "globex")
+	s.expect(s.do("POST", "/v1/company", mod.Company{Name: "acme", Address: "x", Phone: "0123456789"}, owner), http.StatusConflict)
+	s.expect(s.do("POST", "/v1/company", mod.Company{Name: "", Address: "x", Phone: "0123456789"}, owner), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/company", mod.Company{Name: "initech", Address: "x", Phone: "0123456789"}, guard), http.StatusUnauthorized)
+
+	var company mod.Company
+	rec := s.do("GET", "/v1/company/"+id, nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if company.Name != "acme" || company.Tenent != tenent {
+		t.Fatalf("unexpected company %+v", company)
+	}
+
+	var result mod.DeleteResult
+	rec = s.do("DELETE", "/v1/company/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &result)
+	if result.DeletedCount != 1 {
+		t.Fatalf("company not deleted")
+	}
+	s.expect(s.do("GET", "/v1/company/"+id, nil, owner), http.StatusBadRequest)
+
+	rec = s.do("DELETE", "/v1/company", nil, owner)
+	s.expect(rec, http.StatusAccepted)
+	decode(t, rec, &result)
+	if result.DeletedCount != 1 {
+		t.Fatalf("expected 1 company deleted, got %v", result.DeletedCount)
+	}
+}
+
+func TestCompanyCrossTenentIsolation(t *testing.T) {
+	s := newTestServer(t)
+	ownerA, _ := s.proprietor("1111111111", "alpha")
+	ownerB, tenentB := s.proprietor("3333333333", "beta")
+	guardB := s.guard(ownerB, tenentB, "4444444444")
+	id := s.company(ownerA, "acme")
+
+	s.expect(s.do("GET", "/v1/company/"+id, nil, ownerB), http.StatusBadRequest)
+	s.expect(s.do("GET", "/v1/company/"+id, nil, guardB), http.StatusBadRequest)
+
+	var companies mod.Companies
+	rec := s.do("GET", "/v1/companies", nil, guardB)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &companies)
+	if len(companies.Companies) != 0 {
+		t.Fatalf("companies of another tenent listed")
+	}
+
+	s.expect(s.do("DELETE", "/v1/company", nil, ownerB), http.StatusAccepted)
+	s.expect(s.do("DELETE", "/v1/company/"+id, nil, ownerB), http.StatusOK)
+	s.expect(s.do("GET", "/v1/company/"+id, nil, ownerA), http.StatusOK)
+}
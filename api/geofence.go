@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ * Check a guard's submission against the company geofence, returns the meters outside
+ * of it ( zero inside ). A response is written and false returned when the submission has
+ * no location or the company rejects submissions from outside. Proprietor side users and
+ * companies without a valid geofence are not checked.
+ */
+func checkSite(w http.ResponseWriter, claims jwt.MapClaims, company mod.Company, location *mod.GeoPoint) (float64, bool) {
+	if company.Geofence == nil || claims["usertype"] != mod.GUARD {
+		return 0, true
+	}
+	if location == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "gps is required at " + company.Name})
+		return 0, false
+	}
+	d, err := util.GeofenceDistance(*company.Geofence, location.Coordinates[1], location.Coordinates[0])
+	if err != nil {
+		//a broken stored geofence must not block every guard of the company.
+		util.Log.Printf("Invalid geofence of %v : %v", company.Name, err.Error())
+		return 0, true
+	}
+	distance := math.Round(d)
+	if distance > 0 && company.Geofence.Enforce {
+		util.Log.Printf("Rejected %v, %v m outside %v", claims["phone"], distance, company.Name)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: fmt.Sprintf("Location is %v m outside %v.", distance, company.Name)})
+		return distance, false
+	}
+	return distance, true
+}
+
+/*
+ * Set the site boundary of a company, a circle or a polygon.
+ */
+func SetCompanyGeofence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.Geofence
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := util.ValidateGeofence(&req); err != nil {
+		util.Log.Printf("Invalid geofence :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	setGeofence(w, r, &req)
+}
+
+func DeleteCompanyGeofence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	setGeofence(w, r, nil)
+}
+
+func setGeofence(w http.ResponseWriter, r *http.Request, g *mod.Geofence) {
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	company, err := store.Companies.SetGeofence(ctx, claims["tenent"].(string), objID, g)
+	if err != nil {
+		writeUpdateError(w, err, "Company", id)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(company)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGeofence(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	id := s.company(owner, "acme")
+
+	circle := mod.Geofence{Center: &mod.GeoPoint{Type: "Point", Coordinates: []float64{77.5946, 12.9716}}, Radius: 200}
+	s.expect(s.do("PUT", "/v1/company/"+id+"/geofence", circle, auditor), http.StatusUnauthorized)
+	s.expect(s.do("PUT", "/v1/company/000000000000000000000000/geofence", circle, owner), http.StatusNotFound)
+	for _, g := range []mod.Geofence{
+		{},
+		{Center: circle.Center},
+		{Center: &mod.GeoPoint{Type: "Point", Coordinates: []float64{12.9716, 97.5946}}, Radius: 200},
+		{Polygon: [][]float64{{77.59, 12.97}, {77.60, 12.97}}},
+		{Center: circle.Center, Radius: 200, Polygon: [][]float64{{77.59, 12.97}, {77.60, 12.97}, {77.60, 12.98}}},
+	} {
+		s.expect(s.do("PUT", "/v1/company/"+id+"/geofence", g, owner), http.StatusBadRequest)
+	}
+
+	var company mod.Company
+	rec := s.do("PUT", "/v1/company/"+id+"/geofence", circle, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if company.Geofence == nil || company.Geofence.Radius != 200 || company.Version != 1 {
+		t.Fatalf("unexpected company %+v", company)
+	}
+
+	//about 934 m north of the center, 734 m outside.
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9717,77.5946", RFData: "TAG-1"}, guard), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9800,77.5946", RFData: "TAG-1"}, guard), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9800,77.5946", RFData: "TAG-1"}, owner), http.StatusCreated)
+
+	var patrols mod.Patrols
+	rec = s.do("GET", "/v1/patrol/company/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	if p := patrols.Patrols; len(p) != 3 || p[0].OutsideSite || p[0].SiteDistance != 0 ||
+		!p[1].OutsideSite || p[1].SiteDistance < 700 || p[1].SiteDistance > 770 || p[2].OutsideSite {
+		t.Fatalf("unexpected patrols %+v", p)
+	}
+
+	s.expect(s.do("POST", "/v1/incident/company/"+id, mod.Incident{Description: "broken lock"}, guard), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/incident/company/"+id, mod.Incident{Description: "broken lock", GPS: "north"}, guard), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/incident/company/"+id, mod.Incident{Description: "broken lock", GPS: "12.9800,77.5946"}, guard), http.StatusCreated)
+	s.incident(owner, id)
+	var incidents mod.Incidents
+	rec = s.do("GET", "/v1/incidents", nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &incidents)
+	flagged := 0
+	for _, i := range incidents.Incidents {
+		if i.OutsideSite && i.SiteDistance > 700 && i.Location != nil {
+			flagged++
+		}
+	}
+	if len(incidents.Incidents) != 2 || flagged != 1 {
+		t.Fatalf("unexpected incidents %+v", incidents)
+	}
+
+	//rejected once enforced, the polygon is a box around the center.
+	box := mod.Geofence{Polygon: [][]float64{{77.59, 12.97}, {77.60, 12.97}, {77.60, 12.975}, {77.59, 12.975}, {77.59, 12.97}}, Enforce: true}
+	rec = s.do("PUT", "/v1/company/"+id+"/geofence", box, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if len(company.Geofence.Polygon) != 4 {
+		t.Fatalf("closing corner kept %+v", company.Geofence)
+	}
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9716,77.5946", RFData: "TAG-1"}, guard), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9800,77.5946", RFData: "TAG-1"}, guard), http.StatusForbidden)
+	s.expect(s.do("POST", "/v1/incident/company/"+id, mod.Incident{Description: "x", GPS: "12.9800,77.5946"}, guard), http.StatusForbidden)
+
+	s.expect(s.do("DELETE", "/v1/company/"+id+"/geofence", nil, owner), http.StatusOK)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.9800,77.5946", RFData: "TAG-1"}, guard), http.StatusCreated)
+	s.incident(guard, id)
+
+	//a geofence given on create is validated, the version is not taken from the body.
+	for _, g := range []*mod.Geofence{
+		{Center: &mod.GeoPoint{Type: "Point"}, Radius: 200, Enforce: true},
+		{Polygon: [][]float64{{77.59}, {77.60, 12.97}, {77.60, 12.98}}},
+		{Polygon: [][]float64{}, Enforce: true},
+	} {
+		c := mod.Company{Name: "globex", Address: "1 Main St", Phone: "0123456789", Geofence: g}
+		s.expect(s.do("POST", "/v1/company", c, owner), http.StatusBadRequest)
+	}
+	c := mod.Company{Name: "globex", Address: "1 Main St", Phone: "0123456789", Geofence: &circle, Version: 7}
+	s.expect(s.do("POST", "/v1/company", c, owner), http.StatusCreated)
+	var globex primitive.ObjectID
+	companies, _ := s.store.Companies.List(context.Background(), tenent)
+	for _, stored := range companies {
+		if stored.Name != "globex" {
+			continue
+		}
+		if stored.Version != 0 || stored.Geofence == nil {
+			t.Fatalf("unexpected company %+v", stored)
+		}
+		globex = stored.Id
+	}
+
+	//a broken stored geofence is not checked instead of failing every scan.
+	broken := &mod.Geofence{Center: &mod.GeoPoint{Type: "Point"}, Enforce: true}
+	if _, err := s.store.Companies.SetGeofence(context.Background(), tenent, globex, broken); err != nil {
+		t.Fatalf("set geofence: %v", err)
+	}
+	s.expect(s.do("POST", "/v1/patrol/company/"+globex.Hex(), mod.Patrol{GPS: "12.9800,77.5946", RFData: "TAG-1"}, guard), http.StatusCreated)
+}
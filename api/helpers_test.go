@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/monitor_security/config"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/notify"
+	"github.com/monitor_security/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "passw0rd123"
+
+type testServer struct {
+	t      *testing.T
+	store  *db.Store
+	router *mux.Router
+	sms    *captureSender
+}
+
+/*
+ * captureSender keeps the last message per phone so tests can read codes back.
+ */
+type captureSender struct {
+	mu   sync.Mutex
+	msgs map[string]string
+}
+
+func (c *captureSender) Send(phone, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs[phone] = message
+	return nil
+}
+
+var codeRe = regexp.MustCompile(`\b([0-9]{8}|[0-9]{6})\b`)
+
+func (c *captureSender) code(phone string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return codeRe.FindString(c.msgs[phone])
+}
+
+func newTestServer(t *testing.T) *testServer {
+	util.InitAuth("test-key-0123456789-0123456789-0123456789", time.Hour)
+	util.PasswordCost = bcrypt.MinCost
+	cfg := config.Default()
+	cfg.MediaDir = t.TempDir()
+	config.Current = cfg
+
+	sms := &captureSender{msgs: map[string]string{}}
+	notify.SMS = sms
+
+	st := db.NewMemoryStore()
+	return &testServer{t: t, store: st, router: NewRouter(st), sms: sms}
+}
+
+func (s *testServer) do(method, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.serve(s.request(method, path, body, token))
+}
+
+func (s *testServer) request(method, path string, body interface{}, token string) *http.Request {
+	s.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			s.t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return req
+}
+
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) expect(rec *httptest.ResponseRecorder, status int) {
+	s.t.Helper()
+	if rec.Code != status {
+		s.t.Fatalf("expected status %v, got %v : %v", status, rec.Code, rec.Body.String())
+	}
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decode response: %v : %v", err, rec.Body.String())
+	}
+}
+
+func tokenCookie(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "token" && c.Value != "" {
+			return c.Value
+		}
+	}
+	t.Fatalf("no token cookie in response : %v", rec.Body.String())
+	return ""
+}
+
+/*
+ * Register and login a proprietor, returns the token and tenent.
+ */
+func (s *testServer) proprietor(phone, group string) (string, string) {
+	s.t.Helper()
+	rec := s.do("POST", "/v1/auth/register-proprietor", mod.Proprietor{
+		Group: group, Phone: phone, Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusCreated)
+
+	rec = s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: phone, Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+
+	p, err := s.store.Proprietors.FindByPhone(context.Background(), phone)
+	if err != nil {
+		s.t.Fatalf("proprietor not stored: %v", err)
+	}
+	return tokenCookie(s.t, rec), p.Tenent
+}
+
+/*
+ * Add, register and login a guard in the proprietor's tenent, returns the guard token.
+ */
+func (s *testServer) guard(ownerToken, tenent, phone string) string {
+	s.t.Helper()
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: phone}, ownerToken), http.StatusCreated)
+
+	rec := s.do("POST", "/v1/auth/register-guard", mod.GuardRegistration{
+		Tenent: tenent, Name: "guard " + phone, Phone: phone, Password: testPassword, UserType: mod.GUARD,
+		Code: s.sms.code(phone),
+	}, "")
+	s.expect(rec, http.StatusCreated)
+
+	rec = s.do("POST", "/v1/auth/login-guard-password", mod.GuardPasswordLogin{
+		Tenent: tenent, Phone: phone, Password: testPassword, UserType: mod.GUARD,
+	}, "")
+	s.expect(rec, http.StatusOK)
+	return tokenCookie(s.t, rec)
+}
+
+/*
+ * Create a company and return its id.
+ */
+func (s *testServer) company(token, name string) string {
+	s.t.Helper()
+	s.expect(s.do("POST", "/v1/company", mod.Company{Name: name, Address: "1 Main St", Phone: "0123456789"}, token), http.StatusCreated)
+
+	var companies mod.Companies
+	rec := s.do("GET", "/v1/companies", nil, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &companies)
+	for _, c := range companies.Companies {
+		if c.Name == name {
+			return c.Id.Hex()
+		}
+	}
+	s.t.Fatalf("company %v not listed", name)
+	return ""
+}
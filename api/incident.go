@@ -8,18 +8,16 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	db "github.com/monitor_security/db"
+	"github.com/monitor_security/config"
 	mod "github.com/monitor_security/model"
 	"github.com/monitor_security/util"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/validator.v2"
 )
 
@@ -54,15 +52,23 @@ func CreateIncident(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	incident.Location = nil
+	if incident.GPS != "" {
+		lat, lng, err := util.ParseLatLng(incident.GPS)
+		if err != nil {
+			util.Log.Printf("Invalid gps %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "gps: " + err.Error()})
+			return
+		}
+		incident.Location = util.GeoPoint(lat, lng)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	//Validate the company and fetch company name
-	var company mod.Company
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	err = db.CompanyDB.FindOne(ctx, filter).Decode(&company)
+	company, err := store.Companies.FindById(ctx, claims["tenent"].(string), objID)
 	if err != nil {
 		util.Log.Printf("Unable to find company: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -75,6 +81,13 @@ func CreateIncident(w http.ResponseWriter, r *http.Request) {
 		incident.Name = "Proprietor"
 	}
 
+	distance, ok := checkSite(w, claims, company, incident.Location)
+	if !ok {
+		return
+	}
+	incident.OutsideSite = distance > 0
+	incident.SiteDistance = distance
+
 	incident.Tenent = claims["tenent"].(string)
 	incident.Phone = claims["phone"].(string)
 	t := time.Now()
@@ -84,27 +97,16 @@ func CreateIncident(w http.ResponseWriter, r *http.Request) {
 	incident.CompanyName = company.Name
 	incident.Media = []string{}
 
-	//Add Patrol Data
-	index := mongo.IndexModel{
-		Keys:    bson.D{{"_id", 1}, {"tenent", 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err = db.IncidentDB.Indexes().CreateOne(ctx, index)
-	if err != nil {
-		util.Log.Printf("Unable to create unique index for company : %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: fmt.Errorf("Unable to create unique index: %v", err.Error()).Error()})
-		return
-	}
-	result, err := db.IncidentDB.InsertOne(ctx, incident)
+	//Add Incident Data
+	insertedId, err := store.Incidents.Create(ctx, incident)
 	if err != nil {
 		util.Log.Printf("Unable to insert Incident document : %v", err)
 		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: fmt.Errorf("Unable to add incident data: %v", err.Error()).Error()})
 		return
 	}
-	json.NewEncoder(w).Encode(result)
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(mod.InsertResult{InsertedID: insertedId})
 }
 
 func UpdateIncident(w http.ResponseWriter, r *http.Request) {
@@ -126,6 +128,18 @@ func UpdateIncident(w http.ResponseWriter, r *http.Request) {
 	claims := dat.(jwt.MapClaims)
 	tenent := claims["tenent"].(string)
 
+	//make sure the incident belongs to the tenent before saving any file.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = store.Incidents.FindById(ctx, tenent, objID)
+	if err != nil {
+		util.Log.Printf("Unable to find Incident: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to find Incident: " + id})
+		return
+	}
+
 	//save files.
 	err = r.ParseMultipartForm(16777216) // 16MB grab the multipart form
 	if err != nil {
@@ -145,14 +159,14 @@ func UpdateIncident(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintln(w, err)
 			return
 		}
-		pwd, _ := os.Getwd()
-		dir := path.Join(pwd, "media", id)
+		dir := filepath.Join(config.Current.MediaDir, id)
 		util.Log.Println("dir is , ", dir)
 		err = os.MkdirAll(dir, os.ModePerm)
 
 		if err == nil {
-			//fileName := dir + "/" + files[i].Filename
-			fileName := path.Join(dir, files[i].Filename)
+			//only the base name, the client controls the rest of the path.
+			name := filepath.Base(files[i].Filename)
+			fileName := filepath.Join(dir, name)
 			util.Log.Println("fileName is , ", fileName)
 			out, err := os.Create(fileName)
 			defer out.Close()
@@ -164,27 +178,21 @@ func UpdateIncident(w http.ResponseWriter, r *http.Request) {
 				if err != nil {
 					util.Log.Printf("Error Copying file :%v", err.Error())
 				} else {
-					data = append(data, path.Join("media", id, files[i].Filename))
+					data = append(data, path.Join("media", id, name))
 				}
 			}
 
 		} else {
 			util.Log.Printf("Error Creating directory :%v", err.Error())
 		}
+	}
 
-		//update the incident with image files.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		filter := bson.M{"_id": objID, "tenent": tenent}
-		update := bson.M{"$addToSet": bson.M{"media": bson.M{"$each": data}}}
-		result := db.IncidentDB.FindOneAndUpdate(ctx, filter, update)
-		if result.Err() != nil {
-			util.Log.Printf("Unable to update the list of image files to incident: %v", result.Err().Error())
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
+	//update the incident with image files.
+	err = store.Incidents.AddMedia(ctx, tenent, objID, data)
+	if err != nil {
+		util.Log.Printf("Unable to update the list of image files to incident: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Files uploaded successfully."})
@@ -200,22 +208,20 @@ func GetAllIncidents(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"tenent": claims["tenent"].(string)}
-	cursor, err := db.IncidentDB.Find(ctx, filter)
-	defer cursor.Close(ctx)
-
+	var c []mod.Incident
+	var err error
+	//a client only sees the incidents of its own company.
+	if claims["usertype"] == mod.CLIENT {
+		company, _ := claims["company"].(string)
+		c, err = store.Incidents.ListByCompany(ctx, claims["tenent"].(string), company)
+	} else {
+		c, err = store.Incidents.List(ctx, claims["tenent"].(string))
+	}
 	if err != nil {
 		util.Log.Printf("Unable to find incidents: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	c := []mod.Incident{}
-	for cursor.Next(ctx) {
-		tmp := mod.Incident{}
-		cursor.Decode(&tmp)
-		c = append(c, tmp)
-	}
 	var incidents mod.Incidents
 	incidents.Incidents = c
 
@@ -242,11 +248,9 @@ func DeleteIncidentById(w http.ResponseWriter, r *http.Request) {
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	result := db.IncidentDB.FindOneAndDelete(ctx, filter)
-	if result.Err() != nil {
-		util.Log.Printf("Unable to find Incident: %v", result.Err().Error())
+	err = store.Incidents.DeleteById(ctx, claims["tenent"].(string), objID)
+	if err != nil {
+		util.Log.Printf("Unable to find Incident: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to find Incident: " + id})
 		return
@@ -256,3 +260,41 @@ func DeleteIncidentById(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Successfully Incident Deleted"})
 
 }
+
+/*
+ * Serve a media file of an incident to a user allowed to read the incident.
+ */
+func GetIncidentMedia(w http.ResponseWriter, r *http.Request) {
+	w.Header()["Date"] = nil
+
+	params := mux.Vars(r)
+	id := params["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	incident, err := store.Incidents.FindById(ctx, claims["tenent"].(string), objID)
+	if err != nil || !companyAllowed(claims, incident.CompanyId) {
+		util.Log.Printf("Incident %v not found for : %v", id, claims["phone"])
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	//only files recorded on the incident are served, never an arbitrary path.
+	file := filepath.Base(params["File"])
+	for _, m := range incident.Media {
+		if m == path.Join("media", id, file) {
+			http.ServeFile(w, r, filepath.Join(config.Current.MediaDir, id, file))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
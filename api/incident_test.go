@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monitor_security/config"
+	mod "github.com/monitor_security/model"
+)
+
+func (s *testServer) upload(path, token string, files map[string]string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("files", name)
+		if err != nil {
+			s.t.Fatalf("create form file: %v", err)
+		}
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("PUT", path, &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) incident(token, companyId string) string {
+	s.t.Helper()
+	var result mod.InsertResult
+	rec := s.do("POST", "/v1/incident/company/"+companyId, mod.Incident{Description: "broken lock"}, token)
+	s.expect(rec, http.StatusCreated)
+	decode(s.t, rec, &result)
+	return result.InsertedID
+}
+
+func TestIncident(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	companyId := s.company(owner, "acme")
+
+	id := s.incident(guard, companyId)
+	s.expect(s.do("POST", "/v1/incident/company/000000000000000000000000", mod.Incident{}, guard), http.StatusBadRequest)
+
+	rec := s.upload("/v1/incident/"+id, guard, map[string]string{"door.jpg": "jpeg"})
+	s.expect(rec, http.StatusOK)
+	if _, err := os.Stat(filepath.Join(config.Current.MediaDir, id, "door.jpg")); err != nil {
+		t.Fatalf("media not saved: %v", err)
+	}
+
+	var incidents mod.Incidents
+	rec = s.do("GET", "/v1/incidents", nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &incidents)
+	if len(incidents.Incidents) != 1 {
+		t.Fatalf("expected 1 incident, got %+v", incidents)
+	}
+	i := incidents.Incidents[0]
+	if i.CompanyName != "acme" || len(i.Media) != 1 || i.Media[0] != filepath.Join("media", id, "door.jpg") {
+		t.Fatalf("unexpected incident %+v", i)
+	}
+
+	//media is served with a token only, never as a static file.
+	rec = s.do("GET", "/v1/incident/"+id+"/media/door.jpg", nil, owner)
+	s.expect(rec, http.StatusOK)
+	if rec.Body.String() != "jpeg" {
+		t.Fatalf("unexpected media %q", rec.Body.String())
+	}
+	s.expect(s.do("GET", "/v1/incident/"+id+"/media/door.jpg", nil, ""), http.StatusUnauthorized)
+	rec = s.do("GET", "/media/"+id+"/door.jpg", nil, "")
+	if rec.Code == http.StatusOK || rec.Body.String() == "jpeg" {
+		t.Fatalf("media served without a token: %v %q", rec.Code, rec.Body.String())
+	}
+
+	//several files at once, a directory in the name is dropped.
+	rec = s.upload("/v1/incident/"+id, guard, map[string]string{"../../gate.jpg": "gate", "window.jpg": "window"})
+	s.expect(rec, http.StatusOK)
+	rec = s.do("GET", "/v1/incidents", nil, owner)
+	decode(t, rec, &incidents)
+	media := map[string]bool{}
+	for _, m := range incidents.Incidents[0].Media {
+		media[m] = true
+	}
+	for _, name := range []string{"door.jpg", "gate.jpg", "window.jpg"} {
+		if !media[filepath.Join("media", id, name)] {
+			t.Fatalf("%v not recorded in %+v", name, incidents.Incidents[0].Media)
+		}
+	}
+	if len(media) != 3 {
+		t.Fatalf("unexpected media %+v", incidents.Incidents[0].Media)
+	}
+	rec = s.do("GET", "/v1/incident/"+id+"/media/gate.jpg", nil, owner)
+	s.expect(rec, http.StatusOK)
+	if rec.Body.String() != "gate" {
+		t.Fatalf("unexpected media %q", rec.Body.String())
+	}
+
+	s.expect(s.do("DELETE", "/v1/incident/"+id, nil, guard), http.StatusUnauthorized)
+	s.expect(s.do("DELETE", "/v1/incident/"+id, nil, owner), http.StatusOK)
+	s.expect(s.do("DELETE", "/v1/incident/"+id, nil, owner), http.StatusBadRequest)
+}
+
+func TestIncidentCrossTenentIsolation(t *testing.T) {
+	s := newTestServer(t)
+	ownerA, _ := s.proprietor("1111111111", "alpha")
+	ownerB, tenentB := s.proprietor("3333333333", "beta")
+	guardB := s.guard(ownerB, tenentB, "4444444444")
+	id := s.incident(ownerA, s.company(ownerA, "acme"))
+
+	s.expect(s.upload("/v1/incident/"+id, guardB, map[string]string{"evil.jpg": "x"}), http.StatusBadRequest)
+	if _, err := os.Stat(filepath.Join(config.Current.MediaDir, id)); !os.IsNotExist(err) {
+		t.Fatalf("media written for another tenent's incident")
+	}
+
+	var incidents mod.Incidents
+	rec := s.do("GET", "/v1/incidents", nil, guardB)
+	decode(t, rec, &incidents)
+	if len(incidents.Incidents) != 0 {
+		t.Fatalf("incidents of another tenent listed")
+	}
+	s.expect(s.do("DELETE", "/v1/incident/"+id, nil, ownerB), http.StatusBadRequest)
+	s.expect(s.do("DELETE", "/v1/incident/"+id, nil, ownerA), http.StatusOK)
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/notify"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	inviteDigits      = 8
+	inviteExpiry      = 72 * time.Hour
+	inviteMaxAttempts = 5
+	inviteCooldown    = time.Minute
+)
+
+/*
+ * Issue a new invitation code for the guard and send it, any previous code stops working.
+ */
+func sendInvitation(ctx context.Context, tenent, group, phone string) (mod.Invitation, error) {
+	code, err := util.GenerateCode(inviteDigits)
+	if err != nil {
+		return mod.Invitation{}, err
+	}
+
+	t := time.Now()
+	inv := mod.Invitation{
+		Tenent:  tenent,
+		Phone:   phone,
+		Code:    util.HashCode(code),
+		Created: t,
+		Expires: t.Add(inviteExpiry),
+	}
+	if err := store.Invitations.Save(ctx, inv); err != nil {
+		return inv, err
+	}
+	if inv, err = store.Invitations.Find(ctx, tenent, phone); err != nil {
+		return inv, err
+	}
+	inv.Status = invitationStatus(inv)
+
+	msg := fmt.Sprintf("You are invited to join %v as a guard, your registration code is %v, valid for %v hours.",
+		group, code, int(inviteExpiry.Hours()))
+	return inv, notify.SMS.Send(phone, msg)
+}
+
+func invitationStatus(inv mod.Invitation) string {
+	if time.Now().After(inv.Expires) {
+		return "expired"
+	}
+	return "pending"
+}
+
+/*
+ * Check the invitation code of a registering guard, the code is single use and is
+ * burnt after too many wrong attempts.
+ */
+func acceptInvitation(ctx context.Context, tenent, phone, code string) error {
+	inv, err := store.Invitations.Find(ctx, tenent, phone)
+	if err != nil {
+		return err
+	}
+	if inv.Used || time.Now().After(inv.Expires) {
+		return fmt.Errorf("invitation used or expired")
+	}
+	//the attempt is counted before comparing, so parallel guesses can not pass the limit.
+	if _, err := store.Invitations.CountAttempt(ctx, inv.Id, inviteMaxAttempts); err != nil {
+		return fmt.Errorf("invitation used or out of attempts: %v", err)
+	}
+	if !util.CheckCode(inv.Code, code) {
+		return fmt.Errorf("wrong invitation code")
+	}
+	return store.Invitations.Consume(ctx, inv.Id)
+}
+
+/*
+ * List invitations of the tenent which were not used yet.
+ */
+func GetAllInvitations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Invitations.List(ctx, claims["tenent"].(string))
+	if err != nil {
+		util.Log.Printf("Unable to find invitations: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range c {
+		c[i].Status = invitationStatus(c[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Invitations{Invitations: c})
+}
+
+/*
+ * Send a new code for an invitation, the guard must not be registered yet.
+ */
+func ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inv, err := store.Invitations.FindById(ctx, tenent, objID)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Invitation not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find invitation: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	guard, err := store.Guards.FindByPhone(ctx, tenent, inv.Phone)
+	if err != nil || guard.Registered || guard.Deleted {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Guard already registered or removed."})
+		return
+	}
+	if time.Since(inv.Created) < inviteCooldown {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Invitation recently sent, try again later."})
+		return
+	}
+
+	inv, err = sendInvitation(ctx, tenent, guard.Group, inv.Phone)
+	if err != nil {
+		util.Log.Printf("Unable to send invitation : %v", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to send invitation."})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(inv)
+}
+
+/*
+ * Revoke a pending invitation, the guard which was not registered yet is removed.
+ */
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	inv, err := store.Invitations.FindById(ctx, tenent, objID)
+	if err == nil && inv.Used {
+		err = db.ErrNotFound
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Invitation not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find invitation: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := store.Invitations.DeleteById(ctx, tenent, objID); err != nil {
+		util.Log.Printf("Unable to delete invitation: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if guard, err := store.Guards.FindByPhone(ctx, tenent, inv.Phone); err == nil && !guard.Registered {
+		if _, err := store.Guards.DeleteById(ctx, tenent, guard.Id); err != nil {
+			util.Log.Printf("Unable to delete invited guard: %v", err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Invitation revoked."})
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	mod "github.com/monitor_security/model"
+)
+
+func (s *testServer) invitations(token string) []mod.Invitation {
+	s.t.Helper()
+	rec := s.do("GET", "/v1/invitations", nil, token)
+	s.expect(rec, http.StatusOK)
+	var c mod.Invitations
+	decode(s.t, rec, &c)
+	return c.Invitations
+}
+
+func registration(tenent, phone, code string) mod.GuardRegistration {
+	return mod.GuardRegistration{
+		Tenent: tenent, Name: "guard " + phone, Phone: phone, Password: testPassword, UserType: mod.GUARD, Code: code,
+	}
+}
+
+func TestAddGuardSendsInvitation(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+
+	rec := s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner)
+	s.expect(rec, http.StatusCreated)
+	var inv mod.Invitation
+	decode(t, rec, &inv)
+	if inv.Phone != "2222222222" || inv.Status != "pending" {
+		t.Fatalf("unexpected invitation %+v", inv)
+	}
+	code := s.sms.code("2222222222")
+	if len(code) != 8 {
+		t.Fatalf("invitation code not sent")
+	}
+
+	//the old default password no longer works
+	rec = s.do("POST", "/v1/auth/login-guard-password", mod.GuardPasswordLogin{
+		Tenent: tenent, Phone: "2222222222", Password: "123456789", UserType: mod.GUARD,
+	}, "")
+	s.expect(rec, http.StatusUnauthorized)
+
+	if c := s.invitations(owner); len(c) != 1 || c[0].Id != inv.Id {
+		t.Fatalf("unexpected invitations %+v", c)
+	}
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", code), ""), http.StatusCreated)
+	if c := s.invitations(owner); len(c) != 0 {
+		t.Fatalf("used invitation still listed %+v", c)
+	}
+}
+
+func TestInvitationCodeAttempts(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusCreated)
+	code := s.sms.code("2222222222")
+
+	wrong := "00000000"
+	if code == wrong {
+		wrong = "11111111"
+	}
+	for i := 0; i < inviteMaxAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", wrong), ""), http.StatusBadRequest)
+	}
+	//burnt after too many attempts
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", code), ""), http.StatusBadRequest)
+
+	//another tenent's code does not register the guard here
+	other, otherTenent := s.proprietor("3333333333", "beta")
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, other), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", s.sms.code("2222222222")), ""), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(otherTenent, "2222222222", s.sms.code("2222222222")), ""), http.StatusCreated)
+}
+
+func TestInvitationParallelGuesses(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusCreated)
+	code := s.sms.code("2222222222")
+	wrong := "00000000"
+	if code == wrong {
+		wrong = "11111111"
+	}
+
+	//guesses racing each other are all counted against the code
+	var wg sync.WaitGroup
+	for i := 0; i < 4*inviteMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(s.request("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", wrong), ""))
+		}()
+	}
+	wg.Wait()
+	inv, err := s.store.Invitations.Find(context.Background(), tenent, "2222222222")
+	if err != nil || inv.Attempts != inviteMaxAttempts {
+		t.Fatalf("unexpected attempts %v %v", inv.Attempts, err)
+	}
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", code), ""), http.StatusBadRequest)
+}
+
+func TestResendInvitation(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	rec := s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner)
+	s.expect(rec, http.StatusCreated)
+	var inv mod.Invitation
+	decode(t, rec, &inv)
+	first := s.sms.code("2222222222")
+
+	s.expect(s.do("POST", "/v1/invitation/"+inv.Id.Hex()+"/resend", nil, owner), http.StatusTooManyRequests)
+
+	//age the invitation past the cooldown
+	stored, _ := s.store.Invitations.FindById(context.Background(), tenent, inv.Id)
+	stored.Created = time.Now().Add(-2 * inviteCooldown)
+	s.store.Invitations.Save(context.Background(), stored)
+
+	s.expect(s.do("POST", "/v1/invitation/"+inv.Id.Hex()+"/resend", nil, owner), http.StatusOK)
+	second := s.sms.code("2222222222")
+
+	//only the latest code is valid
+	if first != second {
+		s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", first), ""), http.StatusBadRequest)
+	}
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", second), ""), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/invitation/"+inv.Id.Hex()+"/resend", nil, owner), http.StatusConflict)
+
+	//other tenents can not resend
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("POST", "/v1/invitation/"+inv.Id.Hex()+"/resend", nil, other), http.StatusNotFound)
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	rec := s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner)
+	s.expect(rec, http.StatusCreated)
+	var inv mod.Invitation
+	decode(t, rec, &inv)
+	code := s.sms.code("2222222222")
+
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("DELETE", "/v1/invitation/"+inv.Id.Hex(), nil, other), http.StatusNotFound)
+
+	s.expect(s.do("DELETE", "/v1/invitation/"+inv.Id.Hex(), nil, owner), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/register-guard", registration(tenent, "2222222222", code), ""), http.StatusBadRequest)
+	if c := s.invitations(owner); len(c) != 0 {
+		t.Fatalf("revoked invitation still listed %+v", c)
+	}
+
+	//the guard can be invited again
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusCreated)
+}
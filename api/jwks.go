@@ -0,0 +1,20 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/monitor_security/util"
+)
+
+/*
+ * Public token verification keys, lets other services verify tokens without a shared secret.
+ */
+func GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header()["Date"] = nil
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(util.JWKS())
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+)
+
+func (s *testServer) jwks() mod.JWKS {
+	s.t.Helper()
+	rec := s.do("GET", "/.well-known/jwks.json", nil, "")
+	s.expect(rec, http.StatusOK)
+	var set mod.JWKS
+	decode(s.t, rec, &set)
+	return set
+}
+
+func b64Int(t *testing.T, v string) *big.Int {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		t.Fatalf("invalid jwk value %v: %v", v, err)
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+/*
+ * Verify the token the way an external service would, using only the JWKS.
+ */
+func verifyWithJWKS(t *testing.T, set mod.JWKS, token string) *jwt.Token {
+	t.Helper()
+	tok, err := jwt.Parse(token, func(tk *jwt.Token) (interface{}, error) {
+		for _, k := range set.Keys {
+			if k.Kid != tk.Header["kid"] {
+				continue
+			}
+			if k.Kty == "RSA" {
+				return &rsa.PublicKey{N: b64Int(t, k.N), E: int(b64Int(t, k.E).Int64())}, nil
+			}
+			return &ecdsa.PublicKey{Curve: elliptic.P256(), X: b64Int(t, k.X), Y: b64Int(t, k.Y)}, nil
+		}
+		return nil, jwt.ErrInvalidKey
+	})
+	if err != nil || !tok.Valid {
+		t.Fatalf("token not verified with jwks: %v", err)
+	}
+	return tok
+}
+
+func TestJWKSEmptyForHS256(t *testing.T) {
+	s := newTestServer(t)
+	if set := s.jwks(); len(set.Keys) != 0 {
+		t.Fatalf("symmetric key published: %+v", set)
+	}
+}
+
+func TestRS256Tokens(t *testing.T) {
+	s := newTestServer(t)
+	hsToken, _ := s.proprietor("1111111111", "alpha")
+
+	if err := db.RotateSigningKeys(s.store, "RS256", time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	owner := s.loginOwner("1111111111").AccessToken
+	s.expect(s.do("GET", "/v1/companies", nil, owner), http.StatusOK)
+
+	set := s.jwks()
+	if len(set.Keys) != 1 || set.Keys[0].Kty != "RSA" || set.Keys[0].Alg != "RS256" {
+		t.Fatalf("unexpected jwks: %+v", set)
+	}
+	tok := verifyWithJWKS(t, set, owner)
+	if tok.Claims.(jwt.MapClaims)["usertype"] != mod.PROPRIETOR {
+		t.Fatalf("unexpected claims: %v", tok.Claims)
+	}
+
+	//HS256 tokens are not accepted once the keyring is asymmetric.
+	s.expect(s.do("GET", "/v1/companies", nil, hsToken), http.StatusUnauthorized)
+}
+
+func TestES256KeyRotation(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	if err := db.RotateSigningKeys(s.store, "ES256", time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	before := s.loginOwner("1111111111").AccessToken
+
+	//rotation period elapsed, a second key is published but does not sign yet.
+	if err := db.RotateSigningKeys(s.store, "ES256", db.KeyPublishDelay, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	set := s.jwks()
+	if len(set.Keys) != 2 {
+		t.Fatalf("expected old and new key in jwks: %+v", set)
+	}
+	after := s.loginOwner("1111111111").AccessToken
+	verifyWithJWKS(t, set, after)
+	if verifyWithJWKS(t, set, before).Header["kid"] != verifyWithJWKS(t, set, after).Header["kid"] {
+		t.Fatalf("unpublished key used for signing")
+	}
+	s.expect(s.do("GET", "/v1/companies", nil, before), http.StatusOK)
+}
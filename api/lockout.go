@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/monitor_security/config"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ * Failed logins are free up to the limit, every further failure doubles the
+ * lockout starting at lockoutBase, up to lockoutMax. Counts are forgotten
+ * lockoutWindow after the last failure.
+ */
+const (
+	accountFreeAttempts = 5
+	addressFreeAttempts = 20
+	lockoutBase         = time.Minute
+	lockoutMax          = time.Hour
+	lockoutWindow       = 24 * time.Hour
+)
+
+//Uniform login failure, never reveals whether the account exists.
+var invalidCredentials = mod.ErrorResponse{Error: "Invalid credentials."}
+
+func accountKey(usertype, tenent, phone string) string {
+	return "acct:" + util.TokenSubject(usertype, tenent, phone)
+}
+
+func addressKey(r *http.Request) string {
+	return "ip:" + clientAddress(r)
+}
+
+/*
+ * Client address of the request, X-Forwarded-For is only used when the server is
+ * configured to run behind a proxy, the last entry is the one the proxy added.
+ */
+func clientAddress(r *http.Request) string {
+	if config.Current.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func lockoutDelay(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	d := lockoutBase
+	for i := free; i < failures && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+/*
+ * Time left until the account may try again, zero when not locked. Does not count an
+ * attempt, used where no credentials are checked.
+ */
+func loginLockout(ctx context.Context, account string) (time.Duration, error) {
+	a, err := store.LoginAttempts.Find(ctx, account)
+	if err == db.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if left := time.Until(a.Last.Add(lockoutDelay(a.Failures, accountFreeAttempts))); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+/*
+ * Login attempt counted against the account and the client address before the credentials
+ * are checked, so parallel guesses can not pass the limits. A failed attempt stays counted,
+ * one which passed is taken back with refund.
+ */
+type loginAttempt struct {
+	counted  time.Time
+	previous map[string]time.Time //key to the time of the attempt before this one
+}
+
+/*
+ * Count an attempt to login to account. A response is written and nil returned when the
+ * account or the client address is locked, or the attempt can not be counted.
+ */
+func countLoginAttempt(ctx context.Context, w http.ResponseWriter, r *http.Request, account string) *loginAttempt {
+	a := &loginAttempt{counted: time.Now().Truncate(time.Millisecond), previous: map[string]time.Time{}}
+	expires := a.counted.Add(lockoutWindow)
+	var wait time.Duration
+	for key, free := range map[string]int{account: accountFreeAttempts, addressKey(r): addressFreeAttempts} {
+		before, err := store.LoginAttempts.CountAttempt(ctx, key, a.counted, expires)
+		if err != nil {
+			//without a count there is no lockout, so no login either.
+			util.Log.Printf("Unable to count login attempt : %v", err.Error())
+			a.refund(ctx)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to login, try again later."})
+			return nil
+		}
+		a.previous[key] = before.Last
+		if left := time.Until(before.Last.Add(lockoutDelay(before.Failures, free))); left > wait {
+			wait = left
+		}
+	}
+	if wait > 0 {
+		//an attempt refused while locked does not make the lockout longer.
+		a.refund(ctx)
+		util.Log.Printf("Login locked for : %v", account)
+		writeLockedOut(w, wait)
+		return nil
+	}
+	return a
+}
+
+//take back the attempt, the credentials passed.
+func (a *loginAttempt) refund(ctx context.Context) {
+	for key, previous := range a.previous {
+		if err := store.LoginAttempts.Refund(ctx, key, a.counted, previous); err != nil {
+			util.Log.Printf("Unable to refund login attempt : %v", err.Error())
+		}
+	}
+}
+
+/*
+ * A successful login clears the account count, the address count is left to expire
+ * so an attacker can not reset it by logging into an account of their own.
+ */
+func loginSucceeded(ctx context.Context, account string) {
+	if err := store.LoginAttempts.Reset(ctx, account); err != nil {
+		util.Log.Printf("Unable to reset login failures : %v", err.Error())
+	}
+}
+
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Too many failed attempts, try again later."})
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+/*
+ * Compare against a throw away hash when the account does not exist, so the
+ * response time does not tell whether it does.
+ */
+func checkDummyPassword(password string) {
+	dummyHash.once.Do(func() {
+		dummyHash.hash, _ = util.HashPassword("dummy-password")
+	})
+	util.CheckPassword(dummyHash.hash, password)
+}
+
+/*
+ * Clear the failed login counts of a guard in the proprietor's tenent, including the
+ * login without a tenent.
+ */
+func UnlockGuard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	guard, err := store.Guards.FindById(ctx, tenent, objID)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Guard not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find guard: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	//the tenent login and switch count under the tenent, the login by phone alone without one.
+	for _, key := range []string{accountKey(mod.GUARD, tenent, guard.Phone), accountKey(mod.GUARD, "", guard.Phone)} {
+		if err := store.LoginAttempts.Reset(ctx, key); err != nil {
+			util.Log.Printf("Unable to unlock guard: %v", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Guard unlocked."})
+}
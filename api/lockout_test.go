@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/monitor_security/config"
+	mod "github.com/monitor_security/model"
+)
+
+func TestProprietorLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	wrong := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: "wrongpass1", UserType: mod.PROPRIETOR}
+	right := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}
+
+	for i := 0; i < accountFreeAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/login-proprietor-password", wrong, ""), http.StatusUnauthorized)
+	}
+	//locked, even with the right password
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", right, "")
+	s.expect(rec, http.StatusTooManyRequests)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("no Retry-After header")
+	}
+}
+
+func TestParallelPasswordGuesses(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	wrong := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: "wrongpass1", UserType: mod.PROPRIETOR}
+
+	//guesses racing each other are counted before the password is checked
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checked := 0
+	for i := 0; i < 4*accountFreeAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.serve(s.request("POST", "/v1/auth/login-proprietor-password", wrong, "")).Code == http.StatusUnauthorized {
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if checked != accountFreeAttempts {
+		t.Fatalf("expected %v guesses checked got %v", accountFreeAttempts, checked)
+	}
+}
+
+func TestSuccessfulLoginsDoNotLockAddress(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	right := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}
+
+	//every attempt is counted, the ones that pass are taken back
+	for i := 0; i <= addressFreeAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/login-proprietor-password", right, ""), http.StatusOK)
+	}
+}
+
+func TestUnknownAccountLockout(t *testing.T) {
+	s := newTestServer(t)
+	login := mod.ProprietorPasswordLogin{Phone: "9999999999", Password: "wrongpass1", UserType: mod.PROPRIETOR}
+
+	//unknown accounts lock the same way, so lockout does not reveal existence either
+	for i := 0; i < accountFreeAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusUnauthorized)
+	}
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusTooManyRequests)
+}
+
+func TestAddressLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	//spraying many accounts from one address
+	for i := 0; i < addressFreeAttempts; i++ {
+		login := mod.ProprietorPasswordLogin{Phone: fmt.Sprintf("90000000%02d", i), Password: "wrongpass1", UserType: mod.PROPRIETOR}
+		s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusUnauthorized)
+	}
+	right := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", right, ""), http.StatusTooManyRequests)
+
+	//another client is not affected
+	config.Current.TrustProxy = true
+	req := s.request("POST", "/v1/auth/login-proprietor-password", right, "")
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	s.expect(s.serve(req), http.StatusOK)
+}
+
+func TestCodeLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	//otp logins and reset codes count against the account like passwords
+	reset := mod.PasswordReset{Phone: "1111111111", UserType: mod.PROPRIETOR, Code: "000000", NewPassword: newPassword}
+	for i := 0; i < accountFreeAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusUnauthorized)
+	}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusTooManyRequests)
+	login := mod.OtpLogin{Phone: "1111111111", Otp: "000000", UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/login-otp", login, ""), http.StatusTooManyRequests)
+
+	//rotating phones from one address locks the address
+	for i := 0; i < addressFreeAttempts; i++ {
+		login := mod.OtpLogin{Phone: fmt.Sprintf("90000000%02d", i), Otp: "000000", UserType: mod.PROPRIETOR}
+		code := s.do("POST", "/v1/auth/login-otp", login, "").Code
+		if code != http.StatusUnauthorized && code != http.StatusTooManyRequests {
+			t.Fatalf("unexpected status %v", code)
+		}
+	}
+	login.Phone = "9100000000"
+	s.expect(s.do("POST", "/v1/auth/login-otp", login, ""), http.StatusTooManyRequests)
+}
+
+func TestUnlockGuard(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+	g, _ := s.store.Guards.FindByPhone(context.Background(), tenent, "2222222222")
+
+	for i := 0; i < accountFreeAttempts; i++ {
+		if s.guardLogin(tenent, "2222222222", "wrongpass1") != http.StatusUnauthorized {
+			t.Fatalf("expected unauthorized")
+		}
+	}
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusTooManyRequests {
+		t.Fatalf("guard not locked")
+	}
+
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/unlock", nil, other), http.StatusNotFound)
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/unlock", nil, owner), http.StatusOK)
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusOK {
+		t.Fatalf("guard still locked")
+	}
+
+	//the login without a tenent counts under its own key, unlocked as well
+	other, otherTenent := s.proprietor("4444444444", "gamma")
+	s.guard(other, otherTenent, "2222222222")
+	login := mod.PasswordLogin{Phone: "2222222222", Password: "wrongpass1", UserType: mod.GUARD}
+	for i := 0; i < accountFreeAttempts; i++ {
+		s.expect(s.do("POST", "/v1/auth/login", login, ""), http.StatusUnauthorized)
+	}
+	login.Password = testPassword
+	s.expect(s.do("POST", "/v1/auth/login", login, ""), http.StatusTooManyRequests)
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/unlock", nil, owner), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/login", login, ""), http.StatusMultipleChoices)
+}
+
+func TestSuspendedLoginKeepsFailures(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+	admin := adminToken(t, s)
+
+	for i := 0; i < accountFreeAttempts-1; i++ {
+		if s.guardLogin(tenent, "2222222222", "wrongpass1") != http.StatusUnauthorized {
+			t.Fatalf("expected unauthorized")
+		}
+	}
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+tenent+"/suspend", nil, admin), http.StatusOK)
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusForbidden {
+		t.Fatalf("expected suspended")
+	}
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+tenent+"/reactivate", nil, admin), http.StatusOK)
+	if s.guardLogin(tenent, "2222222222", "wrongpass1") != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized")
+	}
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusTooManyRequests {
+		t.Fatalf("failures reset by a rejected login")
+	}
+}
+
+func TestLockoutDelay(t *testing.T) {
+	if lockoutDelay(accountFreeAttempts-1, accountFreeAttempts) != 0 {
+		t.Fatalf("locked before the free attempts are used")
+	}
+	if lockoutDelay(accountFreeAttempts+2, accountFreeAttempts) != 4*lockoutBase {
+		t.Fatalf("delay does not double")
+	}
+	if lockoutDelay(100, accountFreeAttempts) != lockoutMax {
+		t.Fatalf("delay not capped")
+	}
+}
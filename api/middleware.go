@@ -6,122 +6,114 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	db "github.com/monitor_security/db"
 	mod "github.com/monitor_security/model"
-	"go.mongodb.org/mongo-driver/bson"
-
+	"github.com/monitor_security/policy"
 	"github.com/monitor_security/util"
 )
 
-func TokenValidator(next http.Handler) http.Handler {
+/*
+ * The single authorization check of a route: a valid, unrevoked token of an active
+ * user whose role the policy grants perm. Public routes are served as is.
+ */
+func Authorize(perm policy.Permission, next http.Handler) http.Handler {
+	if perm == policy.Public {
+		return next
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
-		if util.ValidateToken(auth) {
-			cPtr, err := util.GetUserClaims(auth)
-			if err != nil {
-				util.Log.Printf("Unable to get user type : %v", err)
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			} else {
-				r = r.WithContext(context.WithValue(r.Context(), "user-claim", cPtr))
-				next.ServeHTTP(w, r)
-			}
-		} else {
+		if !util.ValidateToken(auth) {
 			util.Log.Println("Invalid Token")
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-	})
-}
-
-func IsAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		claims := r.Context().Value("user-claim").(jwt.MapClaims)
-		utype, ok := claims["usertype"]
-		if ok && utype == mod.ADMIN {
-			next.ServeHTTP(w, r)
-		} else {
-			util.Log.Printf("Wrong user type Actual: %v, expected: %v", utype, mod.ADMIN)
+		claims, err := util.GetUserClaims(auth)
+		if err != nil {
+			util.Log.Printf("Unable to get user type : %v", err)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-	})
-}
-
-func IsProprietor(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		claims := r.Context().Value("user-claim").(jwt.MapClaims)
-		utype, ok := claims["usertype"]
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-		if ok && utype == mod.PROPRIETOR {
-			next.ServeHTTP(w, r)
-		} else {
-			util.Log.Printf("Wrong user type Actual: %v, expected: %v", utype, mod.PROPRIETOR)
+		if isTokenRevoked(ctx, claims) {
+			util.Log.Println("Revoked Token")
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-	})
-}
-
-func IsGuard(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		claims := r.Context().Value("user-claim").(jwt.MapClaims)
-		utype, ok := claims["usertype"]
-
-		if ok && utype == mod.GUARD {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			//Check if User is actie
-			phone, _ := claims["phone"]
-			var guard mod.Guard
-			filter := bson.M{"phone": phone, "tenent": claims["tenent"].(string), "active": true}
-
-			err := db.GuardDB.FindOne(ctx, filter).Decode(&guard)
-			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			next.ServeHTTP(w, r)
-		} else {
-			util.Log.Printf("Wrong user type Actual: %v, expected: %v", utype, mod.GUARD)
+		if role := claimsRole(claims); !permissions.Allows(role, perm) {
+			util.Log.Printf("Permission denied Role: %q, permission: %v", role, perm)
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		if !isUserActive(ctx, claims) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), "user-claim", claims))
+		next.ServeHTTP(w, r)
 	})
 }
 
-func IsProprietorOrGuard(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+/*
+ * Users of a suspended tenent or de-activated account are refused.
+ */
+func isUserActive(ctx context.Context, claims jwt.MapClaims) bool {
+	switch claims["usertype"] {
+	case mod.PROPRIETOR:
+		return isProprietorActive(ctx, claims)
+	case mod.GUARD:
+		return isGuardActive(ctx, claims)
+	case mod.CLIENT:
+		return isClientActive(ctx, claims)
+	}
+	return true
+}
 
-		claims := r.Context().Value("user-claim").(jwt.MapClaims)
-		utype, ok := claims["usertype"]
+/*
+ * Revoked tokens and tokens of revoked subjects ( deleted guards, logout-all ) are rejected.
+ */
+func isTokenRevoked(ctx context.Context, claims jwt.MapClaims) bool {
+	jti, _ := claims["jti"].(string)
+	revoked, err := store.Revocations.IsRevoked(ctx, jti, claimsSubject(claims), util.ClaimsIssuedAt(claims))
+	if err != nil {
+		util.Log.Printf("Unable to check token revocation : %v", err)
+		return true
+	}
+	return revoked
+}
 
-		if ok && (utype == mod.PROPRIETOR) {
-			next.ServeHTTP(w, r)
-		} else if ok && (utype == mod.GUARD) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+func claimsSubject(claims jwt.MapClaims) string {
+	usertype, _ := claims["usertype"].(string)
+	tenent, _ := claims["tenent"].(string)
+	phone, _ := claims["phone"].(string)
+	return util.TokenSubject(usertype, tenent, phone)
+}
 
-			phone, _ := claims["phone"]
-			var guard mod.Guard
-			filter := bson.M{"phone": phone, "tenent": claims["tenent"].(string), "active": true}
+func claimsExpiry(claims jwt.MapClaims) time.Time {
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Now().Add(util.TokenLifetime())
+}
 
-			err := db.GuardDB.FindOne(ctx, filter).Decode(&guard)
-			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-			next.ServeHTTP(w, r)
-		} else {
-			util.Log.Printf("Wrong user type Actual: %v, expected: %v", utype, mod.GUARD)
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-	})
+func isProprietorActive(ctx context.Context, claims jwt.MapClaims) bool {
+	phone, _ := claims["phone"].(string)
+	user, err := store.Proprietors.FindByPhone(ctx, phone)
+	return err == nil && user.Active && user.Tenent == claims["tenent"].(string)
+}
+
+func isGuardActive(ctx context.Context, claims jwt.MapClaims) bool {
+	phone, _ := claims["phone"].(string)
+	guard, err := store.Guards.FindByPhone(ctx, claims["tenent"].(string), phone)
+	return err == nil && guard.Active && isTenentActive(ctx, guard.Tenent)
+}
+
+/*
+ * A tenent is suspended by the platform admin by de-activating its proprietor.
+ */
+func isTenentActive(ctx context.Context, tenent string) bool {
+	user, err := store.Proprietors.FindByTenent(ctx, tenent)
+	return err == nil && user.Active
 }
 
 func Logger(inner http.Handler, name string) http.Handler {
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/notify"
+	"github.com/monitor_security/util"
+	"gopkg.in/validator.v2"
+)
+
+const (
+	otpDigits      = 6
+	otpExpiry      = 5 * time.Minute
+	otpMaxAttempts = 5
+	otpCooldown    = 30 * time.Second
+	//codes a client address may ask for, until an hour passes without a request.
+	addressCodeRequests = 10
+	addressCodeWindow   = time.Hour
+)
+
+/*
+ * Send a login OTP to a registered proprietor or guard.
+ */
+func RequestOtp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.OtpRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.UserType == mod.CLIENT {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "OTP login is not available for client"})
+		return
+	}
+	if req.UserType == mod.GUARD && req.Tenent == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "tenent is required for guard"})
+		return
+	}
+	if req.UserType == mod.PROPRIETOR {
+		req.Tenent = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !allowCodeRequest(ctx, w, r) {
+		return
+	}
+
+	//Do not reveal whether the account exists, not even by a failure to send.
+	sent := mod.SuccessResponse{Status: "If the account exists, an OTP has been sent."}
+	if !otpUserExists(ctx, req.Tenent, req.Phone, req.UserType) {
+		util.Log.Printf("OTP requested for unknown %v : %v", req.UserType, req.Phone)
+	} else if err := sendOtp(ctx, req.Tenent, req.Phone, req.UserType, mod.OTP_LOGIN, otpExpiry, otpCooldown,
+		"Your login code is %v, valid for %v minutes."); err != nil {
+		util.Log.Printf("OTP not sent to %v : %v", req.Phone, err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sent)
+}
+
+/*
+ * Verify the OTP and login the proprietor or guard.
+ */
+func VerifyOtp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var login mod.OtpLogin
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(login); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if login.UserType == mod.GUARD && login.Tenent == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "tenent is required for guard"})
+		return
+	}
+	if login.UserType == mod.PROPRIETOR {
+		login.Tenent = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account := accountKey(login.UserType, login.Tenent, login.Phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	invalid := mod.ErrorResponse{Error: "OTP is invalid or expired."}
+	if err := checkOtp(ctx, login.Tenent, login.Phone, login.UserType, mod.OTP_LOGIN, login.Otp); err != nil {
+		util.Log.Printf("OTP not accepted for %v : %v", login.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+
+	if login.UserType == mod.PROPRIETOR {
+		user, err := store.Proprietors.FindByPhone(ctx, login.Phone)
+		if err != nil || !user.Active {
+			util.Log.Printf("Unable to find user : %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(invalid)
+			return
+		}
+		attempt.refund(ctx)
+		completeProprietorLogin(ctx, w, r, user)
+		return
+	}
+
+	user, err := store.Guards.FindByPhone(ctx, login.Tenent, login.Phone)
+	if err != nil || !user.Registered || !user.Active {
+		util.Log.Printf("Unable to find user : %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	attempt.refund(ctx)
+	loginSucceeded(ctx, account)
+	writeLoginToken(w, r, &mod.GuardTokenData{
+		UserType: user.UserType,
+		Tenent:   user.Tenent,
+		Phone:    user.Phone,
+		Name:     user.Name,
+		Group:    user.Group,
+	})
+}
+
+var errOtpCooldown = errors.New("otp recently sent")
+
+/*
+ * Count a request for a code sent by SMS against the client address, whether the account
+ * exists or not. A response is written and false returned once the address asked for
+ * addressCodeRequests codes.
+ */
+func allowCodeRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	now := time.Now()
+	a, err := store.LoginAttempts.CountAttempt(ctx, "sms:"+addressKey(r), now, now.Add(addressCodeWindow))
+	if err != nil {
+		util.Log.Printf("Unable to count code request : %v", err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to send code, try again later."})
+		return false
+	}
+	if a.Failures >= addressCodeRequests {
+		util.Log.Printf("Code requests limited for : %v", clientAddress(r))
+		w.Header().Set("Retry-After", fmt.Sprint(int(addressCodeWindow.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Too many codes requested, try again later."})
+		return false
+	}
+	return true
+}
+
+/*
+ * Generate, store and send a code, message gets the code and validity in minutes.
+ * A code which was sent less than cooldown ago is not replaced.
+ */
+func sendOtp(ctx context.Context, tenent, phone, usertype, purpose string, expiry, cooldown time.Duration, message string) error {
+	last, err := store.Otps.Find(ctx, tenent, phone, usertype, purpose)
+	if err == nil && !last.Used && time.Since(last.Created) < cooldown {
+		return errOtpCooldown
+	}
+
+	code, err := util.GenerateCode(otpDigits)
+	if err != nil {
+		return err
+	}
+
+	t := time.Now()
+	otp := mod.Otp{
+		Tenent:   tenent,
+		Phone:    phone,
+		UserType: usertype,
+		Purpose:  purpose,
+		Code:     util.HashCode(code),
+		Created:  t,
+		Expires:  t.Add(expiry),
+	}
+	if err := store.Otps.Save(ctx, otp); err != nil {
+		return err
+	}
+	return notify.SMS.Send(phone, fmt.Sprintf(message, code, int(expiry.Minutes())))
+}
+
+/*
+ * Check and consume a code, a code is checked at most otpMaxAttempts times.
+ */
+func checkOtp(ctx context.Context, tenent, phone, usertype, purpose, code string) error {
+	otp, err := store.Otps.Find(ctx, tenent, phone, usertype, purpose)
+	if err != nil {
+		return err
+	}
+	if otp.Used || time.Now().After(otp.Expires) {
+		return fmt.Errorf("code used or expired")
+	}
+	//the attempt is counted before comparing, so parallel guesses can not pass the limit.
+	if _, err := store.Otps.CountAttempt(ctx, otp.Id, otpMaxAttempts); err != nil {
+		return fmt.Errorf("code used or out of attempts: %v", err)
+	}
+	if !util.CheckCode(otp.Code, code) {
+		return fmt.Errorf("code did not match")
+	}
+	//Single use, only one concurrent check can consume the code.
+	return store.Otps.Consume(ctx, otp.Id)
+}
+
+func otpUserExists(ctx context.Context, tenent, phone, usertype string) bool {
+	if usertype == mod.PROPRIETOR {
+		user, err := store.Proprietors.FindByPhone(ctx, phone)
+		return err == nil && user.Active
+	}
+	user, err := store.Guards.FindByPhone(ctx, tenent, phone)
+	return err == nil && user.Registered && user.Active && isTenentActive(ctx, tenent)
+}
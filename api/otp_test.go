@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/monitor_security/config"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/notify"
+)
+
+func TestOtpLoginProprietor(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	req := mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/request-otp", req, ""), http.StatusOK)
+	code := s.sms.code("1111111111")
+	if code == "" {
+		t.Fatalf("no otp sent")
+	}
+	//resend is throttled, with the same answer as for an unknown account
+	s.expect(s.do("POST", "/v1/auth/request-otp", req, ""), http.StatusOK)
+	if s.sms.code("1111111111") != code {
+		t.Fatalf("otp resent within the cooldown")
+	}
+
+	login := mod.OtpLogin{Phone: "1111111111", Otp: code, UserType: mod.PROPRIETOR}
+	rec := s.do("POST", "/v1/auth/login-otp", login, "")
+	s.expect(rec, http.StatusOK)
+	s.expect(s.do("GET", "/v1/guards", nil, tokenCookie(t, rec)), http.StatusOK)
+
+	//single use
+	s.expect(s.do("POST", "/v1/auth/login-otp", login, ""), http.StatusUnauthorized)
+}
+
+func TestOtpLoginGuardAttemptLimit(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Phone: "2222222222", UserType: mod.GUARD}, ""), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Tenent: tenent, Phone: "2222222222", UserType: mod.GUARD}, ""), http.StatusOK)
+	code := s.sms.code("2222222222")
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < otpMaxAttempts; i++ {
+		rec := s.do("POST", "/v1/auth/login-otp", mod.OtpLogin{Tenent: tenent, Phone: "2222222222", Otp: wrong, UserType: mod.GUARD}, "")
+		s.expect(rec, http.StatusUnauthorized)
+	}
+	//the failures lock the account like password logins
+	login := mod.OtpLogin{Tenent: tenent, Phone: "2222222222", Otp: code, UserType: mod.GUARD}
+	s.expect(s.do("POST", "/v1/auth/login-otp", login, ""), http.StatusTooManyRequests)
+	//code is burnt after too many attempts
+	s.store.LoginAttempts.Reset(context.Background(), accountKey(mod.GUARD, tenent, "2222222222"))
+	s.expect(s.do("POST", "/v1/auth/login-otp", login, ""), http.StatusUnauthorized)
+}
+
+func TestOtpParallelGuesses(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Tenent: tenent, Phone: "2222222222", UserType: mod.GUARD}, ""), http.StatusOK)
+	code := s.sms.code("2222222222")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	//guesses racing each other are all counted against the code
+	var wg sync.WaitGroup
+	for i := 0; i < 4*otpMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(s.request("POST", "/v1/auth/login-otp", mod.OtpLogin{Tenent: tenent, Phone: "2222222222", Otp: wrong, UserType: mod.GUARD}, ""))
+		}()
+	}
+	wg.Wait()
+	otp, err := s.store.Otps.Find(context.Background(), tenent, "2222222222", mod.GUARD, mod.OTP_LOGIN)
+	if err != nil || otp.Attempts != otpMaxAttempts {
+		t.Fatalf("unexpected attempts %v %v", otp.Attempts, err)
+	}
+	s.store.LoginAttempts.Reset(context.Background(), accountKey(mod.GUARD, tenent, "2222222222"))
+	rec := s.do("POST", "/v1/auth/login-otp", mod.OtpLogin{Tenent: tenent, Phone: "2222222222", Otp: code, UserType: mod.GUARD}, "")
+	s.expect(rec, http.StatusUnauthorized)
+}
+
+func TestOtpUnknownAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}, ""), http.StatusOK)
+	if s.sms.code("1111111111") != "" {
+		t.Fatalf("otp sent to unknown account")
+	}
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(phone, message string) error {
+	return errors.New("gateway down")
+}
+
+func TestOtpRequestDoesNotRevealAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	//a failure to send looks the same as an unknown account
+	notify.SMS = failingSender{}
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}, ""), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/forgot-password", mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}, ""), http.StatusOK)
+	notify.SMS = s.sms
+
+	//requests of one address are limited, whether the accounts exist or not
+	for i := 2; i < addressCodeRequests; i++ {
+		req := mod.OtpRequest{Phone: fmt.Sprintf("90000000%02d", i), UserType: mod.PROPRIETOR}
+		s.expect(s.do("POST", "/v1/auth/request-otp", req, ""), http.StatusOK)
+	}
+	s.proprietor("3333333333", "beta")
+	req := mod.OtpRequest{Phone: "3333333333", UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/request-otp", req, ""), http.StatusTooManyRequests)
+	s.expect(s.do("POST", "/v1/auth/forgot-password", req, ""), http.StatusTooManyRequests)
+	if s.sms.code("3333333333") != "" {
+		t.Fatalf("otp sent past the address limit")
+	}
+
+	//another client is not affected
+	config.Current.TrustProxy = true
+	r := s.request("POST", "/v1/auth/request-otp", req, "")
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	s.expect(s.serve(r), http.StatusOK)
+	if s.sms.code("3333333333") == "" {
+		t.Fatalf("no otp sent")
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+const (
+	resetExpiry   = 15 * time.Minute
+	resetCooldown = time.Minute
+)
+
+/*
+ * Stored password of a proprietor, guard or client and the function to replace it,
+ * tenent is the one its tokens are issued for.
+ */
+type passwordAccount struct {
+	id       primitive.ObjectID
+	tenent   string
+	password string
+	update   passwordUpdater
+}
+
+func findPasswordAccount(ctx context.Context, tenent, phone, usertype string) (passwordAccount, error) {
+	switch usertype {
+	case mod.PROPRIETOR:
+		user, err := store.Proprietors.FindByPhone(ctx, phone)
+		if err == nil && !user.Active {
+			err = db.ErrNotFound
+		}
+		return passwordAccount{user.Id, user.Tenent, user.Password, store.Proprietors.UpdatePassword}, err
+	case mod.CLIENT:
+		user, err := store.Clients.FindByPhone(ctx, phone)
+		if err == nil && !isClientActive(ctx, jwt.MapClaims{"tenent": user.Tenent, "phone": phone}) {
+			err = db.ErrNotFound
+		}
+		return passwordAccount{user.Id, user.Tenent, user.Password, store.Clients.UpdatePassword}, err
+	}
+	user, err := store.Guards.FindByPhone(ctx, tenent, phone)
+	if err == nil && (!user.Registered || !user.Active || !isTenentActive(ctx, tenent)) {
+		err = db.ErrNotFound
+	}
+	return passwordAccount{user.Id, user.Tenent, user.Password, store.Guards.UpdatePassword}, err
+}
+
+/*
+ * Hash and store the new password, then revoke every session of the user.
+ */
+func setPassword(ctx context.Context, account passwordAccount, usertype, phone, password string) error {
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := account.update(ctx, account.id, account.password, hash); err != nil {
+		return err
+	}
+	return revokeAllSessions(ctx, usertype, account.tenent, phone)
+}
+
+/*
+ * Change the password of the logged in user, all sessions are logged out.
+ */
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.ChangePassword
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	usertype := claims["usertype"].(string)
+	tenent := claims["tenent"].(string)
+	phone := claims["phone"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := findPasswordAccount(ctx, tenent, phone, usertype)
+	if err != nil {
+		util.Log.Printf("Unable to find user : %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "User NOT found."})
+		return
+	}
+	if match, _ := util.CheckPassword(account.password, req.OldPassword); !match {
+		util.Log.Printf("Password did not match for : %v", phone)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Password did not match."})
+		return
+	}
+
+	if err := setPassword(ctx, account, usertype, phone, req.NewPassword); err != nil {
+		util.Log.Printf("Unable to change password : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to change password."})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Password changed, pls login."})
+}
+
+/*
+ * Send a password reset code to a registered proprietor, guard or client.
+ */
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.OtpRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.UserType == mod.GUARD && req.Tenent == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "tenent is required for guard"})
+		return
+	}
+	if req.UserType == mod.PROPRIETOR || req.UserType == mod.CLIENT {
+		req.Tenent = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !allowCodeRequest(ctx, w, r) {
+		return
+	}
+
+	//Do not reveal whether the account exists, not even by a failure to send.
+	sent := mod.SuccessResponse{Status: "If the account exists, a reset code has been sent."}
+	if _, err := findPasswordAccount(ctx, req.Tenent, req.Phone, req.UserType); err != nil {
+		util.Log.Printf("Password reset requested for unknown %v : %v", req.UserType, req.Phone)
+	} else if err := sendOtp(ctx, req.Tenent, req.Phone, req.UserType, mod.OTP_RESET, resetExpiry, resetCooldown,
+		"Your password reset code is %v, valid for %v minutes."); err != nil {
+		util.Log.Printf("Reset code not sent to %v : %v", req.Phone, err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sent)
+}
+
+/*
+ * Set a new password with the reset code, all sessions are logged out.
+ */
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.PasswordReset
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if req.UserType == mod.PROPRIETOR || req.UserType == mod.CLIENT {
+		req.Tenent = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//reset codes are guessed like passwords, the same lockout applies.
+	lockKey := accountKey(req.UserType, req.Tenent, req.Phone)
+	attempt := countLoginAttempt(ctx, w, r, lockKey)
+	if attempt == nil {
+		return
+	}
+
+	invalid := mod.ErrorResponse{Error: "Reset code is invalid or expired."}
+	account, err := findPasswordAccount(ctx, req.Tenent, req.Phone, req.UserType)
+	if err == nil {
+		err = checkOtp(ctx, req.Tenent, req.Phone, req.UserType, mod.OTP_RESET, req.Code)
+	}
+	if err != nil {
+		util.Log.Printf("Reset code not accepted for %v : %v", req.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+
+	if err := setPassword(ctx, account, req.UserType, req.Phone, req.NewPassword); err != nil {
+		util.Log.Printf("Unable to reset password : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to reset password."})
+		return
+	}
+	attempt.refund(ctx)
+	loginSucceeded(ctx, lockKey)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Password reset, pls login."})
+}
+
+/*
+ * Proprietor forces a guard to reset the password, the current password stops working,
+ * the guard is logged out everywhere and is sent a reset code.
+ */
+func ForceGuardPasswordReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	guard, err := store.Guards.FindById(ctx, tenent, objID)
+	if err == nil && (!guard.Registered || guard.Deleted) {
+		err = db.ErrNotFound
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find guard: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Registered guard not found: " + id})
+		return
+	}
+
+	//an empty password never matches, the guard can only login again after the reset.
+	if err := store.Guards.UpdatePassword(ctx, guard.Id, guard.Password, ""); err != nil {
+		util.Log.Printf("Unable to clear guard password: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllSessions(ctx, mod.GUARD, tenent, guard.Phone); err != nil {
+		util.Log.Printf("Unable to revoke guard tokens: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = sendOtp(ctx, tenent, guard.Phone, mod.GUARD, mod.OTP_RESET, resetExpiry, 0,
+		"Your password was reset by your employer, your reset code is %v, valid for %v minutes.")
+	if err != nil {
+		util.Log.Printf("Unable to send reset code : %v", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Password cleared, unable to send reset code, guard can use forgot password."})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Guard password reset, reset code sent."})
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+const newPassword = "n3wpassw0rd"
+
+func (s *testServer) guardLogin(tenent, phone, password string) int {
+	s.t.Helper()
+	return s.do("POST", "/v1/auth/login-guard-password", mod.GuardPasswordLogin{
+		Tenent: tenent, Phone: phone, Password: password, UserType: mod.GUARD,
+	}, "").Code
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+
+	s.expect(s.do("POST", "/v1/auth/change-password", mod.ChangePassword{OldPassword: "wrongpass1", NewPassword: newPassword}, owner), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/auth/change-password", mod.ChangePassword{OldPassword: testPassword, NewPassword: "short"}, owner), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/auth/change-password", mod.ChangePassword{OldPassword: testPassword, NewPassword: newPassword}, owner), http.StatusOK)
+
+	//every session is logged out
+	s.expect(s.do("GET", "/v1/companies", nil, owner), http.StatusUnauthorized)
+
+	login := mod.ProprietorPasswordLogin{Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusUnauthorized)
+	login.Password = newPassword
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusOK)
+}
+
+func TestForgotPasswordGuard(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	req := mod.OtpRequest{Tenent: tenent, Phone: "2222222222", UserType: mod.GUARD}
+
+	//a login otp can not reset the password
+	s.expect(s.do("POST", "/v1/auth/request-otp", req, ""), http.StatusOK)
+	reset := mod.PasswordReset{Tenent: tenent, Phone: "2222222222", UserType: mod.GUARD, Code: s.sms.code("2222222222"), NewPassword: newPassword}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusUnauthorized)
+
+	s.expect(s.do("POST", "/v1/auth/forgot-password", req, ""), http.StatusOK)
+	reset.Code = s.sms.code("2222222222")
+	//resend is throttled, with the same answer as for an unknown account
+	s.expect(s.do("POST", "/v1/auth/forgot-password", req, ""), http.StatusOK)
+	if s.sms.code("2222222222") != reset.Code {
+		t.Fatalf("reset code resent within the cooldown")
+	}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusUnauthorized)
+
+	s.expect(s.do("GET", "/v1/companies", nil, guard), http.StatusUnauthorized)
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusUnauthorized {
+		t.Fatalf("old password still valid")
+	}
+	if s.guardLogin(tenent, "2222222222", newPassword) != http.StatusOK {
+		t.Fatalf("new password not valid")
+	}
+}
+
+func TestForgotPasswordUnknownAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(s.do("POST", "/v1/auth/forgot-password", mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}, ""), http.StatusOK)
+	if s.sms.code("1111111111") != "" {
+		t.Fatalf("reset code sent to unknown account")
+	}
+}
+
+func TestForceGuardPasswordReset(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	g, _ := s.store.Guards.FindByPhone(context.Background(), tenent, "2222222222")
+
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/reset-password", nil, other), http.StatusNotFound)
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/reset-password", nil, guard), http.StatusUnauthorized)
+
+	s.expect(s.do("PUT", "/v1/guard/"+g.Id.Hex()+"/reset-password", nil, owner), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, guard), http.StatusUnauthorized)
+	if s.guardLogin(tenent, "2222222222", testPassword) != http.StatusUnauthorized {
+		t.Fatalf("password still valid after forced reset")
+	}
+
+	reset := mod.PasswordReset{Tenent: tenent, Phone: "2222222222", UserType: mod.GUARD, Code: s.sms.code("2222222222"), NewPassword: newPassword}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusOK)
+	if s.guardLogin(tenent, "2222222222", newPassword) != http.StatusOK {
+		t.Fatalf("new password not valid")
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/validator.v2"
+)
+
+/*
+ * Decode a partial update of entity, a JSON object with the version it was read at and
+ * only the fields to change. Each field must be one of allowed ( json name ) and is
+ * validated with the tags of entity, the fields are returned keyed by bson name.
+ */
+func decodePatch(r *http.Request, entity interface{}, allowed ...string) (int64, bson.M, error) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return 0, nil, err
+	}
+
+	raw, ok := body["version"]
+	if !ok {
+		return 0, nil, errors.New("version is required")
+	}
+	var version int64
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, nil, fmt.Errorf("version: %v", err)
+	}
+	delete(body, "version")
+	if len(body) == 0 {
+		return 0, nil, errors.New("nothing to update")
+	}
+
+	fields := bson.M{}
+	for name, raw := range body {
+		f, ok := patchField(reflect.TypeOf(entity), name, allowed)
+		if !ok {
+			return 0, nil, fmt.Errorf("field %q can not be changed", name)
+		}
+		v := reflect.New(f.Type)
+		if err := json.Unmarshal(raw, v.Interface()); err != nil {
+			return 0, nil, fmt.Errorf("%v: %v", name, err)
+		}
+		if tags := f.Tag.Get("validate"); tags != "" {
+			if err := validator.Valid(v.Elem().Interface(), tags); err != nil {
+				return 0, nil, fmt.Errorf("%v: %v", name, err)
+			}
+		}
+		fields[tagName(f.Tag.Get("bson"))] = v.Elem().Interface()
+	}
+	return version, fields, nil
+}
+
+func patchField(t reflect.Type, name string, allowed []string) (reflect.StructField, bool) {
+	for _, a := range allowed {
+		if a != name {
+			continue
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); tagName(f.Tag.Get("json")) == name {
+				return f, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func tagName(tag string) string {
+	return strings.Split(tag, ",")[0]
+}
+
+/*
+ * Response for a failed Update of the store, what names the document.
+ */
+func writeUpdateError(w http.ResponseWriter, err error, what, id string) {
+	switch err {
+	case db.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: what + " not found: " + id})
+	case db.ErrVersionConflict:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: what + " was changed, reload and retry."})
+	case db.ErrDuplicate:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: what + " already exists."})
+	default:
+		util.Log.Printf("Unable to update %v: %v", what, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func writeBadPatch(w http.ResponseWriter, err error) {
+	util.Log.Printf("Invalid update :%v", err.Error())
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+type patch map[string]interface{}
+
+func TestUpdateCompany(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	id := s.company(owner, "acme")
+	s.company(owner, "globex")
+
+	var company mod.Company
+	rec := s.do("PATCH", "/v1/company/"+id, patch{"version": 0, "address": "2 main st"}, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if company.Address != "2 main st" || company.Name != "acme" || company.Version != 1 {
+		t.Fatalf("unexpected company %+v", company)
+	}
+
+	//stale version
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 0, "address": "3 main st"}, owner), http.StatusConflict)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "name": "globex"}, owner), http.StatusConflict)
+
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"address": "3 main st"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "phone": "12ab"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "name": ""}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "tenent": "other"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/company/000000000000000000000000", patch{"version": 0, "address": "x"}, owner), http.StatusNotFound)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "address": "x"}, guard), http.StatusUnauthorized)
+	s.expect(s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "address": "x"}, auditor), http.StatusUnauthorized)
+
+	rec = s.do("PATCH", "/v1/company/"+id, patch{"version": 1, "name": "initech", "phone": "0123456789"}, owner)
+	s.expect(rec, http.StatusOK)
+	rec = s.do("GET", "/v1/company/"+id, nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &company)
+	if company.Name != "initech" || company.Address != "2 main st" || company.Tenent != tenent || company.Version != 2 {
+		t.Fatalf("unexpected company %+v", company)
+	}
+}
+
+func TestUpdateGuard(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+	g, err := s.store.Guards.FindByPhone(context.Background(), tenent, "2222222222")
+	if err != nil {
+		t.Fatalf("find guard: %v", err)
+	}
+	id := g.Id.Hex()
+
+	var guard mod.Guard
+	rec := s.do("PATCH", "/v1/guard/"+id, patch{"version": 0, "name": "night shift", "image": "me.jpg"}, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &guard)
+	if guard.Name != "night shift" || guard.Image != "me.jpg" || guard.Phone != "2222222222" || guard.Password != "" || guard.Version != 1 {
+		t.Fatalf("unexpected guard %+v", guard)
+	}
+
+	s.expect(s.do("PATCH", "/v1/guard/"+id, patch{"version": 1, "name": "ab"}, owner), http.StatusBadRequest)
+	for _, field := range []string{"phone", "usertype", "tenent", "password", "active"} {
+		s.expect(s.do("PATCH", "/v1/guard/"+id, patch{"version": 1, field: "x"}, owner), http.StatusBadRequest)
+	}
+	s.expect(s.do("PATCH", "/v1/guard/"+id, patch{"version": 0, "group": "beta"}, owner), http.StatusConflict)
+
+	//another tenent
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("PATCH", "/v1/guard/"+id, patch{"version": 1, "group": "beta"}, other), http.StatusNotFound)
+
+	//the guard still logs in with the same password
+	s.expect(s.do("POST", "/v1/auth/login", mod.PasswordLogin{Phone: "2222222222", Password: testPassword, UserType: mod.GUARD, Tenent: tenent}, ""), http.StatusOK)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	manager := s.staff(owner, "5555555555", mod.STAFF_MANAGER)
+	guard := s.guard(owner, tenent, "2222222222")
+
+	var p mod.Proprietor
+	rec := s.do("GET", "/v1/profile", nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &p)
+	if p.Phone != "1111111111" || p.Password != "" || p.Role != mod.STAFF_OWNER {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+
+	rec = s.do("PATCH", "/v1/profile", patch{"version": p.Version, "image": "logo.png"}, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &p)
+	if p.Group != "alpha" || p.Image != "logo.png" || p.Tenent != tenent || p.Version != 1 {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+	//the group is shared by the whole tenent, not only this profile
+	s.expect(s.do("PATCH", "/v1/profile", patch{"version": 1, "group": "alpha security"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/profile", patch{"version": 1, "phone": "9999999999"}, owner), http.StatusBadRequest)
+	s.expect(s.do("PATCH", "/v1/profile", patch{"version": 1, "role": mod.STAFF_MANAGER}, owner), http.StatusBadRequest)
+
+	//staff change their image too
+	s.expect(s.do("PATCH", "/v1/profile", patch{"version": 0, "group": "other"}, manager), http.StatusBadRequest)
+	rec = s.do("PATCH", "/v1/profile", patch{"version": 0, "image": "me.png"}, manager)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &p)
+	if p.Image != "me.png" || p.Role != mod.STAFF_MANAGER {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+
+	s.expect(s.do("GET", "/v1/profile", nil, guard), http.StatusUnauthorized)
+	s.expect(s.do("PATCH", "/v1/profile", patch{"version": 0, "image": "x"}, guard), http.StatusUnauthorized)
+}
@@ -3,20 +3,19 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
-	db "github.com/monitor_security/db"
 	mod "github.com/monitor_security/model"
 	"github.com/monitor_security/util"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/validator.v2"
 )
 
@@ -34,31 +33,38 @@ func AddPatrolData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// validate post data
-	patrol := mod.Patrol{}
+	body := mod.Patrol{}
 
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	err = json.NewDecoder(r.Body).Decode(&patrol)
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		util.Log.Printf("Invalid body :%v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	//only what the guard app sends is taken from the body, the rest is set here.
+	patrol := mod.Patrol{Description: body.Description, GPS: body.GPS, RFData: body.RFData}
 	if err := validator.NewValidator().Validate(patrol); err != nil {
 		util.Log.Printf("Error input validation %v\n", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	lat, lng, err := util.ParseLatLng(patrol.GPS)
+	if err != nil {
+		util.Log.Printf("Invalid gps %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "gps: " + err.Error()})
+		return
+	}
+	patrol.Location = util.GeoPoint(lat, lng)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	//Validate the company and fetch company name
-	var company mod.Company
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	err = db.CompanyDB.FindOne(ctx, filter).Decode(&company)
+	company, err := store.Companies.FindById(ctx, claims["tenent"].(string), objID)
 	if err != nil {
 		util.Log.Printf("Unable to find company: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
@@ -71,6 +77,16 @@ func AddPatrolData(w http.ResponseWriter, r *http.Request) {
 		patrol.Name = "Proprietor"
 	}
 
+	distance, ok := checkSite(w, claims, company, patrol.Location)
+	if !ok {
+		return
+	}
+	patrol.OutsideSite = distance > 0
+	patrol.SiteDistance = distance
+	if !resolveCheckpoint(ctx, w, company, &patrol) {
+		return
+	}
+
 	patrol.Tenent = claims["tenent"].(string)
 	patrol.Phone = claims["phone"].(string)
 	t := time.Now()
@@ -79,28 +95,65 @@ func AddPatrolData(w http.ResponseWriter, r *http.Request) {
 	patrol.CompanyId = id
 	patrol.CompanyName = company.Name
 
-	//Add Patrol Data
-	index := mongo.IndexModel{
-		Keys:    bson.D{{"_id", 1}, {"tenent", 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err = db.PatrolDB.Indexes().CreateOne(ctx, index)
-	if err != nil {
-		util.Log.Printf("Unable to create unique index for company : %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: fmt.Errorf("Unable to create unique index: %v", err.Error()).Error()})
+	patrol.TourId = ""
+	tour, onTour, ok := activeTour(ctx, w, patrol)
+	if !ok {
 		return
 	}
-	_, err = db.PatrolDB.InsertOne(ctx, patrol)
+	if onTour {
+		patrol.TourId = tour.Id.Hex()
+	}
+
+	//Add Patrol Data
+	patrolId, err := store.Patrols.Create(ctx, patrol)
 	if err != nil {
 		util.Log.Printf("Unable to insert Patrol document : %v", err)
 		w.WriteHeader(http.StatusConflict)
 		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: fmt.Errorf("Unable to add patrol data: %v", err.Error()).Error()})
 		return
 	}
+	if onTour {
+		addTourLeg(ctx, tour, patrol, patrolId)
+	}
 	w.WriteHeader(http.StatusCreated)
 }
 
+var errBadArea = errors.New("invalid area")
+
+/*
+ * Patrols of the company, limited to a circle with near=lat,lng&radius=meters ( nearest
+ * first ) or to a box with bbox=south,west,north,east. A box crossing the antimeridian
+ * ( west greater than east ) is rejected, query each side of it separately. Patrols without
+ * a location are only listed when no area is given.
+ */
+func listPatrols(ctx context.Context, r *http.Request, tenent, companyId string) ([]mod.Patrol, error) {
+	q := r.URL.Query()
+	near, bbox := q.Get("near"), q.Get("bbox")
+	switch {
+	case near != "" && bbox == "":
+		lat, lng, err := util.ParseLatLng(near)
+		radius, rerr := strconv.ParseFloat(q.Get("radius"), 64)
+		if err != nil || rerr != nil || !(radius > 0) {
+			return nil, errBadArea
+		}
+		return store.Patrols.ListNear(ctx, tenent, companyId, lat, lng, radius)
+	case bbox != "" && near == "":
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			return nil, errBadArea
+		}
+		south, west, err := util.ParseLatLng(parts[0] + "," + parts[1])
+		north, east, nerr := util.ParseLatLng(parts[2] + "," + parts[3])
+		if err != nil || nerr != nil || south > north || west > east {
+			return nil, errBadArea
+		}
+		return store.Patrols.ListWithin(ctx, tenent, companyId, south, west, north, east)
+	case near != "" || bbox != "" || q.Get("radius") != "":
+		return nil, errBadArea
+	}
+	return store.Patrols.ListByCompany(ctx, tenent, companyId)
+}
+
 func GetAllPatrolsByCompanyId(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header()["Date"] = nil
@@ -117,26 +170,26 @@ func GetAllPatrolsByCompanyId(w http.ResponseWriter, r *http.Request) {
 
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
+	if !companyAllowed(claims, id) {
+		util.Log.Printf("Company %v not allowed for : %v", id, claims["phone"])
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"tenent": claims["tenent"].(string), "companyid": id}
-	cursor, err := db.PatrolDB.Find(ctx, filter)
-	defer cursor.Close(ctx)
-
+	c, err := listPatrols(ctx, r, claims["tenent"].(string), id)
+	if err == errBadArea {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "use near=lat,lng&radius=meters or bbox=south,west,north,east, a bbox can not cross the antimeridian"})
+		return
+	}
 	if err != nil {
 		util.Log.Printf("Unable to find patrol data: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	c := []mod.Patrol{}
-	for cursor.Next(ctx) {
-		tmp := mod.Patrol{}
-		cursor.Decode(&tmp)
-		c = append(c, tmp)
-	}
 	var patrols mod.Patrols
 	patrols.Patrols = c
 
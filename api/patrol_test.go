@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func TestPatrol(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	id := s.company(owner, "acme")
+
+	patrol := mod.Patrol{GPS: "12.97,77.59", RFData: "TAG-1", Description: "gate"}
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, patrol, guard), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, patrol, owner), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: "12.97,77.59"}, guard), http.StatusBadRequest)
+	s.expect(s.do("POST", "/v1/patrol/company/000000000000000000000000", patrol, guard), http.StatusBadRequest)
+
+	var patrols mod.Patrols
+	rec := s.do("GET", "/v1/patrol/company/"+id, nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	if len(patrols.Patrols) != 2 {
+		t.Fatalf("expected 2 patrols, got %+v", patrols)
+	}
+	p := patrols.Patrols[0]
+	if p.Name != "guard 2222222222" || p.Phone != "2222222222" || p.CompanyName != "acme" || p.Tenent != tenent {
+		t.Fatalf("unexpected patrol %+v", p)
+	}
+	if patrols.Patrols[1].Name != "Proprietor" {
+		t.Fatalf("unexpected patrol %+v", patrols.Patrols[1])
+	}
+}
+
+func TestPatrolCrossTenentIsolation(t *testing.T) {
+	s := newTestServer(t)
+	ownerA, tenentA := s.proprietor("1111111111", "alpha")
+	ownerB, tenentB := s.proprietor("3333333333", "beta")
+	guardA := s.guard(ownerA, tenentA, "2222222222")
+	guardB := s.guard(ownerB, tenentB, "4444444444")
+	id := s.company(ownerA, "acme")
+
+	patrol := mod.Patrol{GPS: "12.97,77.59", RFData: "TAG-1"}
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, patrol, guardA), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/patrol/company/"+id, patrol, guardB), http.StatusBadRequest)
+
+	var patrols mod.Patrols
+	rec := s.do("GET", "/v1/patrol/company/"+id, nil, guardB)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	if len(patrols.Patrols) != 0 {
+		t.Fatalf("patrols of another tenent listed")
+	}
+}
+
+func TestPatrolGeoSearch(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	id := s.company(owner, "acme")
+
+	for _, gps := range []string{"12.9716,77.5946", "12.9800, 77.5946", "13.0827,80.2707"} {
+		s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: gps, RFData: "TAG-1"}, guard), http.StatusCreated)
+	}
+	for _, gps := range []string{"gate", "12.97", "91,77.59", "12.97,181", "NaN,77.59"} {
+		s.expect(s.do("POST", "/v1/patrol/company/"+id, mod.Patrol{GPS: gps, RFData: "TAG-1"}, guard), http.StatusBadRequest)
+	}
+
+	list := func(query string) []mod.Patrol {
+		t.Helper()
+		var patrols mod.Patrols
+		rec := s.do("GET", "/v1/patrol/company/"+id+query, nil, owner)
+		s.expect(rec, http.StatusOK)
+		decode(t, rec, &patrols)
+		return patrols.Patrols
+	}
+
+	all := list("")
+	if len(all) != 3 || all[0].Location == nil || all[0].Location.Type != "Point" || all[0].Location.Coordinates[0] != 77.5946 {
+		t.Fatalf("unexpected patrols %+v", all)
+	}
+
+	//nearest first
+	c := list("?near=12.9810,77.5946&radius=2000")
+	if len(c) != 2 || c[0].GPS != "12.9800, 77.5946" || c[1].GPS != "12.9716,77.5946" {
+		t.Fatalf("unexpected patrols near %+v", c)
+	}
+	if c := list("?near=12.9716,77.5946&radius=500"); len(c) != 1 {
+		t.Fatalf("unexpected patrols near %+v", c)
+	}
+	if c := list("?bbox=12,77,14,81"); len(c) != 3 {
+		t.Fatalf("unexpected patrols within %+v", c)
+	}
+	if c := list("?bbox=13,80,13.1,80.3"); len(c) != 1 || c[0].GPS != "13.0827,80.2707" {
+		t.Fatalf("unexpected patrols within %+v", c)
+	}
+
+	for _, query := range []string{"?near=12.97,77.59", "?near=12.97,77.59&radius=-1", "?radius=100",
+		"?bbox=14,77,12,81", "?bbox=12,170,14,-170", "?bbox=12,77,14", "?near=12.97,77.59&radius=100&bbox=12,77,14,81"} {
+		s.expect(s.do("GET", "/v1/patrol/company/"+id+query, nil, owner), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/policy"
+)
+
+//Policy checked by Authorize, replaced at startup by SetPolicy.
+var permissions = policy.Default()
+
+func SetPolicy(p *policy.Policy) {
+	permissions = p
+}
+
+var staffPolicyRoles = map[string]policy.Role{
+	mod.STAFF_OWNER:      policy.RoleOwner,
+	mod.STAFF_MANAGER:    policy.RoleManager,
+	mod.STAFF_SUPERVISOR: policy.RoleSupervisor,
+	mod.STAFF_AUDITOR:    policy.RoleAuditor,
+}
+
+/*
+ * Policy role of a token, empty for an unknown user type or sub-role so nothing is granted.
+ */
+func claimsRole(claims jwt.MapClaims) policy.Role {
+	switch claims["usertype"] {
+	case mod.ADMIN:
+		return policy.RoleAdmin
+	case mod.GUARD:
+		return policy.RoleGuard
+	case mod.CLIENT:
+		return policy.RoleClient
+	case mod.PROPRIETOR:
+		return staffPolicyRoles[claimsStaffRole(claims)]
+	}
+	return ""
+}
+
+/*
+ * Permissions granted to the token of the request by the current policy.
+ */
+func GetPermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	role := claimsRole(claims)
+
+	resp := mod.Permissions{Role: string(role), Permissions: []string{}}
+	for _, p := range permissions.Permissions(role) {
+		resp.Permissions = append(resp.Permissions, p.String())
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/policy"
+)
+
+func (s *testServer) permissions(token string) mod.Permissions {
+	s.t.Helper()
+	var p mod.Permissions
+	rec := s.do("GET", "/v1/auth/permissions", nil, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &p)
+	return p
+}
+
+func hasPermission(p mod.Permissions, perm string) bool {
+	for _, v := range p.Permissions {
+		if v == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	auditor := s.staff(owner, "3333333333", mod.STAFF_AUDITOR)
+
+	p := s.permissions(owner)
+	if p.Role != string(policy.RoleOwner) || !hasPermission(p, "company:delete-all") {
+		t.Fatalf("owner permissions : %+v", p)
+	}
+	p = s.permissions(auditor)
+	if p.Role != string(policy.RoleAuditor) || hasPermission(p, "company:create") || !hasPermission(p, "company:read") {
+		t.Fatalf("auditor permissions : %+v", p)
+	}
+	p = s.permissions(guard)
+	if p.Role != string(policy.RoleGuard) || hasPermission(p, "guard:read") || !hasPermission(p, "patrol:create") {
+		t.Fatalf("guard permissions : %+v", p)
+	}
+	s.expect(s.do("GET", "/v1/auth/permissions", nil, ""), http.StatusUnauthorized)
+}
+
+func TestPolicyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+			t.Fatalf("write policy: %v", err)
+		}
+		return path
+	}
+
+	for name, body := range map[string]string{
+		"role.json":     `{"proprietor:admin": ["company:read"]}`,
+		"resource.json": `{"proprietor:owner": ["companies:read"]}`,
+		"action.json":   `{"proprietor:owner": ["company:list"]}`,
+		"format.json":   `{"proprietor:owner": ["company"]}`,
+	} {
+		if _, err := policy.Load(write(name, body)); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	auditor := s.staff(owner, "3333333333", mod.STAFF_AUDITOR)
+
+	p, err := policy.Load(write("policy.json", `{
+		"proprietor:owner": ["session:read", "company:read", "company:create"],
+		"proprietor:auditor": ["session:read", "guard:read", "company:read", "company:create"]
+	}`))
+	if err != nil {
+		t.Fatalf("load policy: %v", err)
+	}
+	SetPolicy(p)
+	defer SetPolicy(policy.Default())
+
+	s.company(auditor, "acme")
+	s.expect(s.do("DELETE", "/v1/company", nil, owner), http.StatusUnauthorized)
+	if got := s.permissions(auditor).Permissions; len(got) != 4 {
+		t.Fatalf("auditor permissions : %v", got)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+)
+
+/*
+ * Proprietor user of the token, owner or staff.
+ */
+func findProfile(ctx context.Context, claims jwt.MapClaims) (mod.Proprietor, error) {
+	p, err := store.Proprietors.FindByPhone(ctx, claims["phone"].(string))
+	if err == nil && p.Tenent != claims["tenent"].(string) {
+		err = db.ErrNotFound
+	}
+	return p, err
+}
+
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := findProfile(ctx, claims)
+	if err != nil {
+		util.Log.Printf("Unable to find proprietor: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "User NOT found."})
+		return
+	}
+	p.Password = ""
+	p.Role = staffRole(p)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p)
+}
+
+/*
+ * Partial update of the logged in proprietor user. The group is copied into the staff,
+ * guards and tokens of the tenent, so it is not renamed here.
+ */
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	version, fields, err := decodePatch(r, mod.Proprietor{}, "image")
+	if err != nil {
+		writeBadPatch(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := findProfile(ctx, claims)
+	if err == nil {
+		p, err = store.Proprietors.Update(ctx, p.Tenent, p.Id, version, fields)
+	}
+	if err != nil {
+		writeUpdateError(w, err, "User", claims["phone"].(string))
+		return
+	}
+	p.Password = ""
+	p.Role = staffRole(p)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p)
+}
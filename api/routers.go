@@ -3,10 +3,10 @@ package api
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/monitor_security/util"
+	db "github.com/monitor_security/db"
+	"github.com/monitor_security/policy"
 )
 
 type Route struct {
@@ -14,33 +14,29 @@ type Route struct {
 	Method     string
 	Pattern    string
 	HandleFunc http.HandlerFunc
-	Action     string
+	Permission policy.Permission
 }
 
 type Routes []Route
 
-func NewRouter() *mux.Router {
+//Persistence used by the handlers, set by NewRouter.
+var store *db.Store
+
+func NewRouter(s *db.Store) *mux.Router {
+	store = s
 	router := mux.NewRouter().StrictSlash(true)
 
 	for _, route := range routes {
 		var handler http.Handler
 		handler = route.HandleFunc
-		if strings.Contains(route.Action, "RoleAdminValidation") {
-			handler = IsAdmin(handler)
-		}
-		if strings.Contains(route.Action, "RoleProprietorValidation") {
-			handler = IsProprietor(handler)
+		//a route without a permission is a mistake, not a public route.
+		if route.Permission == (policy.Permission{}) {
+			panic(fmt.Sprintf("route %v has no permission", route.Name))
 		}
-		if strings.Contains(route.Action, "RoleGuardValidation") {
-			handler = IsGuard(handler)
-		}
-		if strings.Contains(route.Action, "RoleProprietorOrGuardValidation") {
-			handler = IsProprietorOrGuard(handler)
-		}
-
-		if strings.Contains(route.Action, "TokenValidation") {
-			handler = TokenValidator(handler)
+		if err := route.Permission.Validate(); err != nil {
+			panic(fmt.Sprintf("route %v : %v", route.Name, err))
 		}
+		handler = Authorize(route.Permission, handler)
 
 		handler = Logger(handler, route.Name)
 		router.
@@ -57,19 +53,6 @@ func Index(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "OK")
 }
 
-func Refresh(w http.ResponseWriter, r *http.Request) {
-	tokenStr := r.Header.Get("Authorization")
-	tokenStr, err := util.GenerateJWT(tokenStr)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	http.SetCookie(w, &http.Cookie{
-		Name:  "token",
-		Value: tokenStr,
-	})
-}
-
 var routes = Routes{
 	// Health check
 	Route{
@@ -77,7 +60,64 @@ var routes = Routes{
 		"GET",
 		"/v1/health",
 		Index,
-		"SkipValidation",
+		policy.Public,
+	},
+	Route{
+		"GetJWKS",
+		"GET",
+		"/.well-known/jwks.json",
+		GetJWKS,
+		policy.Public,
+	},
+	Route{
+		"Login",
+		"POST",
+		"/v1/auth/login",
+		Login,
+		policy.Public,
+	},
+	//------------------- Admin Login / Platform management ----------------
+	Route{
+		"AdminPasswordLogin",
+		"POST",
+		"/v1/auth/login-admin-password",
+		AdminPasswordLogin,
+		policy.Public,
+	},
+	Route{
+		"GetAllTenents",
+		"GET",
+		"/v1/admin/tenents",
+		GetAllTenents,
+		policy.Permission{Resource: policy.ResourceTenents, Action: policy.ActionRead},
+	},
+	Route{
+		"GetAllTenentStats",
+		"GET",
+		"/v1/admin/tenents/stats",
+		GetTenentStats,
+		policy.Permission{Resource: policy.ResourceTenents, Action: policy.ActionRead},
+	},
+	Route{
+		"GetTenentStats",
+		"GET",
+		"/v1/admin/tenent/{Tenent}/stats",
+		GetTenentStats,
+		policy.Permission{Resource: policy.ResourceTenents, Action: policy.ActionRead},
+	},
+	Route{
+		"SuspendTenent",
+		"PUT",
+		"/v1/admin/tenent/{Tenent}/suspend",
+		SuspendTenent,
+		policy.Permission{Resource: policy.ResourceTenents, Action: policy.ActionUpdate},
+	},
+	Route{
+		"ReactivateTenent",
+		"PUT",
+		"/v1/admin/tenent/{Tenent}/reactivate",
+		ReactivateTenent,
+		policy.Permission{Resource: policy.ResourceTenents, Action: policy.ActionUpdate},
 	},
 	//------------------- Proprietor Register/Logins -----------------------
 	Route{
@@ -85,28 +125,101 @@ var routes = Routes{
 		"POST",
 		"/v1/auth/register-proprietor",
 		RegisterProprietor,
-		"SkipValidation",
+		policy.Public,
 	},
 	Route{
 		"ProprietorPasswordLogin",
 		"POST",
 		"/v1/auth/login-proprietor-password",
 		ProprietorPasswordLogin,
-		"SkipValidation",
+		policy.Public,
+	},
+	//------------------- Proprietor two factor authentication -------------
+	Route{
+		"LoginTotp",
+		"POST",
+		"/v1/auth/login-2fa",
+		LoginTotp,
+		policy.Public,
+	},
+	Route{
+		"EnrollTotp",
+		"POST",
+		"/v1/auth/2fa/enroll",
+		EnrollTotp,
+		policy.Permission{Resource: policy.ResourceMfa, Action: policy.ActionCreate},
+	},
+	Route{
+		"ActivateTotp",
+		"POST",
+		"/v1/auth/2fa/activate",
+		ActivateTotp,
+		policy.Permission{Resource: policy.ResourceMfa, Action: policy.ActionUpdate},
+	},
+	Route{
+		"RegenerateRecoveryCodes",
+		"POST",
+		"/v1/auth/2fa/recovery-codes",
+		RegenerateRecoveryCodes,
+		policy.Permission{Resource: policy.ResourceMfa, Action: policy.ActionUpdate},
+	},
+	Route{
+		"DisableTotp",
+		"POST",
+		"/v1/auth/2fa/disable",
+		DisableTotp,
+		policy.Permission{Resource: policy.ResourceMfa, Action: policy.ActionDelete},
+	},
+	Route{
+		"SetTotpRequired",
+		"PUT",
+		"/v1/auth/2fa/required",
+		SetTotpRequired,
+		policy.Permission{Resource: policy.ResourceSettings, Action: policy.ActionUpdate},
+	},
+	//------------------- Proprietor profile -------------------------------
+	Route{
+		"GetProfile",
+		"GET",
+		"/v1/profile",
+		GetProfile,
+		policy.Permission{Resource: policy.ResourceProfile, Action: policy.ActionRead},
+	},
+	Route{
+		"UpdateProfile",
+		"PATCH",
+		"/v1/profile",
+		UpdateProfile,
+		policy.Permission{Resource: policy.ResourceProfile, Action: policy.ActionUpdate},
+	},
+	//------------------- OTP Login ( Proprietor or Guard ) ----------------
+	Route{
+		"RequestOtp",
+		"POST",
+		"/v1/auth/request-otp",
+		RequestOtp,
+		policy.Public,
+	},
+	Route{
+		"VerifyOtp",
+		"POST",
+		"/v1/auth/login-otp",
+		VerifyOtp,
+		policy.Public,
 	},
 	Route{
 		"AddGuard",
 		"POST",
 		"/v1/guard",
 		AddGuard,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionCreate},
 	},
 	Route{
 		"GetTenentsToRegisterForGuard",
 		"GET",
 		"/v1/auth/fetch-tenents/{Phone}",
 		GetValidTenentsToRegister,
-		"SkipValidation",
+		policy.Public,
 	},
 	//----------------- Guard Register/Login -------------------------------
 	Route{
@@ -114,14 +227,28 @@ var routes = Routes{
 		"POST",
 		"/v1/auth/register-guard",
 		RegisterGuard,
-		"SkipValidation",
+		policy.Public,
 	},
 	Route{
 		"GuardPasswordLogin",
 		"POST",
 		"/v1/auth/login-guard-password",
 		GuardPasswordLogin,
-		"SkipValidation",
+		policy.Public,
+	},
+	Route{
+		"GetGuardTenents",
+		"GET",
+		"/v1/auth/guard-tenents",
+		GetGuardTenents,
+		policy.Permission{Resource: policy.ResourceMembership, Action: policy.ActionRead},
+	},
+	Route{
+		"SwitchGuardTenent",
+		"POST",
+		"/v1/auth/switch-tenent",
+		SwitchGuardTenent,
+		policy.Permission{Resource: policy.ResourceMembership, Action: policy.ActionUpdate},
 	},
 	//----------------- Owner Operations w.r.t Guard -----------------------
 	Route{
@@ -129,29 +256,179 @@ var routes = Routes{
 		"GET",
 		"/v1/guards",
 		GetAllGuardsByOwner,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionRead},
 	},
 	Route{
 		"GetGuardById",
 		"GET",
 		"/v1/guard/{Id}",
 		GetGuardById,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionRead},
+	},
+	Route{
+		"UpdateGuard",
+		"PATCH",
+		"/v1/guard/{Id}",
+		UpdateGuard,
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionUpdate},
+	},
+	Route{
+		"ForceGuardPasswordReset",
+		"PUT",
+		"/v1/guard/{Id}/reset-password",
+		ForceGuardPasswordReset,
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionUpdate},
+	},
+	Route{
+		"SuspendGuard",
+		"PUT",
+		"/v1/guard/{Id}/suspend",
+		SuspendGuard,
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionUpdate},
+	},
+	Route{
+		"ReactivateGuard",
+		"PUT",
+		"/v1/guard/{Id}/reactivate",
+		ReactivateGuard,
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionUpdate},
+	},
+	Route{
+		"UnlockGuard",
+		"PUT",
+		"/v1/guard/{Id}/unlock",
+		UnlockGuard,
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionUnlock},
+	},
+	Route{
+		"GetAllInvitations",
+		"GET",
+		"/v1/invitations",
+		GetAllInvitations,
+		policy.Permission{Resource: policy.ResourceInvitation, Action: policy.ActionRead},
+	},
+	Route{
+		"ResendInvitation",
+		"POST",
+		"/v1/invitation/{Id}/resend",
+		ResendInvitation,
+		policy.Permission{Resource: policy.ResourceInvitation, Action: policy.ActionCreate},
+	},
+	Route{
+		"RevokeInvitation",
+		"DELETE",
+		"/v1/invitation/{Id}",
+		RevokeInvitation,
+		policy.Permission{Resource: policy.ResourceInvitation, Action: policy.ActionDelete},
 	},
 	Route{
 		"DeleteGuardById",
 		"DELETE",
 		"/v1/guard/{Id}",
 		DeleteGuardById,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceGuard, Action: policy.ActionDelete},
+	},
+	//----------------- Owner operations w.r.t Staff -----------------------
+	Route{
+		"AddStaff",
+		"POST",
+		"/v1/staff",
+		AddStaff,
+		policy.Permission{Resource: policy.ResourceStaff, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetAllStaff",
+		"GET",
+		"/v1/staff",
+		GetAllStaff,
+		policy.Permission{Resource: policy.ResourceStaff, Action: policy.ActionRead},
+	},
+	Route{
+		"UpdateStaffRole",
+		"PUT",
+		"/v1/staff/{Id}/role",
+		UpdateStaffRole,
+		policy.Permission{Resource: policy.ResourceStaff, Action: policy.ActionUpdate},
+	},
+	Route{
+		"DeleteStaffById",
+		"DELETE",
+		"/v1/staff/{Id}",
+		DeleteStaffById,
+		policy.Permission{Resource: policy.ResourceStaff, Action: policy.ActionDelete},
+	},
+	//----------------- Owner operations w.r.t Client ----------------------
+	Route{
+		"AddClient",
+		"POST",
+		"/v1/company/{Id}/client",
+		AddClient,
+		policy.Permission{Resource: policy.ResourceClient, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetAllClients",
+		"GET",
+		"/v1/clients",
+		GetAllClients,
+		policy.Permission{Resource: policy.ResourceClient, Action: policy.ActionRead},
+	},
+	Route{
+		"DeleteClientById",
+		"DELETE",
+		"/v1/client/{Id}",
+		DeleteClientById,
+		policy.Permission{Resource: policy.ResourceClient, Action: policy.ActionDelete},
 	},
 	//----------------- Refresh token Owner or Guard -----------------------
 	Route{
 		"RefreshToken",
-		"GET",
+		"POST",
 		"/v1/auth/token-refresh",
 		RefreshToken,
-		"SkipValidation",
+		policy.Public,
+	},
+	//----------------- Password change / reset Owner or Guard -------------
+	Route{
+		"ChangePassword",
+		"POST",
+		"/v1/auth/change-password",
+		ChangePassword,
+		policy.Permission{Resource: policy.ResourcePassword, Action: policy.ActionUpdate},
+	},
+	Route{
+		"ForgotPassword",
+		"POST",
+		"/v1/auth/forgot-password",
+		ForgotPassword,
+		policy.Public,
+	},
+	Route{
+		"ResetPassword",
+		"POST",
+		"/v1/auth/reset-password",
+		ResetPassword,
+		policy.Public,
+	},
+	Route{
+		"Logout",
+		"POST",
+		"/v1/auth/logout",
+		Logout,
+		policy.Permission{Resource: policy.ResourceSession, Action: policy.ActionDelete},
+	},
+	Route{
+		"LogoutAll",
+		"POST",
+		"/v1/auth/logout-all",
+		LogoutAll,
+		policy.Permission{Resource: policy.ResourceSession, Action: policy.ActionDelete},
+	},
+	Route{
+		"GetPermissions",
+		"GET",
+		"/v1/auth/permissions",
+		GetPermissions,
+		policy.Permission{Resource: policy.ResourceSession, Action: policy.ActionRead},
 	},
 	//----------------- Owner operation w.r.t Company ----------------------
 	Route{
@@ -159,21 +436,42 @@ var routes = Routes{
 		"POST",
 		"/v1/company",
 		AddCompany,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionCreate},
 	},
 	Route{
 		"DeleteAllCompanies",
 		"DELETE",
 		"/v1/company",
 		DeleteAllCompanies,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionDeleteAll},
+	},
+	Route{
+		"UpdateCompany",
+		"PATCH",
+		"/v1/company/{Id}",
+		UpdateCompany,
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionUpdate},
+	},
+	Route{
+		"SetCompanyGeofence",
+		"PUT",
+		"/v1/company/{Id}/geofence",
+		SetCompanyGeofence,
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionUpdate},
+	},
+	Route{
+		"DeleteCompanyGeofence",
+		"DELETE",
+		"/v1/company/{Id}/geofence",
+		DeleteCompanyGeofence,
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionUpdate},
 	},
 	Route{
 		"DeleteCompanyById",
 		"DELETE",
 		"/v1/company/{Id}",
 		DeleteCompanyById,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionDelete},
 	},
 	//--------------- Owner or Guard operations w.r.t Company --------------
 	Route{
@@ -181,14 +479,43 @@ var routes = Routes{
 		"GET",
 		"/v1/companies",
 		GetAllCompanies,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionRead},
 	},
 	Route{
 		"GetCompanyById",
 		"GET",
 		"/v1/company/{Id}",
 		GetCompanyById,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourceCompany, Action: policy.ActionRead},
+	},
+	//------------ Checkpoints ( tags at a company site ) ------------------
+	Route{
+		"AddCheckpoint",
+		"POST",
+		"/v1/company/{Id}/checkpoint",
+		AddCheckpoint,
+		policy.Permission{Resource: policy.ResourceCheckpoint, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetCheckpointsByCompanyId",
+		"GET",
+		"/v1/company/{Id}/checkpoints",
+		GetCheckpointsByCompanyId,
+		policy.Permission{Resource: policy.ResourceCheckpoint, Action: policy.ActionRead},
+	},
+	Route{
+		"UpdateCheckpoint",
+		"PATCH",
+		"/v1/checkpoint/{Id}",
+		UpdateCheckpoint,
+		policy.Permission{Resource: policy.ResourceCheckpoint, Action: policy.ActionUpdate},
+	},
+	Route{
+		"DeleteCheckpointById",
+		"DELETE",
+		"/v1/checkpoint/{Id}",
+		DeleteCheckpointById,
+		policy.Permission{Resource: policy.ResourceCheckpoint, Action: policy.ActionDelete},
 	},
 	//------------ Patrol ( owner or guard ) ------------------------------
 	Route{
@@ -196,14 +523,114 @@ var routes = Routes{
 		"POST",
 		"/v1/patrol/company/{Id}",
 		AddPatrolData,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourcePatrol, Action: policy.ActionCreate},
 	},
 	Route{
 		"GetAllPatrolDataByCompanyID",
 		"GET",
 		"/v1/patrol/company/{Id}",
 		GetAllPatrolsByCompanyId,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourcePatrol, Action: policy.ActionRead},
+	},
+	//------------ Patrol tours ( ordered tag routes ) ---------------------
+	Route{
+		"AddTourRoute",
+		"POST",
+		"/v1/company/{Id}/tour-route",
+		AddTourRoute,
+		policy.Permission{Resource: policy.ResourceTourRoute, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetTourRoutesByCompanyId",
+		"GET",
+		"/v1/company/{Id}/tour-routes",
+		GetTourRoutesByCompanyId,
+		policy.Permission{Resource: policy.ResourceTourRoute, Action: policy.ActionRead},
+	},
+	Route{
+		"DeleteTourRouteById",
+		"DELETE",
+		"/v1/tour-route/{Id}",
+		DeleteTourRouteById,
+		policy.Permission{Resource: policy.ResourceTourRoute, Action: policy.ActionDelete},
+	},
+	Route{
+		"StartTour",
+		"POST",
+		"/v1/tour-route/{Id}/start",
+		StartTour,
+		policy.Permission{Resource: policy.ResourceTour, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetActiveTour",
+		"GET",
+		"/v1/tour/active",
+		GetActiveTour,
+		policy.Permission{Resource: policy.ResourceTour, Action: policy.ActionRead},
+	},
+	Route{
+		"GetTourById",
+		"GET",
+		"/v1/tour/{Id}",
+		GetTourById,
+		policy.Permission{Resource: policy.ResourceTour, Action: policy.ActionRead},
+	},
+	Route{
+		"FinishTour",
+		"PUT",
+		"/v1/tour/{Id}/finish",
+		FinishTour,
+		policy.Permission{Resource: policy.ResourceTour, Action: policy.ActionUpdate},
+	},
+	Route{
+		"GetToursByCompanyId",
+		"GET",
+		"/v1/company/{Id}/tours",
+		GetToursByCompanyId,
+		policy.Permission{Resource: policy.ResourceTour, Action: policy.ActionRead},
+	},
+	//------------ Patrol schedules and compliance ------------------------
+	Route{
+		"AddSchedule",
+		"POST",
+		"/v1/company/{Id}/schedule",
+		AddSchedule,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionCreate},
+	},
+	Route{
+		"GetSchedulesByCompanyId",
+		"GET",
+		"/v1/company/{Id}/schedules",
+		GetSchedulesByCompanyId,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionRead},
+	},
+	Route{
+		"DeleteScheduleById",
+		"DELETE",
+		"/v1/schedule/{Id}",
+		DeleteScheduleById,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionDelete},
+	},
+	Route{
+		"GetMissedPatrols",
+		"GET",
+		"/v1/company/{Id}/missed-patrols",
+		GetMissedPatrols,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionRead},
+	},
+	Route{
+		"GetCompanyCompliance",
+		"GET",
+		"/v1/company/{Id}/compliance",
+		GetCompanyCompliance,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionRead},
+	},
+	Route{
+		"GetCompliance",
+		"GET",
+		"/v1/compliance",
+		GetCompliance,
+		policy.Permission{Resource: policy.ResourceSchedule, Action: policy.ActionRead},
 	},
 	//------------ Incident ( owner or guard ) ------------------------------
 	Route{
@@ -211,27 +638,34 @@ var routes = Routes{
 		"POST",
 		"/v1/incident/company/{Id}",
 		CreateIncident,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourceIncident, Action: policy.ActionCreate},
 	},
 	Route{
 		"UpdateIncidentById",
 		"PUT",
 		"/v1/incident/{Id}",
 		UpdateIncident,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourceIncident, Action: policy.ActionUpdate},
 	},
 	Route{
 		"GetAllIncidents",
 		"GET",
 		"/v1/incidents",
 		GetAllIncidents,
-		"TokenValidation RoleProprietorOrGuardValidation",
+		policy.Permission{Resource: policy.ResourceIncident, Action: policy.ActionRead},
+	},
+	Route{
+		"GetIncidentMedia",
+		"GET",
+		"/v1/incident/{Id}/media/{File}",
+		GetIncidentMedia,
+		policy.Permission{Resource: policy.ResourceIncident, Action: policy.ActionRead},
 	},
 	Route{
 		"DeleteIncidentById",
 		"DELETE",
 		"/v1/incident/{Id}",
 		DeleteIncidentById,
-		"TokenValidation RoleProprietorValidation",
+		policy.Permission{Resource: policy.ResourceIncident, Action: policy.ActionDelete},
 	},
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/policy"
+)
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+	rec := s.do("GET", "/v1/health", nil, "")
+	s.expect(rec, http.StatusOK)
+	if rec.Body.String() != "OK" {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	s := newTestServer(t)
+	for _, route := range routes {
+		if route.Permission == policy.Public {
+			continue
+		}
+		path := strings.NewReplacer("{Id}", "000000000000000000000000", "{Tenent}", "x", "{Phone}", "0123456789").Replace(route.Pattern)
+
+		rec := s.do(route.Method, path, nil, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%v: no token, expected 401 got %v", route.Name, rec.Code)
+		}
+		rec = s.do(route.Method, path, nil, "not-a-token")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%v: bad token, expected 401 got %v", route.Name, rec.Code)
+		}
+	}
+}
+
+func TestRoleEnforcement(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	tokens := map[policy.Role]string{
+		policy.RoleAdmin:   adminToken(t, s),
+		policy.RoleOwner:   owner,
+		policy.RoleAuditor: s.staff(owner, "3333333333", mod.STAFF_AUDITOR),
+		policy.RoleGuard:   s.guard(owner, tenent, "2222222222"),
+		policy.RoleClient:  s.client(owner, s.company(owner, "acme"), "4444444444"),
+	}
+
+	for _, route := range routes {
+		if route.Permission == policy.Public {
+			continue
+		}
+		path := strings.NewReplacer("{Id}", "000000000000000000000000", "{Tenent}", tenent).Replace(route.Pattern)
+		for role, tok := range tokens {
+			if permissions.Allows(role, route.Permission) {
+				continue
+			}
+			if rec := s.do(route.Method, path, nil, tok); rec.Code != http.StatusUnauthorized {
+				t.Errorf("%v: %v token, expected 401 got %v", route.Name, role, rec.Code)
+			}
+		}
+	}
+}
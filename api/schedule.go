@@ -0,0 +1,438 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+//longest period a compliance or missed patrol report covers.
+const maxReportPeriod = 31 * 24 * time.Hour
+
+var errBadPeriod = errors.New("use from and to as RFC3339 times, at most 31 days apart")
+
+/*
+ * Period of a report from the from and to query parameters, the last 24 hours by default.
+ */
+func reportPeriod(r *http.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errBadPeriod
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errBadPeriod
+		}
+		from = t
+	}
+	if !from.Before(to) || to.Sub(from) > maxReportPeriod {
+		return time.Time{}, time.Time{}, errBadPeriod
+	}
+	return from, to, nil
+}
+
+/*
+ * Compliance of a company with its schedules, over the windows starting in [from, to)
+ * which already ended. Windows before a schedule was created are not expected.
+ */
+func companyCompliance(ctx context.Context, company mod.Company, schedules []mod.PatrolSchedule, from, to time.Time) (mod.Compliance, error) {
+	c := mod.Compliance{
+		CompanyId:   company.Id.Hex(),
+		CompanyName: company.Name,
+		From:        from,
+		To:          to,
+		Shifts:      []mod.ShiftCompliance{},
+	}
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+	var patrols []mod.Patrol
+	if len(schedules) > 0 && from.Before(end) {
+		var err error
+		patrols, err = store.Patrols.ListBetween(ctx, company.Tenent, c.CompanyId, from, end)
+		if err != nil {
+			return mod.Compliance{}, err
+		}
+	}
+	for _, schedule := range schedules {
+		shift := mod.ShiftCompliance{ScheduleId: schedule.Id.Hex(), Shift: schedule.Name}
+		for _, w := range util.ScheduleWindows(schedule, from, to) {
+			if w.End.After(end) || w.Start.Before(schedule.Created) {
+				continue
+			}
+			shift.Expected++
+			if util.Patrolled(w, patrols) {
+				shift.Completed++
+			}
+		}
+		shift.Missed = shift.Expected - shift.Completed
+		shift.Rate = complianceRate(shift.Completed, shift.Expected)
+		c.Expected += shift.Expected
+		c.Completed += shift.Completed
+		c.Shifts = append(c.Shifts, shift)
+	}
+	c.Missed = c.Expected - c.Completed
+	c.Rate = complianceRate(c.Completed, c.Expected)
+	return c, nil
+}
+
+func complianceRate(completed, expected int) float64 {
+	if expected == 0 {
+		return 1
+	}
+	return float64(completed) / float64(expected)
+}
+
+/*
+ * Add a patrol schedule ( shift ) to a company, either every Interval minutes between
+ * Start and End or a Cron rule with a Window in minutes.
+ */
+func AddSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.PatrolSchedule
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := util.ValidateSchedule(req); err != nil {
+		util.Log.Printf("Invalid schedule %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := store.Companies.FindById(ctx, tenent, objID); err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + id})
+		return
+	}
+
+	req.Id = primitive.NilObjectID
+	req.Tenent = tenent
+	req.CompanyId = id
+	req.Created = time.Now().UTC()
+	req.EvaluatedUntil = req.Created
+	schedule, err := store.Schedules.Create(ctx, req)
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(schedule)
+}
+
+func GetSchedulesByCompanyId(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Schedules.List(ctx, claims["tenent"].(string), id)
+	if err != nil {
+		util.Log.Printf("Unable to find schedules: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.PatrolSchedules{Schedules: c})
+}
+
+/*
+ * Remove a schedule, missed patrols already recorded for it are kept.
+ */
+func DeleteScheduleById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = store.Schedules.DeleteById(ctx, claims["tenent"].(string), objID)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Schedule not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to delete schedule: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Schedule removed."})
+}
+
+/*
+ * Missed patrol exceptions of a company with a window starting in the period.
+ */
+func GetMissedPatrols(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.MissedPatrols.List(ctx, claims["tenent"].(string), id, from, to)
+	if err != nil {
+		util.Log.Printf("Unable to find missed patrols: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.MissedPatrols{MissedPatrols: c})
+}
+
+/*
+ * Compliance of a company with its schedules, in total and per shift.
+ */
+func GetCompanyCompliance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	company, err := store.Companies.FindById(ctx, tenent, objID)
+	if err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + id})
+		return
+	}
+	schedules, err := store.Schedules.List(ctx, tenent, id)
+	if err != nil {
+		util.Log.Printf("Unable to find schedules: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c, err := companyCompliance(ctx, company, schedules, from, to)
+	if err != nil {
+		util.Log.Printf("Unable to find patrol data: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
+
+/*
+ * Compliance of every company of the tenent.
+ */
+func GetCompliance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	from, to, err := reportPeriod(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	companies, err := store.Companies.List(ctx, tenent)
+	if err != nil {
+		util.Log.Printf("Unable to find companies: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	schedules, err := store.Schedules.ListByTenent(ctx, tenent)
+	if err != nil {
+		util.Log.Printf("Unable to find schedules: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	byCompany := map[string][]mod.PatrolSchedule{}
+	for _, s := range schedules {
+		byCompany[s.CompanyId] = append(byCompany[s.CompanyId], s)
+	}
+
+	result := mod.Compliances{Companies: []mod.Compliance{}}
+	for _, company := range companies {
+		c, err := companyCompliance(ctx, company, byCompany[company.Id.Hex()], from, to)
+		if err != nil {
+			util.Log.Printf("Unable to find patrol data: %v", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		result.Companies = append(result.Companies, c)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+/*
+ * Record a MissedPatrol for every schedule window that ended since the last run without
+ * a patrol at the company. Windows are recorded once, so overlapping runs are harmless.
+ */
+func EvaluateSchedules(s *db.Store, now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	schedules, err := s.Schedules.ListAll(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	//each schedule gets its own timeout, a slow one does not hold up the rest.
+	for _, schedule := range schedules {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := evaluateSchedule(ctx, s, schedule, now); err != nil {
+			util.Log.Printf("Unable to evaluate schedule %v : %v", schedule.Id.Hex(), err)
+		}
+		cancel()
+	}
+	return nil
+}
+
+func evaluateSchedule(ctx context.Context, s *db.Store, schedule mod.PatrolSchedule, now time.Time) error {
+	since := schedule.EvaluatedUntil
+	if since.Before(schedule.Created) {
+		since = schedule.Created
+	}
+	if !since.Before(now) {
+		return nil
+	}
+	//a window ending now may have started up to a day ago.
+	from := since.Add(-24 * time.Hour)
+	if from.Before(schedule.Created) {
+		from = schedule.Created
+	}
+	var windows []util.Window
+	for _, w := range util.ScheduleWindows(schedule, from, now) {
+		if w.End.After(since) && !w.End.After(now) {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) > 0 {
+		patrols, err := s.Patrols.ListBetween(ctx, schedule.Tenent, schedule.CompanyId, windows[0].Start, now)
+		if err != nil {
+			return err
+		}
+		var companyName string
+		if id, err := primitive.ObjectIDFromHex(schedule.CompanyId); err == nil {
+			if company, err := s.Companies.FindById(ctx, schedule.Tenent, id); err == nil {
+				companyName = company.Name
+			}
+		}
+		for _, w := range windows {
+			if util.Patrolled(w, patrols) {
+				continue
+			}
+			missed := mod.MissedPatrol{
+				Tenent:      schedule.Tenent,
+				CompanyId:   schedule.CompanyId,
+				CompanyName: companyName,
+				ScheduleId:  schedule.Id.Hex(),
+				Shift:       schedule.Name,
+				From:        w.Start,
+				To:          w.End,
+				Created:     now,
+			}
+			if err := s.MissedPatrols.Create(ctx, missed); err != nil && err != db.ErrDuplicate {
+				return err
+			}
+		}
+	}
+	return s.Schedules.SetEvaluated(ctx, schedule.Id, now)
+}
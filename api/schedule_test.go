@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSchedules(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	acme := s.company(owner, "acme")
+
+	night := mod.PatrolSchedule{Name: "night", Start: "22:00", End: "06:00", Interval: 120, TimeZone: "Asia/Kolkata"}
+	s.expect(s.do("POST", "/v1/company/"+acme+"/schedule", night, auditor), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/company/000000000000000000000000/schedule", night, owner), http.StatusNotFound)
+	for _, c := range []mod.PatrolSchedule{
+		{Name: "ab", Start: "22:00", End: "06:00", Interval: 120},
+		{Name: "night", Start: "25:00", End: "06:00", Interval: 120},
+		{Name: "night", Start: "22:00", End: "06:00", Interval: 1},
+		{Name: "night", Start: "22:00", End: "06:00", Interval: 120, TimeZone: "Mars/Base"},
+		{Name: "night", Start: "22:00", End: "06:00", Interval: 120, Cron: "0 * * * *"},
+		{Name: "hourly", Cron: "0 * * *", Window: 60},
+		{Name: "hourly", Cron: "61 * * * *", Window: 60},
+		{Name: "hourly", Cron: "0 * * * *"},
+	} {
+		s.expect(s.do("POST", "/v1/company/"+acme+"/schedule", c, owner), http.StatusBadRequest)
+	}
+
+	var schedule mod.PatrolSchedule
+	rec := s.do("POST", "/v1/company/"+acme+"/schedule", night, owner)
+	s.expect(rec, http.StatusCreated)
+	decode(t, rec, &schedule)
+	if schedule.Id.IsZero() || schedule.CompanyId != acme || schedule.Created.IsZero() {
+		t.Fatalf("unexpected schedule %+v", schedule)
+	}
+	s.expect(s.do("POST", "/v1/company/"+acme+"/schedule", mod.PatrolSchedule{Name: "weekdays", Cron: "0 9-17/2 * * 1-5", Window: 30}, owner), http.StatusCreated)
+
+	var schedules mod.PatrolSchedules
+	rec = s.do("GET", "/v1/company/"+acme+"/schedules", nil, auditor)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &schedules)
+	if len(schedules.Schedules) != 2 {
+		t.Fatalf("unexpected schedules %+v", schedules.Schedules)
+	}
+
+	s.expect(s.do("DELETE", "/v1/schedule/"+schedule.Id.Hex(), nil, auditor), http.StatusUnauthorized)
+	s.expect(s.do("DELETE", "/v1/schedule/"+schedule.Id.Hex(), nil, owner), http.StatusOK)
+	s.expect(s.do("DELETE", "/v1/schedule/"+schedule.Id.Hex(), nil, owner), http.StatusNotFound)
+
+	other, _ := s.proprietor("3333333333", "beta")
+	rec = s.do("GET", "/v1/company/"+acme+"/schedules", nil, other)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &schedules)
+	if len(schedules.Schedules) != 0 {
+		t.Fatalf("schedules of another tenent listed %+v", schedules.Schedules)
+	}
+}
+
+func TestMissedPatrolsAndCompliance(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	acme := s.company(owner, "acme")
+	globex := s.company(owner, "globex")
+	ctx := context.Background()
+
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	hourly, _ := s.store.Schedules.Create(ctx, mod.PatrolSchedule{Tenent: tenent, CompanyId: acme, Name: "hourly",
+		Cron: "0 * * * *", Window: 60, TimeZone: "UTC", Created: day, EvaluatedUntil: day})
+	//shift of the previous day still running at midnight
+	s.store.Schedules.Create(ctx, mod.PatrolSchedule{Tenent: tenent, CompanyId: acme, Name: "night",
+		Start: "22:00", End: "02:00", Interval: 120, TimeZone: "UTC", Created: day.Add(-24 * time.Hour), EvaluatedUntil: day})
+	s.store.Patrols.Create(ctx, mod.Patrol{Tenent: tenent, CompanyId: acme, Date: day.Add(90 * time.Minute), RFData: "gate"})
+	//patrols of another company do not count
+	s.store.Patrols.Create(ctx, mod.Patrol{Tenent: tenent, CompanyId: globex, Date: day.Add(150 * time.Minute), RFData: "gate"})
+	//nor do flagged patrols
+	s.store.Patrols.Create(ctx, mod.Patrol{Tenent: tenent, CompanyId: acme, Date: day.Add(130 * time.Minute), RFData: "gate", OutsideSite: true, SiteDistance: 5000})
+	s.store.Patrols.Create(ctx, mod.Patrol{Tenent: tenent, CompanyId: acme, Date: day.Add(140 * time.Minute), RFData: "home", UnknownTag: true})
+
+	missed := func() []mod.MissedPatrol {
+		var m mod.MissedPatrols
+		rec := s.do("GET", "/v1/company/"+acme+"/missed-patrols?from=2026-01-04T00:00:00Z&to=2026-01-06T00:00:00Z", nil, auditor)
+		s.expect(rec, http.StatusOK)
+		decode(t, rec, &m)
+		return m.MissedPatrols
+	}
+
+	if err := EvaluateSchedules(s.store, day.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	m := missed()
+	if len(m) != 2 || !m[0].From.Equal(day) || !m[1].From.Equal(day.Add(2*time.Hour)) ||
+		m[0].Shift != "hourly" || m[0].ScheduleId != hourly.Id.Hex() || m[0].CompanyName != "acme" || !m[0].To.Equal(day.Add(time.Hour)) {
+		t.Fatalf("unexpected missed patrols %+v", m)
+	}
+	//windows are recorded once, the 03:00 window has not ended yet
+	EvaluateSchedules(s.store, day.Add(3*time.Hour+30*time.Minute))
+	if m = missed(); len(m) != 2 {
+		t.Fatalf("unexpected missed patrols %+v", m)
+	}
+	EvaluateSchedules(s.store, day.Add(4*time.Hour))
+	if m = missed(); len(m) != 3 || !m[2].From.Equal(day.Add(3*time.Hour)) {
+		t.Fatalf("unexpected missed patrols %+v", m)
+	}
+
+	var c mod.Compliance
+	rec := s.do("GET", "/v1/company/"+acme+"/compliance?from=2026-01-05T00:00:00Z&to=2026-01-05T04:00:00Z", nil, auditor)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &c)
+	if c.CompanyName != "acme" || c.Expected != 5 || c.Completed != 2 || c.Missed != 3 || c.Rate != 0.4 || len(c.Shifts) != 2 {
+		t.Fatalf("unexpected compliance %+v", c)
+	}
+	for _, shift := range c.Shifts {
+		if shift.Shift == "hourly" && (shift.Expected != 4 || shift.Completed != 1 || shift.Rate != 0.25) ||
+			shift.Shift == "night" && (shift.Expected != 1 || shift.Completed != 1 || shift.Rate != 1) {
+			t.Fatalf("unexpected shift compliance %+v", shift)
+		}
+	}
+
+	var all mod.Compliances
+	rec = s.do("GET", "/v1/compliance?from=2026-01-05T00:00:00Z&to=2026-01-05T04:00:00Z", nil, auditor)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &all)
+	if len(all.Companies) != 2 {
+		t.Fatalf("unexpected compliance %+v", all.Companies)
+	}
+	for _, c := range all.Companies {
+		if c.CompanyId == globex && (c.Expected != 0 || c.Rate != 1 || len(c.Shifts) != 0) ||
+			c.CompanyId == acme && c.Expected != 5 {
+			t.Fatalf("unexpected compliance %+v", c)
+		}
+	}
+
+	for _, q := range []string{"from=yesterday", "from=2026-01-05T04:00:00Z&to=2026-01-05T00:00:00Z", "from=2025-01-01T00:00:00Z&to=2026-01-05T00:00:00Z"} {
+		s.expect(s.do("GET", "/v1/company/"+acme+"/compliance?"+q, nil, auditor), http.StatusBadRequest)
+		s.expect(s.do("GET", "/v1/company/"+acme+"/missed-patrols?"+q, nil, auditor), http.StatusBadRequest)
+	}
+	s.expect(s.do("GET", "/v1/company/"+primitive.NewObjectID().Hex()+"/compliance", nil, auditor), http.StatusNotFound)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+//Staff set their password with the reset code sent in the invitation.
+const staffInviteExpiry = 24 * time.Hour
+
+/*
+ * Sub-role of a proprietor user, owners registered before staff existed have none.
+ */
+func staffRole(user mod.Proprietor) string {
+	if user.Role == "" {
+		return mod.STAFF_OWNER
+	}
+	return user.Role
+}
+
+func claimsStaffRole(claims jwt.MapClaims) string {
+	role, _ := claims["role"].(string)
+	if role == "" {
+		return mod.STAFF_OWNER
+	}
+	return role
+}
+
+/*
+ * Find a staff member of the tenent, the owner is not staff and can not be changed here.
+ */
+func findStaff(ctx context.Context, w http.ResponseWriter, tenent, id string) (mod.Proprietor, bool) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return mod.Proprietor{}, false
+	}
+	user, err := store.Proprietors.FindById(ctx, tenent, objID)
+	if err == nil && staffRole(user) == mod.STAFF_OWNER {
+		err = db.ErrNotFound
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Staff not found: " + id})
+		return user, false
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find staff: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return user, false
+	}
+	return user, true
+}
+
+/*
+ * Owner invites a staff member, the staff logs in as a proprietor of the tenent with
+ * the given sub-role once the password is set with the code sent to the phone.
+ */
+func AddStaff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.StaffInvite
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	owner, err := store.Proprietors.FindByTenent(ctx, tenent)
+	if err != nil {
+		util.Log.Printf("Unable to find tenent owner: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	//an empty password never matches, the staff can only login after setting it.
+	user := mod.Proprietor{
+		Tenent:     tenent,
+		Group:      owner.Group,
+		Phone:      req.Phone,
+		UserType:   mod.PROPRIETOR,
+		Role:       req.Role,
+		Active:     owner.Active,
+		Require2FA: owner.Require2FA,
+	}
+	err = store.Proprietors.Create(ctx, user)
+	if err == db.ErrDuplicate {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Phone already registered as a proprietor user."})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user, err = store.Proprietors.FindByPhone(ctx, req.Phone); err != nil {
+		util.Log.Printf("Unable to find staff : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	msg := fmt.Sprintf("You are invited to join %v as %v, set your password with the code %%v, valid for %%v minutes.",
+		owner.Group, req.Role)
+	if err := sendOtp(ctx, "", req.Phone, mod.PROPRIETOR, mod.OTP_RESET, staffInviteExpiry, 0, msg); err != nil {
+		util.Log.Printf("Unable to send staff invitation : %v", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Staff added, unable to send invitation, staff can use forgot password."})
+		return
+	}
+
+	user.Password = ""
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+/*
+ * List the owner and staff of the tenent.
+ */
+func GetAllStaff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Proprietors.ListByTenent(ctx, claims["tenent"].(string))
+	if err != nil {
+		util.Log.Printf("Unable to find staff: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for i := range c {
+		c[i].Password = ""
+		c[i].Role = staffRole(c[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Proprietors{Proprietors: c})
+}
+
+/*
+ * Change the sub-role of a staff member, the staff is logged out so the new role applies.
+ */
+func UpdateStaffRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.StaffRole
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findStaff(ctx, w, tenent, mux.Vars(r)["Id"])
+	if !ok {
+		return
+	}
+	if err := store.Proprietors.SetRole(ctx, tenent, user.Id, req.Role); err != nil {
+		util.Log.Printf("Unable to update staff role: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllSessions(ctx, mod.PROPRIETOR, tenent, user.Phone); err != nil {
+		util.Log.Printf("Unable to revoke staff tokens: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Staff role updated."})
+}
+
+/*
+ * Remove a staff member from the tenent, all its sessions are revoked.
+ */
+func DeleteStaffById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findStaff(ctx, w, tenent, mux.Vars(r)["Id"])
+	if !ok {
+		return
+	}
+	if err := store.Proprietors.DeleteById(ctx, tenent, user.Id); err != nil {
+		util.Log.Printf("Unable to delete staff: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllSessions(ctx, mod.PROPRIETOR, tenent, user.Phone); err != nil {
+		util.Log.Printf("Unable to revoke staff tokens: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Staff removed."})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+/*
+ * Invite a staff member, set the password with the code sent and login, returns the token.
+ */
+func (s *testServer) staff(ownerToken, phone, role string) string {
+	s.t.Helper()
+	s.expect(s.do("POST", "/v1/staff", mod.StaffInvite{Phone: phone, Role: role}, ownerToken), http.StatusCreated)
+
+	//no password until the invitation code is used
+	login := mod.ProprietorPasswordLogin{Phone: phone, Password: newPassword, UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusUnauthorized)
+
+	reset := mod.PasswordReset{Phone: phone, UserType: mod.PROPRIETOR, Code: s.sms.code(phone), NewPassword: newPassword}
+	s.expect(s.do("POST", "/v1/auth/reset-password", reset, ""), http.StatusOK)
+
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", login, "")
+	s.expect(rec, http.StatusOK)
+	return tokenCookie(s.t, rec)
+}
+
+func TestStaffRoles(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	manager := s.staff(owner, "5555555555", mod.STAFF_MANAGER)
+	auditor := s.staff(owner, "6666666666", mod.STAFF_AUDITOR)
+
+	s.expect(s.do("POST", "/v1/staff", mod.StaffInvite{Phone: "1111111111", Role: mod.STAFF_MANAGER}, owner), http.StatusConflict)
+	s.expect(s.do("POST", "/v1/staff", mod.StaffInvite{Phone: "7777777777", Role: mod.STAFF_OWNER}, owner), http.StatusBadRequest)
+
+	//manager runs the tenent but can not manage staff
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, manager), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/staff", mod.StaffInvite{Phone: "7777777777", Role: mod.STAFF_AUDITOR}, manager), http.StatusUnauthorized)
+	var staff mod.Proprietors
+	rec := s.do("GET", "/v1/staff", nil, manager)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &staff)
+	if len(staff.Proprietors) != 3 {
+		t.Fatalf("expected owner and 2 staff, got %+v", staff)
+	}
+
+	//auditor is read only
+	s.expect(s.do("GET", "/v1/guards", nil, auditor), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, auditor), http.StatusOK)
+	s.expect(s.do("POST", "/v1/company", mod.Company{Name: "acme", Address: "1 Main St", Phone: "0123456789"}, auditor), http.StatusUnauthorized)
+
+	//a role change logs the staff out
+	var auditorId string
+	for _, p := range staff.Proprietors {
+		if p.Phone == "6666666666" {
+			auditorId = p.Id.Hex()
+		}
+		if p.Tenent != tenent {
+			t.Fatalf("staff of another tenent listed")
+		}
+	}
+	s.expect(s.do("PUT", "/v1/staff/"+auditorId+"/role", mod.StaffRole{Role: mod.STAFF_SUPERVISOR}, manager), http.StatusUnauthorized)
+	s.expect(s.do("PUT", "/v1/staff/"+auditorId+"/role", mod.StaffRole{Role: mod.STAFF_SUPERVISOR}, owner), http.StatusOK)
+	s.expect(s.do("GET", "/v1/guards", nil, auditor), http.StatusUnauthorized)
+
+	//the owner is not staff, removed staff can not login
+	other, _ := s.proprietor("3333333333", "beta")
+	s.expect(s.do("DELETE", "/v1/staff/"+auditorId, nil, other), http.StatusNotFound)
+	s.expect(s.do("DELETE", "/v1/staff/"+auditorId, nil, owner), http.StatusOK)
+	login := mod.ProprietorPasswordLogin{Phone: "6666666666", Password: newPassword, UserType: mod.PROPRIETOR}
+	s.expect(s.do("POST", "/v1/auth/login-proprietor-password", login, ""), http.StatusUnauthorized)
+
+	//staff are not tenents
+	var tenents mod.Proprietors
+	rec = s.do("GET", "/v1/admin/tenents", nil, adminToken(t, s))
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tenents)
+	if len(tenents.Proprietors) != 2 {
+		t.Fatalf("expected 2 tenents, got %v", len(tenents.Proprietors))
+	}
+}
+
+func TestSuspendTenentSuspendsStaff(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	manager := s.staff(owner, "5555555555", mod.STAFF_MANAGER)
+
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+tenent+"/suspend", nil, adminToken(t, s)), http.StatusOK)
+	s.expect(s.do("GET", "/v1/guards", nil, manager), http.StatusUnauthorized)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"gopkg.in/validator.v2"
+)
+
+/*
+ * Tenents the guard can work for, registered and active in an active tenent.
+ */
+func guardTenents(ctx context.Context, phone string) ([]mod.Guard, error) {
+	guards, err := store.Guards.ListByPhone(ctx, phone)
+	if err != nil {
+		return nil, err
+	}
+	var c []mod.Guard
+	for _, g := range guards {
+		if g.Registered && g.Active && isTenentActive(ctx, g.Tenent) {
+			c = append(c, g)
+		}
+	}
+	return c, nil
+}
+
+/*
+ * List the tenents the logged in guard belongs to.
+ */
+func GetGuardTenents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	guards, err := guardTenents(ctx, claims["phone"].(string))
+	if err != nil {
+		util.Log.Printf("Unable to find guard tenents: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c := []mod.TenentGroup{}
+	for _, g := range guards {
+		c = append(c, mod.TenentGroup{Tenent: g.Tenent, Group: g.Group})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.GuardTenents{Current: claims["tenent"].(string), Tenents: c})
+}
+
+/*
+ * Exchange the guard's token for a new login session in another tenent of the same
+ * phone, the current token and its session are revoked.
+ */
+func SwitchGuardTenent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.SwitchTenent
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	phone := claims["phone"].(string)
+	if req.Tenent == claims["tenent"].(string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Already logged into tenent: " + req.Tenent})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//same active check as Authorize, applied to the target tenent.
+	target := jwt.MapClaims{"tenent": req.Tenent, "phone": phone}
+	user, err := store.Guards.FindByPhone(ctx, req.Tenent, phone)
+	if err != nil || !user.Registered || !isGuardActive(ctx, target) {
+		util.Log.Printf("Guard %v can not switch to tenent %v : %v", phone, req.Tenent, err)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Not an active guard of tenent: " + req.Tenent})
+		return
+	}
+	wait, err := loginLockout(ctx, accountKey(mod.GUARD, req.Tenent, phone))
+	if err != nil {
+		util.Log.Printf("Unable to check login lockout : %v", err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to switch tenent."})
+		return
+	}
+	if wait > 0 {
+		util.Log.Printf("Login locked for : %v", phone)
+		writeLockedOut(w, wait)
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	err = store.Revocations.RevokeToken(ctx, jti, claimsExpiry(claims))
+	if sid, _ := claims["sid"].(string); err == nil && sid != "" {
+		err = store.RefreshTokens.RevokeFamily(ctx, sid)
+	}
+	if err != nil {
+		util.Log.Printf("Unable to revoke token : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to switch tenent."})
+		return
+	}
+
+	writeLoginToken(w, r, &mod.GuardTokenData{
+		UserType: user.UserType,
+		Tenent:   user.Tenent,
+		Phone:    user.Phone,
+		Name:     user.Name,
+		Group:    user.Group,
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func (s *testServer) guardTenents(token string) mod.GuardTenents {
+	s.t.Helper()
+	var tenents mod.GuardTenents
+	rec := s.do("GET", "/v1/auth/guard-tenents", nil, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &tenents)
+	return tenents
+}
+
+func TestSwitchGuardTenent(t *testing.T) {
+	s := newTestServer(t)
+	alpha, alphaTenent := s.proprietor("1111111111", "alpha")
+	beta, betaTenent := s.proprietor("3333333333", "beta")
+	_, gammaTenent := s.proprietor("4444444444", "gamma")
+	s.guard(beta, betaTenent, "2222222222")
+	guard := s.guard(alpha, alphaTenent, "2222222222")
+
+	tenents := s.guardTenents(guard)
+	if tenents.Current != alphaTenent || len(tenents.Tenents) != 2 {
+		t.Fatalf("unexpected tenents : %+v", tenents)
+	}
+
+	s.expect(s.do("POST", "/v1/auth/switch-tenent", mod.SwitchTenent{Tenent: gammaTenent}, guard), http.StatusForbidden)
+	s.expect(s.do("POST", "/v1/auth/switch-tenent", mod.SwitchTenent{Tenent: alphaTenent}, guard), http.StatusBadRequest)
+
+	rec := s.do("POST", "/v1/auth/switch-tenent", mod.SwitchTenent{Tenent: betaTenent}, guard)
+	s.expect(rec, http.StatusOK)
+	switched := tokenCookie(t, rec)
+	if s.guardTenents(switched).Current != betaTenent {
+		t.Fatalf("token not scoped to the new tenent")
+	}
+	//the exchanged token stops working
+	s.expect(s.do("GET", "/v1/auth/guard-tenents", nil, guard), http.StatusUnauthorized)
+
+	//a suspended tenent is not listed and can not be switched to
+	s.expect(s.do("PUT", "/v1/admin/tenent/"+alphaTenent+"/suspend", nil, adminToken(t, s)), http.StatusOK)
+	if len(s.guardTenents(switched).Tenents) != 1 {
+		t.Fatalf("suspended tenent listed")
+	}
+	s.expect(s.do("POST", "/v1/auth/switch-tenent", mod.SwitchTenent{Tenent: alphaTenent}, switched), http.StatusForbidden)
+}
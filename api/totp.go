@@ -0,0 +1,466 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"gopkg.in/validator.v2"
+)
+
+const (
+	totpIssuer        = "MonitorSecurity"
+	mfaExpiry         = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+/*
+ * Last step of a proprietor login, the token is only issued straight away when the
+ * user has no second factor and the tenent does not require one. Otherwise an mfa
+ * token is returned which has to be sent with a TOTP or recovery code to LoginTotp.
+ */
+func completeProprietorLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, user mod.Proprietor) {
+	if !user.Totp.Enabled && !user.Require2FA {
+		loginSucceeded(ctx, accountKey(mod.PROPRIETOR, "", user.Phone))
+		writeLoginToken(w, r, &mod.OwnerTokenData{
+			UserType: user.UserType,
+			Tenent:   user.Tenent,
+			Phone:    user.Phone,
+			Group:    user.Group,
+			Role:     staffRole(user),
+		})
+		return
+	}
+
+	token, err := util.GenerateToken(32)
+	if err != nil {
+		util.Log.Printf("Unable to generate mfa token : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	challenge := mod.MfaChallenge{Status: "Second factor required.", MfaToken: token}
+
+	//2FA is required by the tenent, the user enrols as part of this login.
+	if !user.Totp.Enabled {
+		secret, err := util.GenerateTotpSecret()
+		if err == nil {
+			user.Totp.PendingSecret = secret
+			err = store.Proprietors.UpdateTotp(ctx, user.Id, user.Totp)
+		}
+		if err != nil {
+			util.Log.Printf("Unable to start totp enrolment : %v", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		challenge.Status = "Two factor authentication required, enrol the secret and send a code."
+		challenge.Enroll = true
+		challenge.Secret = secret
+		challenge.URI = util.TotpURI(totpIssuer, user.Phone, secret)
+	}
+
+	t := time.Now()
+	otp := mod.Otp{
+		Phone:    user.Phone,
+		UserType: mod.PROPRIETOR,
+		Purpose:  mod.OTP_MFA,
+		Code:     util.HashCode(token),
+		Created:  t,
+		Expires:  t.Add(mfaExpiry),
+	}
+	if err := store.Otps.Save(ctx, otp); err != nil {
+		util.Log.Printf("Unable to save mfa token : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(challenge)
+}
+
+/*
+ * Second login step of a proprietor, the mfa token from the first step with either a
+ * TOTP code or a recovery code. Wrong codes count as failed logins.
+ */
+func LoginTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var login mod.MfaLogin
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(login); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if (login.Code == "") == (login.RecoveryCode == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Either code or recoverycode is required."})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account := accountKey(mod.PROPRIETOR, "", login.Phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	invalid := mod.ErrorResponse{Error: "Code is invalid or login expired."}
+	challenge, err := store.Otps.Find(ctx, "", login.Phone, mod.PROPRIETOR, mod.OTP_MFA)
+	if err == nil && (challenge.Used || time.Now().After(challenge.Expires) || !util.CheckCode(challenge.Code, login.MfaToken)) {
+		err = fmt.Errorf("mfa token used, expired or wrong")
+	}
+	//the attempt is counted before the second factor is checked, so parallel guesses can not pass the limit.
+	if err == nil {
+		_, err = store.Otps.CountAttempt(ctx, challenge.Id, otpMaxAttempts)
+	}
+	var user mod.Proprietor
+	if err == nil {
+		user, err = store.Proprietors.FindByPhone(ctx, login.Phone)
+	}
+	if err == nil && !user.Active {
+		err = db.ErrNotFound
+	}
+	if err != nil {
+		util.Log.Printf("Mfa login not accepted for %v : %v", login.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+
+	if err := checkSecondFactor(ctx, user, login.Code, login.RecoveryCode); err != nil {
+		util.Log.Printf("Second factor not accepted for %v : %v", login.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	//Single use, only one concurrent login can consume the mfa token.
+	if err := store.Otps.Consume(ctx, challenge.Id); err != nil {
+		util.Log.Printf("Mfa token already used for %v", login.Phone)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	attempt.refund(ctx)
+	loginSucceeded(ctx, account)
+
+	writeLoginToken(w, r, &mod.OwnerTokenData{
+		UserType: user.UserType,
+		Tenent:   user.Tenent,
+		Phone:    user.Phone,
+		Group:    user.Group,
+		Role:     staffRole(user),
+	})
+}
+
+/*
+ * Verify a TOTP or recovery code of the user. A user enrolling at login is verified
+ * against the pending secret, which is then enabled without recovery codes, those
+ * are generated after login with RegenerateRecoveryCodes.
+ */
+func checkSecondFactor(ctx context.Context, user mod.Proprietor, code, recovery string) error {
+	if !user.Totp.Enabled {
+		if code == "" || user.Totp.PendingSecret == "" {
+			return fmt.Errorf("totp not enrolled")
+		}
+		step, ok := util.ValidateTotp(user.Totp.PendingSecret, code, time.Now())
+		if !ok {
+			return fmt.Errorf("totp code did not match")
+		}
+		return store.Proprietors.UpdateTotp(ctx, user.Id, mod.Totp{
+			Secret:   user.Totp.PendingSecret,
+			Enabled:  true,
+			LastStep: step,
+		})
+	}
+	if recovery != "" {
+		return store.Proprietors.UseRecoveryCode(ctx, user.Id, util.HashRecoveryCode(recovery))
+	}
+	return checkTotp(ctx, user, code)
+}
+
+/*
+ * Verify a code against the enabled secret, a step is only accepted once.
+ */
+func checkTotp(ctx context.Context, user mod.Proprietor, code string) error {
+	step, ok := util.ValidateTotp(user.Totp.Secret, code, time.Now())
+	if !ok {
+		return fmt.Errorf("totp code did not match")
+	}
+	if err := store.Proprietors.UseTotpStep(ctx, user.Id, step); err != nil {
+		return fmt.Errorf("totp code already used")
+	}
+	return nil
+}
+
+/*
+ * Generate recovery codes, only the hashes are stored.
+ */
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := util.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = util.HashRecoveryCode(c)
+	}
+	return codes, hashes, nil
+}
+
+func decodeTotpCode(w http.ResponseWriter, r *http.Request) (mod.TotpCode, bool) {
+	var req mod.TotpCode
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = validator.NewValidator().Validate(req)
+	}
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+func findClaimedProprietor(ctx context.Context, w http.ResponseWriter, r *http.Request) (mod.Proprietor, bool) {
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	user, err := store.Proprietors.FindByPhone(ctx, claims["phone"].(string))
+	if err != nil {
+		util.Log.Printf("Unable to find user : %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "User NOT found."})
+		return user, false
+	}
+	return user, true
+}
+
+/*
+ * Start TOTP enrolment, returns a new secret and its otpauth uri for the authenticator app.
+ */
+func EnrollTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findClaimedProprietor(ctx, w, r)
+	if !ok {
+		return
+	}
+	if user.Totp.Enabled {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Two factor authentication already enabled."})
+		return
+	}
+
+	secret, err := util.GenerateTotpSecret()
+	if err == nil {
+		user.Totp.PendingSecret = secret
+		err = store.Proprietors.UpdateTotp(ctx, user.Id, user.Totp)
+	}
+	if err != nil {
+		util.Log.Printf("Unable to start totp enrolment : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.TotpEnrollment{Secret: secret, URI: util.TotpURI(totpIssuer, user.Phone, secret)})
+}
+
+/*
+ * Finish enrolment with a code from the authenticator app, returns the recovery codes.
+ */
+func ActivateTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	req, ok := decodeTotpCode(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findClaimedProprietor(ctx, w, r)
+	if !ok {
+		return
+	}
+	if user.Totp.Enabled || user.Totp.PendingSecret == "" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "No two factor enrolment in progress."})
+		return
+	}
+	step, match := util.ValidateTotp(user.Totp.PendingSecret, req.Code, time.Now())
+	if !match {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Code did not match."})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = store.Proprietors.UpdateTotp(ctx, user.Id, mod.Totp{
+			Secret:        user.Totp.PendingSecret,
+			Enabled:       true,
+			LastStep:      step,
+			RecoveryCodes: hashes,
+		})
+	}
+	if err != nil {
+		util.Log.Printf("Unable to enable totp : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.RecoveryCodes{Codes: codes})
+}
+
+/*
+ * Replace the recovery codes, the previous ones stop working.
+ */
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	req, ok := decodeTotpCode(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findClaimedProprietor(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !user.Totp.Enabled {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Two factor authentication not enabled."})
+		return
+	}
+	if err := checkTotp(ctx, user, req.Code); err != nil {
+		util.Log.Printf("Totp not accepted for %v : %v", user.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Code did not match."})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		//re-read, checkTotp moved the last step on.
+		user, err = store.Proprietors.FindByPhone(ctx, user.Phone)
+	}
+	if err == nil {
+		user.Totp.RecoveryCodes = hashes
+		err = store.Proprietors.UpdateTotp(ctx, user.Id, user.Totp)
+	}
+	if err != nil {
+		util.Log.Printf("Unable to replace recovery codes : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.RecoveryCodes{Codes: codes})
+}
+
+/*
+ * Turn off 2FA with a current code, not allowed while the tenent requires it.
+ */
+func DisableTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	req, ok := decodeTotpCode(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findClaimedProprietor(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !user.Totp.Enabled {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Two factor authentication not enabled."})
+		return
+	}
+	if user.Require2FA {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Two factor authentication is required by the tenent."})
+		return
+	}
+	if err := checkTotp(ctx, user, req.Code); err != nil {
+		util.Log.Printf("Totp not accepted for %v : %v", user.Phone, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Code did not match."})
+		return
+	}
+
+	if err := store.Proprietors.UpdateTotp(ctx, user.Id, mod.Totp{}); err != nil {
+		util.Log.Printf("Unable to disable totp : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Two factor authentication disabled."})
+}
+
+/*
+ * Make 2FA mandatory ( or optional ) for every proprietor user of the tenent, the
+ * proprietor turning it on must have it enabled so they are not locked out.
+ */
+func SetTotpRequired(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.TotpRequired
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, ok := findClaimedProprietor(ctx, w, r)
+	if !ok {
+		return
+	}
+	if req.Required && !user.Totp.Enabled {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Enable two factor authentication before requiring it."})
+		return
+	}
+
+	if err := store.Proprietors.SetRequire2FA(ctx, user.Tenent, req.Required); err != nil {
+		util.Log.Printf("Unable to update tenent 2fa setting : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Two factor authentication setting updated."})
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := util.TotpCode(secret, util.TotpStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	return code
+}
+
+/*
+ * Enrol and activate TOTP for the logged in proprietor, returns the secret and recovery codes.
+ * Activation uses the current step, so later logins in the test use the next one.
+ */
+func (s *testServer) enableTotp(token string) (string, []string) {
+	s.t.Helper()
+	var enrol mod.TotpEnrollment
+	rec := s.do("POST", "/v1/auth/2fa/enroll", nil, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &enrol)
+
+	var codes mod.RecoveryCodes
+	rec = s.do("POST", "/v1/auth/2fa/activate", mod.TotpCode{Code: totpCode(s.t, enrol.Secret, 0)}, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &codes)
+	if len(codes.Codes) != recoveryCodeCount {
+		s.t.Fatalf("expected %v recovery codes, got %v", recoveryCodeCount, len(codes.Codes))
+	}
+	return enrol.Secret, codes.Codes
+}
+
+func (s *testServer) mfaChallenge(phone string) mod.MfaChallenge {
+	s.t.Helper()
+	var challenge mod.MfaChallenge
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: phone, Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "token" && c.Value != "" {
+			s.t.Fatalf("token issued before the second factor")
+		}
+	}
+	decode(s.t, rec, &challenge)
+	if challenge.MfaToken == "" {
+		s.t.Fatalf("no mfa challenge : %+v", challenge)
+	}
+	return challenge
+}
+
+func TestTotpLogin(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	secret, recovery := s.enableTotp(owner)
+	s.expect(s.do("POST", "/v1/auth/2fa/enroll", nil, owner), http.StatusConflict)
+
+	challenge := s.mfaChallenge("1111111111")
+	login := mod.MfaLogin{Phone: "1111111111", MfaToken: challenge.MfaToken, Code: "000000"}
+	if login.Code == totpCode(t, secret, 1) {
+		login.Code = "000001"
+	}
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusUnauthorized)
+
+	wrongToken := mod.MfaLogin{Phone: "1111111111", MfaToken: "wrong", Code: totpCode(t, secret, 1)}
+	s.expect(s.do("POST", "/v1/auth/login-2fa", wrongToken, ""), http.StatusUnauthorized)
+
+	login.Code = totpCode(t, secret, 1)
+	rec := s.do("POST", "/v1/auth/login-2fa", login, "")
+	s.expect(rec, http.StatusOK)
+	s.expect(s.do("GET", "/v1/guards", nil, tokenCookie(t, rec)), http.StatusOK)
+
+	//the mfa token and the code are single use
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusUnauthorized)
+	login.MfaToken = s.mfaChallenge("1111111111").MfaToken
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusUnauthorized)
+
+	//recovery codes work once
+	login = mod.MfaLogin{Phone: "1111111111", MfaToken: s.mfaChallenge("1111111111").MfaToken, RecoveryCode: recovery[0]}
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusOK)
+	login.MfaToken = s.mfaChallenge("1111111111").MfaToken
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusUnauthorized)
+}
+
+func TestTotpParallelGuesses(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	s.enableTotp(owner)
+	challenge := s.mfaChallenge("1111111111")
+
+	//guesses racing each other are all counted against the challenge
+	var wg sync.WaitGroup
+	for i := 0; i < 4*otpMaxAttempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			login := mod.MfaLogin{Phone: "1111111111", MfaToken: challenge.MfaToken, Code: fmt.Sprintf("%06d", i)}
+			s.serve(s.request("POST", "/v1/auth/login-2fa", login, ""))
+		}(i)
+	}
+	wg.Wait()
+	otp, err := s.store.Otps.Find(context.Background(), "", "1111111111", mod.PROPRIETOR, mod.OTP_MFA)
+	if err != nil || otp.Attempts > otpMaxAttempts {
+		t.Fatalf("unexpected attempts %v %v", otp.Attempts, err)
+	}
+}
+
+func TestTotpOtpLoginChallenged(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+	s.enableTotp(owner)
+
+	s.expect(s.do("POST", "/v1/auth/request-otp", mod.OtpRequest{Phone: "1111111111", UserType: mod.PROPRIETOR}, ""), http.StatusOK)
+	var challenge mod.MfaChallenge
+	rec := s.do("POST", "/v1/auth/login-otp", mod.OtpLogin{Phone: "1111111111", Otp: s.sms.code("1111111111"), UserType: mod.PROPRIETOR}, "")
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &challenge)
+	if challenge.MfaToken == "" {
+		t.Fatalf("otp login not challenged")
+	}
+}
+
+func TestTotpRequiredByTenent(t *testing.T) {
+	s := newTestServer(t)
+	owner, _ := s.proprietor("1111111111", "alpha")
+
+	required := mod.TotpRequired{Required: true}
+	s.expect(s.do("PUT", "/v1/auth/2fa/required", required, owner), http.StatusConflict)
+	secret, _ := s.enableTotp(owner)
+	s.expect(s.do("PUT", "/v1/auth/2fa/required", required, owner), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/2fa/disable", mod.TotpCode{Code: totpCode(t, secret, 1)}, owner), http.StatusConflict)
+
+	//a user of the tenent without 2FA has to enrol at login
+	user, err := s.store.Proprietors.FindByPhone(context.Background(), "1111111111")
+	if err != nil {
+		t.Fatalf("find proprietor: %v", err)
+	}
+	user.Phone = "2222222222"
+	user.Id = primitive.NilObjectID
+	user.Totp = mod.Totp{}
+	if err := s.store.Proprietors.Create(context.Background(), user); err != nil {
+		t.Fatalf("create proprietor: %v", err)
+	}
+	challenge := s.mfaChallenge("2222222222")
+	if !challenge.Enroll || challenge.Secret == "" {
+		t.Fatalf("enrolment not required : %+v", challenge)
+	}
+	login := mod.MfaLogin{Phone: "2222222222", MfaToken: challenge.MfaToken, Code: totpCode(t, challenge.Secret, 0)}
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusOK)
+
+	//enrolled, next login is verified against the same secret
+	login = mod.MfaLogin{Phone: "2222222222", MfaToken: s.mfaChallenge("2222222222").MfaToken, Code: totpCode(t, challenge.Secret, 1)}
+	s.expect(s.do("POST", "/v1/auth/login-2fa", login, ""), http.StatusOK)
+}
@@ -0,0 +1,446 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/validator.v2"
+)
+
+/*
+ * Active tour of the user at the company the patrol is for, found is false when there is
+ * none. On a store error a response is written and ok is false.
+ */
+func activeTour(ctx context.Context, w http.ResponseWriter, patrol mod.Patrol) (tour mod.Tour, found bool, ok bool) {
+	tour, err := store.Tours.FindActive(ctx, patrol.Tenent, patrol.Phone)
+	if err == db.ErrNotFound {
+		return tour, false, true
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find active tour: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return tour, false, false
+	}
+	return tour, tour.CompanyId == patrol.CompanyId, true
+}
+
+/*
+ * Add the saved patrol as the next leg of the tour, the patrol is kept when this fails.
+ */
+func addTourLeg(ctx context.Context, tour mod.Tour, patrol mod.Patrol, patrolId string) {
+	leg := mod.TourLeg{
+		Tag:        patrol.RFData,
+		Checkpoint: patrol.Checkpoint,
+		Position:   -1,
+		PatrolId:   patrolId,
+		Scanned:    patrol.Date,
+	}
+	for i, tag := range tour.Tags {
+		if tag == patrol.RFData {
+			leg.Position = i
+			break
+		}
+	}
+	previous := tour.Started
+	if n := len(tour.Legs); n > 0 {
+		previous = tour.Legs[n-1].Scanned
+	}
+	leg.Seconds = patrol.Date.Sub(previous).Seconds()
+	if err := store.Tours.AddLeg(ctx, tour.Tenent, tour.Id, leg); err != nil {
+		util.Log.Printf("Unable to add patrol %v to tour %v : %v", patrolId, tour.Id.Hex(), err)
+	}
+}
+
+/*
+ * Status of a tour when it is closed and the route tags not scanned. Tags missing make
+ * it partial, otherwise it is out-of-order when a tag was first scanned after a later
+ * one of the route. Repeated and off route scans are ignored.
+ */
+func tourStatus(tour mod.Tour) (string, []string) {
+	scanned := map[int]bool{}
+	last, inOrder := -1, true
+	for _, leg := range tour.Legs {
+		if leg.Position < 0 || scanned[leg.Position] {
+			continue
+		}
+		scanned[leg.Position] = true
+		if leg.Position < last {
+			inOrder = false
+		}
+		last = leg.Position
+	}
+	var missing []string
+	for i, tag := range tour.Tags {
+		if !scanned[i] {
+			missing = append(missing, tag)
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		return mod.TOUR_PARTIAL, missing
+	case !inOrder:
+		return mod.TOUR_OUT_OF_ORDER, nil
+	}
+	return mod.TOUR_COMPLETE, nil
+}
+
+/*
+ * Define a tour of a company as the ordered tags to scan.
+ */
+func AddTourRoute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var req mod.TourRoute
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(req); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	seen := map[string]bool{}
+	for _, tag := range req.Tags {
+		if tag == "" || len(tag) > 128 || seen[tag] {
+			util.Log.Printf("Invalid tour tag %q\n", tag)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "tags must be unique and 1 to 128 characters"})
+			return
+		}
+		seen[tag] = true
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := store.Companies.FindById(ctx, tenent, objID); err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + id})
+		return
+	}
+
+	req.Id = primitive.NilObjectID
+	req.Tenent = tenent
+	req.CompanyId = id
+	req.Created = time.Now().UTC()
+	route, err := store.TourRoutes.Create(ctx, req)
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(route)
+}
+
+func GetTourRoutesByCompanyId(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.TourRoutes.List(ctx, claims["tenent"].(string), id)
+	if err != nil {
+		util.Log.Printf("Unable to find tour routes: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.TourRoutes{Routes: c})
+}
+
+/*
+ * Remove a tour route, tours already walked keep their copy of the tags.
+ */
+func DeleteTourRouteById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = store.TourRoutes.DeleteById(ctx, claims["tenent"].(string), objID)
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tour route not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to delete tour route: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Tour route removed."})
+}
+
+/*
+ * Start a tour of the route, the patrols the user adds at the company until it is
+ * finished are its legs. A user walks one tour at a time.
+ */
+func StartTour(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	route, err := store.TourRoutes.FindById(ctx, tenent, objID)
+	if err != nil {
+		util.Log.Printf("Unable to find tour route: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tour route not found: " + id})
+		return
+	}
+	companyID, _ := primitive.ObjectIDFromHex(route.CompanyId)
+	company, err := store.Companies.FindById(ctx, tenent, companyID)
+	if err != nil {
+		util.Log.Printf("Unable to find company: %v", err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Company not found: " + route.CompanyId})
+		return
+	}
+
+	tour := mod.Tour{
+		Tenent:      tenent,
+		CompanyId:   route.CompanyId,
+		CompanyName: company.Name,
+		RouteId:     id,
+		Route:       route.Name,
+		Tags:        route.Tags,
+		Phone:       claims["phone"].(string),
+		Name:        "Proprietor",
+		Status:      mod.TOUR_ACTIVE,
+		Started:     time.Now(),
+		Legs:        []mod.TourLeg{},
+	}
+	if name, ok := claims["name"]; ok {
+		tour.Name = name.(string)
+	}
+	tour, err = store.Tours.Create(ctx, tour)
+	if err == db.ErrDuplicate {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Finish the active tour first."})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to insert document : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tour)
+}
+
+/*
+ * The tour the user is walking.
+ */
+func GetActiveTour(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tour, err := store.Tours.FindActive(ctx, claims["tenent"].(string), claims["phone"].(string))
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "No active tour."})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find active tour: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tour)
+}
+
+/*
+ * A tour by id, a guard can only read their own tours.
+ */
+func GetTourById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tour, err := store.Tours.FindById(ctx, claims["tenent"].(string), objID)
+	if err == nil && claims["usertype"] == mod.GUARD && tour.Phone != claims["phone"] {
+		util.Log.Printf("Tour %v not allowed for : %v", id, claims["phone"])
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tour not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to find tour: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tour)
+}
+
+/*
+ * Close an active tour as complete, partial or out-of-order. A guard can only finish
+ * their own tour.
+ */
+func FinishTour(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tour, err := store.Tours.FindById(ctx, tenent, objID)
+	if err == nil && claims["usertype"] == mod.GUARD && tour.Phone != claims["phone"] {
+		util.Log.Printf("Tour %v not allowed for : %v", id, claims["phone"])
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err == nil && tour.Status != mod.TOUR_ACTIVE {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tour already finished."})
+		return
+	}
+	if err == nil {
+		status, missing := tourStatus(tour)
+		tour, err = store.Tours.Finish(ctx, tenent, objID, status, missing, time.Now())
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Tour not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to finish tour: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tour)
+}
+
+/*
+ * Tours of a company, latest first. A guard only gets their own tours.
+ */
+func GetToursByCompanyId(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := store.Tours.List(ctx, claims["tenent"].(string), id)
+	if err != nil {
+		util.Log.Printf("Unable to find tours: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if claims["usertype"] == mod.GUARD {
+		own := []mod.Tour{}
+		for _, t := range c {
+			if t.Phone == claims["phone"] {
+				own = append(own, t)
+			}
+		}
+		c = own
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.Tours{Tours: c})
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func TestTours(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guard := s.guard(owner, tenent, "2222222222")
+	other := s.guard(owner, tenent, "3333333333")
+	auditor := s.staff(owner, "5555555555", mod.STAFF_AUDITOR)
+	acme := s.company(owner, "acme")
+	globex := s.company(owner, "globex")
+
+	perimeter := mod.TourRoute{Name: "perimeter", Tags: []string{"A", "B", "C"}}
+	s.expect(s.do("POST", "/v1/company/"+acme+"/tour-route", perimeter, guard), http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/company/000000000000000000000000/tour-route", perimeter, owner), http.StatusNotFound)
+	for _, c := range []mod.TourRoute{
+		{Name: "ab", Tags: []string{"A"}},
+		{Name: "perimeter"},
+		{Name: "perimeter", Tags: []string{"A", "B", "A"}},
+		{Name: "perimeter", Tags: []string{"A", ""}},
+	} {
+		s.expect(s.do("POST", "/v1/company/"+acme+"/tour-route", c, owner), http.StatusBadRequest)
+	}
+	var route mod.TourRoute
+	rec := s.do("POST", "/v1/company/"+acme+"/tour-route", perimeter, owner)
+	s.expect(rec, http.StatusCreated)
+	decode(t, rec, &route)
+
+	var routes mod.TourRoutes
+	rec = s.do("GET", "/v1/company/"+acme+"/tour-routes", nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &routes)
+	if len(routes.Routes) != 1 || !reflect.DeepEqual(routes.Routes[0].Tags, perimeter.Tags) {
+		t.Fatalf("unexpected routes %+v", routes.Routes)
+	}
+
+	start := func(token string) mod.Tour {
+		var tour mod.Tour
+		rec := s.do("POST", "/v1/tour-route/"+route.Id.Hex()+"/start", nil, token)
+		s.expect(rec, http.StatusCreated)
+		decode(t, rec, &tour)
+		return tour
+	}
+	scan := func(token, company, tag string) {
+		s.expect(s.do("POST", "/v1/patrol/company/"+company, mod.Patrol{GPS: "12.97,77.59", RFData: tag}, token), http.StatusCreated)
+	}
+	finish := func(token string, tour mod.Tour, status int) mod.Tour {
+		rec := s.do("PUT", "/v1/tour/"+tour.Id.Hex()+"/finish", nil, token)
+		s.expect(rec, status)
+		var finished mod.Tour
+		if status == http.StatusOK {
+			decode(t, rec, &finished)
+		}
+		return finished
+	}
+
+	s.expect(s.do("POST", "/v1/tour-route/"+route.Id.Hex()+"/start", nil, auditor), http.StatusUnauthorized)
+	s.expect(s.do("GET", "/v1/tour/active", nil, guard), http.StatusNotFound)
+	tour := start(guard)
+	if tour.Status != mod.TOUR_ACTIVE || tour.CompanyName != "acme" || tour.Route != "perimeter" || len(tour.Legs) != 0 {
+		t.Fatalf("unexpected tour %+v", tour)
+	}
+	s.expect(s.do("POST", "/v1/tour-route/"+route.Id.Hex()+"/start", nil, guard), http.StatusConflict)
+	var active mod.Tour
+	rec = s.do("GET", "/v1/tour/active", nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &active)
+	if active.Id != tour.Id {
+		t.Fatalf("unexpected active tour %+v", active)
+	}
+
+	scan(guard, acme, "A")
+	scan(guard, acme, "C")
+	scan(guard, globex, "B") //another company, not on the tour
+	scan(guard, acme, "B")
+	scan(guard, acme, "X")
+	scan(other, acme, "A") //not walking the tour
+
+	finish(other, tour, http.StatusUnauthorized)
+	done := finish(guard, tour, http.StatusOK)
+	if done.Status != mod.TOUR_OUT_OF_ORDER || done.Finished == nil || len(done.Missing) != 0 || len(done.Legs) != 4 {
+		t.Fatalf("unexpected tour %+v", done)
+	}
+	for i, position := range []int{0, 2, 1, -1} {
+		if leg := done.Legs[i]; leg.Position != position || leg.PatrolId == "" || leg.Seconds < 0 {
+			t.Fatalf("unexpected leg %v %+v", i, leg)
+		}
+	}
+	finish(guard, tour, http.StatusConflict)
+	s.expect(s.do("GET", "/v1/tour/active", nil, guard), http.StatusNotFound)
+
+	var patrols mod.Patrols
+	rec = s.do("GET", "/v1/patrol/company/"+acme, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	onTour := 0
+	for _, p := range patrols.Patrols {
+		if p.TourId == tour.Id.Hex() {
+			onTour++
+		}
+	}
+	if onTour != 4 {
+		t.Fatalf("expected 4 patrols on the tour got %v", onTour)
+	}
+
+	//the tour of a scan is never taken from the body
+	forged := mod.Patrol{GPS: "12.97,77.59", RFData: "A", TourId: tour.Id.Hex(), Phone: "2222222222", Tenent: "other"}
+	s.expect(s.do("POST", "/v1/patrol/company/"+acme, forged, other), http.StatusCreated)
+	rec = s.do("GET", "/v1/patrol/company/"+acme, nil, owner)
+	decode(t, rec, &patrols)
+	for _, p := range patrols.Patrols {
+		if p.Phone == "3333333333" && p.TourId != "" {
+			t.Fatalf("tour taken from the body %+v", p)
+		}
+	}
+	if len(patrols.Patrols) != 6 {
+		t.Fatalf("expected 6 patrols got %v", len(patrols.Patrols))
+	}
+
+	//a supervisor or above can close any tour
+	partial := start(guard)
+	scan(guard, acme, "A")
+	done = finish(owner, partial, http.StatusOK)
+	if done.Status != mod.TOUR_PARTIAL || !reflect.DeepEqual(done.Missing, []string{"B", "C"}) {
+		t.Fatalf("unexpected tour %+v", done)
+	}
+
+	complete := start(guard)
+	for _, tag := range []string{"A", "B", "A", "C"} {
+		scan(guard, acme, tag)
+	}
+	if done = finish(guard, complete, http.StatusOK); done.Status != mod.TOUR_COMPLETE {
+		t.Fatalf("unexpected tour %+v", done)
+	}
+
+	var tours mod.Tours
+	rec = s.do("GET", "/v1/company/"+acme+"/tours", nil, auditor)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tours)
+	if len(tours.Tours) != 3 || tours.Tours[0].Id != complete.Id {
+		t.Fatalf("unexpected tours %+v", tours.Tours)
+	}
+
+	//a guard only reads their own tours
+	rec = s.do("GET", "/v1/company/"+acme+"/tours", nil, guard)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tours)
+	if len(tours.Tours) != 3 {
+		t.Fatalf("unexpected tours %+v", tours.Tours)
+	}
+	rec = s.do("GET", "/v1/company/"+acme+"/tours", nil, other)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tours)
+	if len(tours.Tours) != 0 {
+		t.Fatalf("tours of another guard listed %+v", tours.Tours)
+	}
+	s.expect(s.do("GET", "/v1/tour/"+tour.Id.Hex(), nil, other), http.StatusUnauthorized)
+	s.expect(s.do("GET", "/v1/tour/"+tour.Id.Hex(), nil, auditor), http.StatusOK)
+
+	s.expect(s.do("DELETE", "/v1/tour-route/"+route.Id.Hex(), nil, auditor), http.StatusUnauthorized)
+	s.expect(s.do("DELETE", "/v1/tour-route/"+route.Id.Hex(), nil, owner), http.StatusOK)
+	s.expect(s.do("DELETE", "/v1/tour-route/"+route.Id.Hex(), nil, owner), http.StatusNotFound)
+	s.expect(s.do("POST", "/v1/tour-route/"+route.Id.Hex()+"/start", nil, guard), http.StatusNotFound)
+	s.expect(s.do("GET", "/v1/tour/"+tour.Id.Hex(), nil, guard), http.StatusOK)
+}
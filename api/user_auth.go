@@ -8,31 +8,90 @@ import (
 
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
+	"github.com/monitor_security/config"
 	db "github.com/monitor_security/db"
 	mod "github.com/monitor_security/model"
 	"github.com/monitor_security/util"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/validator.v2"
 )
 
+/*
+ * Exchange a refresh token ( body or refresh_token cookie ) for a new access and
+ * refresh token. A refresh token is single use, presenting a used one again means
+ * it was stolen and the whole family ( login session ) is revoked.
+ */
 func RefreshToken(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get("Authorization")
-	tokenStr, err := util.GenerateJWT(auth)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	var req mod.RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.Log.Printf("Invalid body :%v", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		if c, err := r.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = c.Value
+		}
+	}
+	if req.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Refresh token required."})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	invalid := mod.ErrorResponse{Error: "Invalid or expired refresh token, pls login."}
+	session, err := store.RefreshTokens.FindByHash(ctx, util.HashCode(req.RefreshToken))
 	if err != nil {
-		fmt.Printf("Err : %v\n", err.Error())
+		util.Log.Printf("Unable to find refresh token : %v", err.Error())
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	if session.Revoked {
+		util.Log.Printf("Revoked refresh token presented, family : %v", session.Family)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	sessionEnd := session.SessionStart.Add(config.Current.Auth.SessionLifetime.Duration)
+	if time.Now().After(session.Expires) || time.Now().After(sessionEnd) {
+		util.Log.Printf("Refresh token expired, family : %v", session.Family)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	//Rotate fails when the token was already used, including a concurrent refresh.
+	if session.Used || store.RefreshTokens.Rotate(ctx, session.Id) != nil {
+		util.Log.Printf("Refresh token reused, revoking family : %v", session.Family)
+		if err := store.RefreshTokens.RevokeFamily(ctx, session.Family); err != nil {
+			util.Log.Printf("Unable to revoke token family : %v", err.Error())
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "token",
-		Value: tokenStr,
-	})
-	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Token Refreshed."})
-	w.WriteHeader(http.StatusOK)
+	//token data is rebuilt from the account so suspended or deleted users can not refresh.
+	tData, err := sessionTokenData(ctx, session)
+	if err != nil {
+		util.Log.Printf("Unable to refresh token : %v", err.Error())
+		store.RefreshTokens.RevokeFamily(ctx, session.Family)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalid)
+		return
+	}
+	writeSessionTokens(ctx, w, tData, session, "Token Refreshed.")
 }
 
 //Register a new Proprietor
@@ -43,14 +102,14 @@ func RegisterProprietor(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
 		util.Log.Printf("Invalid body :%v", err.Error())
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		return
 	}
 	if err := validator.NewValidator().Validate(user); err != nil {
 		util.Log.Printf("Error input validation %v\n", err.Error())
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -66,19 +125,17 @@ func RegisterProprietor(w http.ResponseWriter, r *http.Request) {
 	}
 	user.Tenent = id.String()
 	user.Active = true
-
-	index := mongo.IndexModel{
-		Keys:    bson.D{{"phone", 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err = db.ProprietorDB.Indexes().CreateOne(ctx, index)
+	user.Role = mod.STAFF_OWNER
+	user.Require2FA = false
+	user.Password, err = util.HashPassword(user.Password)
 	if err != nil {
-		util.Log.Printf("Unable to create unique index : %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
+		util.Log.Printf("Unable to hash password : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		return
 	}
-	_, err = db.ProprietorDB.InsertOne(ctx, user)
+
+	err = store.Proprietors.Create(ctx, user)
 	if err != nil {
 		util.Log.Printf("Unable to insert document : %v", err.Error())
 		w.WriteHeader(http.StatusConflict)
@@ -115,50 +172,55 @@ func ProprietorPasswordLogin(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user mod.Proprietor
-	err = db.ProprietorDB.FindOne(ctx, bson.M{"phone": login.Phone}).Decode(&user)
+	loginProprietor(ctx, w, r, login.Phone, login.Password)
+}
+
+func loginProprietor(ctx context.Context, w http.ResponseWriter, r *http.Request, phone, password string) {
+	account := accountKey(mod.PROPRIETOR, "", phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	user, err := store.Proprietors.FindByPhone(ctx, phone)
 	if err != nil {
 		util.Log.Printf("Unable to find user : %v", err)
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "User NOT found, check phone, password, usertype"})
+		checkDummyPassword(password)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
 		return
 	}
 
 	//validate Password
-	if login.Password != user.Password {
-		util.Log.Printf("Password did not match : %v", err)
+	match, legacy := util.CheckPassword(user.Password, password)
+	if !match {
+		util.Log.Printf("Password did not match for : %v", phone)
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Password did not match."})
+		json.NewEncoder(w).Encode(invalidCredentials)
 		return
 	}
+	attempt.refund(ctx)
 
-	tData := &mod.OwnerTokenData{
-		UserType: user.UserType,
-		Tenent:   user.Tenent,
-		Phone:    user.Phone,
-		Group:    user.Group,
-	}
-	tokenStr, err := util.GenerateJWT(tData)
-	if err != nil {
-		fmt.Printf("Err : %v\n", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+	//only revealed to someone who knows the password.
+	if !user.Active {
+		util.Log.Printf("Tenent suspended : %v", user.Tenent)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Account suspended."})
 		return
 	}
+	if legacy {
+		upgradePassword(ctx, store.Proprietors.UpdatePassword, user.Id, password)
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:  "token",
-		Value: tokenStr,
-	})
-	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Login Successful, token cookie returned."})
-	w.WriteHeader(http.StatusOK)
+	//failures are only reset once the second factor, if any, is verified.
+	completeProprietorLogin(ctx, w, r, user)
 }
 
-//Register a new user
+//Register an invited guard
 func RegisterGuard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header()["Date"] = nil
-	var user mod.Guard
+	var user mod.GuardRegistration
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
 		util.Log.Printf("Invalid body :%v", err)
@@ -173,14 +235,23 @@ func RegisterGuard(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	//User  = Guard ( make sure the user is created by Proprietor)
+	//User  = Guard ( must be invited by the Proprietor )
 	util.Log.Println("Register : Guard")
-	filter := bson.M{"phone": user.Phone, "tenent": user.Tenent, "active": true, "registered": false}
-	update := bson.M{"$set": bson.M{"name": user.Name, "registered": true, "password": user.Password}}
-	result := db.GuardDB.FindOneAndUpdate(ctx, filter, update)
-
-	if result.Err() != nil {
-		util.Log.Printf("Unable to register Gurard User, Guard user must be added by Proprietor to complete registration: %v", result.Err().Error())
+	if err := acceptInvitation(ctx, user.Tenent, user.Phone, user.Code); err != nil {
+		util.Log.Printf("Invitation not accepted for %v : %v", user.Phone, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Invalid or expired invitation code."})
+		return
+	}
+	hash, err := util.HashPassword(user.Password)
+	if err != nil {
+		util.Log.Printf("Unable to hash password : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = store.Guards.Register(ctx, user.Tenent, user.Phone, user.Name, hash)
+	if err != nil {
+		util.Log.Printf("Unable to register Gurard User, Guard user must be added by Proprietor to complete registration: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -212,23 +283,52 @@ func GuardPasswordLogin(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user mod.Guard
+	loginGuard(ctx, w, r, login.Tenent, login.Phone, login.Password)
+}
+
+func loginGuard(ctx context.Context, w http.ResponseWriter, r *http.Request, tenent, phone, password string) {
+	account := accountKey(mod.GUARD, tenent, phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
 	//guard must be registered(true), and active( true )
-	err = db.GuardDB.FindOne(ctx, bson.M{"phone": login.Phone, "tenent": login.Tenent, "registered": true, "active": true}).Decode(&user)
+	user, err := store.Guards.FindByPhone(ctx, tenent, phone)
+	if err == nil && (!user.Registered || !user.Active) {
+		err = db.ErrNotFound
+	}
 	if err != nil {
 		util.Log.Printf("Unable to find user : %v", err)
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "User NOT found, check phone, password, usertype, tenet"})
+		checkDummyPassword(password)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
 		return
 	}
 
 	//validate Password
-	if login.Password != user.Password {
-		util.Log.Printf("Password did not match : %v", err)
+	match, legacy := util.CheckPassword(user.Password, password)
+	if !match {
+		util.Log.Printf("Password did not match for : %v", phone)
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Password did not match."})
+		json.NewEncoder(w).Encode(invalidCredentials)
+		return
+	}
+	attempt.refund(ctx)
+
+	//only revealed to someone who knows the password.
+	if !isTenentActive(ctx, user.Tenent) {
+		util.Log.Printf("Tenent suspended : %v", user.Tenent)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Account suspended."})
 		return
 	}
+	//also clears the count of the login without a tenent, which ends up here.
+	loginSucceeded(ctx, account)
+	loginSucceeded(ctx, accountKey(mod.GUARD, "", phone))
+	if legacy {
+		upgradePassword(ctx, store.Guards.UpdatePassword, user.Id, password)
+	}
 
 	tData := &mod.GuardTokenData{
 		UserType: user.UserType,
@@ -237,18 +337,341 @@ func GuardPasswordLogin(w http.ResponseWriter, r *http.Request) {
 		Name:     user.Name,
 		Group:    user.Group,
 	}
+	writeLoginToken(w, r, tData)
+}
+
+/*
+ * Single login for admin, proprietor, guard and client, dispatched by usertype. A guard may
+ * leave out the tenent, it is resolved from the tenents the phone is registered in.
+ */
+func Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+	var login mod.PasswordLogin
+
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil {
+		util.Log.Printf("Invalid body :%v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := validator.NewValidator().Validate(login); err != nil {
+		util.Log.Printf("Error input validation %v\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	switch {
+	case login.UserType == mod.ADMIN:
+		loginAdmin(ctx, w, r, login.Phone, login.Password)
+	case login.UserType == mod.PROPRIETOR:
+		loginProprietor(ctx, w, r, login.Phone, login.Password)
+	case login.UserType == mod.CLIENT:
+		loginClient(ctx, w, r, login.Phone, login.Password)
+	case login.Tenent != "":
+		loginGuard(ctx, w, r, login.Tenent, login.Phone, login.Password)
+	default:
+		loginGuardAnyTenent(ctx, w, r, login.Phone, login.Password)
+	}
+}
+
+/*
+ * Login a guard by phone alone. With a single tenent it is a normal guard login, otherwise
+ * the password is checked against every tenent and the ones it matches are returned to
+ * choose from, so the tenents of a phone are never revealed without its password.
+ */
+func loginGuardAnyTenent(ctx context.Context, w http.ResponseWriter, r *http.Request, phone, password string) {
+	guards, err := store.Guards.ListByPhone(ctx, phone)
+	if err != nil {
+		util.Log.Printf("Unable to find guard tenents : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var candidates []mod.Guard
+	for _, g := range guards {
+		if g.Registered && g.Active {
+			candidates = append(candidates, g)
+		}
+	}
+	//a single tenent counts the attempt there.
+	if len(candidates) == 1 {
+		loginGuard(ctx, w, r, candidates[0].Tenent, phone, password)
+		return
+	}
+
+	account := accountKey(mod.GUARD, "", phone)
+	attempt := countLoginAttempt(ctx, w, r, account)
+	if attempt == nil {
+		return
+	}
+
+	if len(candidates) == 0 {
+		checkDummyPassword(password)
+	}
+	var matched []mod.TenentGroup
+	for _, g := range candidates {
+		if match, _ := util.CheckPassword(g.Password, password); match {
+			matched = append(matched, mod.TenentGroup{Tenent: g.Tenent, Group: g.Group})
+		}
+	}
+	switch len(matched) {
+	case 0:
+		util.Log.Printf("Password did not match any tenent for : %v", phone)
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(invalidCredentials)
+	case 1:
+		//counted again by the tenent login, which clears this count when it succeeds.
+		attempt.refund(ctx)
+		loginGuard(ctx, w, r, matched[0].Tenent, phone, password)
+	default:
+		attempt.refund(ctx)
+		loginSucceeded(ctx, account)
+		w.WriteHeader(http.StatusMultipleChoices)
+		json.NewEncoder(w).Encode(mod.TenentChoices{Status: "Registered in more than one tenent, login with one of them.", Tenents: matched})
+	}
+}
+
+type passwordUpdater func(ctx context.Context, id primitive.ObjectID, old, password string) error
+
+/*
+ * Replace a legacy plaintext password with its hash, login is not failed if this does not succeed.
+ */
+func upgradePassword(ctx context.Context, update passwordUpdater, id primitive.ObjectID, password string) {
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		util.Log.Printf("Unable to hash legacy password : %v", err.Error())
+		return
+	}
+	if err := update(ctx, id, password, hash); err != nil {
+		util.Log.Printf("Unable to upgrade legacy password : %v", err.Error())
+		return
+	}
+	util.Log.Printf("Legacy password upgraded for id : %v", id.Hex())
+}
+
+/*
+ * Start a new login session ( refresh token family ) for the device making the request.
+ */
+func writeLoginToken(w http.ResponseWriter, r *http.Request, tData interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session := mod.RefreshToken{
+		Family:       uuid.New().String(),
+		Device:       deviceId(r),
+		SessionStart: time.Now(),
+	}
+	writeSessionTokens(ctx, w, tData, session, "Login Successful, token cookie returned.")
+}
+
+/*
+ * Issue an access token and the next refresh token of the session, both are
+ * returned as cookies and in the body for clients without a cookie jar.
+ */
+func writeSessionTokens(ctx context.Context, w http.ResponseWriter, tData interface{}, session mod.RefreshToken, status string) {
+	if err := bindSession(tData, &session); err != nil {
+		util.Log.Printf("Err : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
 	tokenStr, err := util.GenerateJWT(tData)
 	if err != nil {
-		fmt.Printf("Err : %v\n", err.Error())
+		util.Log.Printf("Err : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
+		return
+	}
+	refresh, err := util.GenerateToken(32)
+	if err != nil {
+		util.Log.Printf("Unable to generate refresh token : %v", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: err.Error()})
 		return
 	}
 
+	t := time.Now()
+	session.Id = primitive.NilObjectID
+	session.Hash = util.HashCode(refresh)
+	session.Used = false
+	session.Revoked = false
+	session.Created = t
+	session.Expires = t.Add(config.Current.Auth.RefreshTokenLifetime.Duration)
+	if sessionEnd := session.SessionStart.Add(config.Current.Auth.SessionLifetime.Duration); session.Expires.After(sessionEnd) {
+		session.Expires = sessionEnd
+	}
+	if err := store.RefreshTokens.Create(ctx, session); err != nil {
+		util.Log.Printf("Unable to save refresh token : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to create session."})
+		return
+	}
+
+	setAuthCookie(w, "token", tokenStr, "/", int(util.TokenLifetime().Seconds()))
+	setAuthCookie(w, "refresh_token", refresh, "/v1/auth", int(time.Until(session.Expires).Seconds()))
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.TokenResponse{
+		Status:       status,
+		AccessToken:  tokenStr,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(util.TokenLifetime().Seconds()),
+	})
+}
+
+/*
+ * Token cookies are never readable by scripts nor sent cross site, maxAge -1 deletes the cookie.
+ */
+func setAuthCookie(w http.ResponseWriter, name, value, path string, maxAge int) {
 	http.SetCookie(w, &http.Cookie{
-		Name:  "token",
-		Value: tokenStr,
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   config.Current.Auth.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
 	})
-	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Login Successful, token cookie returned."})
+}
+
+/*
+ * Bind the token data to the session family and record whose session it is.
+ */
+func bindSession(tData interface{}, session *mod.RefreshToken) error {
+	switch t := tData.(type) {
+	case *mod.OwnerTokenData:
+		t.Session = session.Family
+		session.UserType, session.Tenent, session.Phone = t.UserType, t.Tenent, t.Phone
+	case *mod.GuardTokenData:
+		t.Session = session.Family
+		session.UserType, session.Tenent, session.Phone = t.UserType, t.Tenent, t.Phone
+	case *mod.ClientTokenData:
+		t.Session = session.Family
+		session.UserType, session.Tenent, session.Phone = t.UserType, t.Tenent, t.Phone
+	case *mod.AdminTokenData:
+		t.Session = session.Family
+		session.UserType, session.Tenent, session.Phone = t.UserType, "", t.Phone
+	default:
+		return fmt.Errorf("Unknown token")
+	}
+	return nil
+}
+
+/*
+ * Token data for a refresh, the account must still exist and be active.
+ */
+func sessionTokenData(ctx context.Context, session mod.RefreshToken) (interface{}, error) {
+	switch session.UserType {
+	case mod.PROPRIETOR:
+		user, err := store.Proprietors.FindByPhone(ctx, session.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if !user.Active {
+			return nil, fmt.Errorf("Account suspended.")
+		}
+		return &mod.OwnerTokenData{UserType: user.UserType, Tenent: user.Tenent, Phone: user.Phone, Group: user.Group, Role: staffRole(user)}, nil
+	case mod.GUARD:
+		user, err := store.Guards.FindByPhone(ctx, session.Tenent, session.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if !user.Registered || !user.Active || !isTenentActive(ctx, user.Tenent) {
+			return nil, fmt.Errorf("Account suspended.")
+		}
+		return &mod.GuardTokenData{UserType: user.UserType, Tenent: user.Tenent, Phone: user.Phone, Name: user.Name, Group: user.Group}, nil
+	case mod.CLIENT:
+		user, err := store.Clients.FindByPhone(ctx, session.Phone)
+		if err != nil {
+			return nil, err
+		}
+		if !isClientActive(ctx, jwt.MapClaims{"tenent": user.Tenent, "phone": user.Phone}) {
+			return nil, fmt.Errorf("Account suspended.")
+		}
+		return clientTokenData(user), nil
+	case mod.ADMIN:
+		user, err := store.Admins.FindByPhone(ctx, session.Phone)
+		if err != nil {
+			return nil, err
+		}
+		return &mod.AdminTokenData{UserType: user.UserType, Phone: user.Phone, Name: user.Name}, nil
+	}
+	return nil, fmt.Errorf("Unknown user type : %v", session.UserType)
+}
+
+/*
+ * Device the session was started on, clients should send a stable X-Device-Id.
+ */
+func deviceId(r *http.Request) string {
+	device := r.Header.Get("X-Device-Id")
+	if device == "" {
+		device = r.UserAgent()
+	}
+	if len(device) > 128 {
+		device = device[:128]
+	}
+	return device
+}
+
+/*
+ * Logout revokes the token used for the request, logout-all revokes every token of the user.
+ */
+func Logout(w http.ResponseWriter, r *http.Request) {
+	revokeSession(w, r, false)
+}
+
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	revokeSession(w, r, true)
+}
+
+/*
+ * Revoke every access and refresh token of the user.
+ */
+func revokeAllSessions(ctx context.Context, usertype, tenent, phone string) error {
+	subject := util.TokenSubject(usertype, tenent, phone)
+	err := store.Revocations.RevokeSubject(ctx, subject, time.Now(), time.Now().Add(util.TokenLifetime()))
+	if err != nil {
+		return err
+	}
+	return store.RefreshTokens.RevokeSubject(ctx, usertype, tenent, phone)
+}
+
+func revokeSession(w http.ResponseWriter, r *http.Request, all bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if all || jti == "" {
+		//tokens issued before jti was introduced can only be revoked by subject.
+		usertype, _ := claims["usertype"].(string)
+		tenent, _ := claims["tenent"].(string)
+		phone, _ := claims["phone"].(string)
+		err = revokeAllSessions(ctx, usertype, tenent, phone)
+	} else {
+		err = store.Revocations.RevokeToken(ctx, jti, claimsExpiry(claims))
+		if sid, _ := claims["sid"].(string); err == nil && sid != "" {
+			err = store.RefreshTokens.RevokeFamily(ctx, sid)
+		}
+	}
+	if err != nil {
+		util.Log.Printf("Unable to revoke token : %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unable to logout."})
+		return
+	}
+
+	setAuthCookie(w, "token", "", "/", -1)
+	setAuthCookie(w, "refresh_token", "", "/v1/auth", -1)
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: "Logged out."})
 }
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/monitor_security/config"
+	db "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+)
+
+func TestRegisterProprietor(t *testing.T) {
+	s := newTestServer(t)
+	user := mod.Proprietor{Group: "alpha", Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}
+
+	s.expect(s.do("POST", "/v1/auth/register-proprietor", user, ""), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/auth/register-proprietor", user, ""), http.StatusConflict)
+
+	stored, err := s.store.Proprietors.FindByPhone(context.Background(), user.Phone)
+	if err != nil {
+		t.Fatalf("proprietor not stored: %v", err)
+	}
+	if stored.Password == testPassword || !util.IsPasswordHash(stored.Password) {
+		t.Fatalf("password stored in plaintext")
+	}
+
+	bad := user
+	bad.Phone = "12ab"
+	s.expect(s.do("POST", "/v1/auth/register-proprietor", bad, ""), http.StatusBadRequest)
+}
+
+func TestProprietorPasswordLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "1111111111", Password: "wrongpass1", UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusUnauthorized)
+
+	rec = s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "9999999999", Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	//unknown accounts get the same response as a wrong password
+	s.expect(rec, http.StatusUnauthorized)
+}
+
+func TestLegacyPasswordIsUpgraded(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	s.store.Proprietors.Create(ctx, mod.Proprietor{
+		Tenent: "legacy", Group: "legacy", Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR, Active: true,
+	})
+
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+
+	stored, _ := s.store.Proprietors.FindByPhone(ctx, "1111111111")
+	if !util.IsPasswordHash(stored.Password) {
+		t.Fatalf("legacy password was not re-hashed")
+	}
+	rec = s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+}
+
+func TestGuardRegistrationAndLogin(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+
+	//guard must be added by the proprietor first
+	rec := s.do("POST", "/v1/auth/register-guard", mod.GuardRegistration{
+		Tenent: tenent, Name: "bob", Phone: "2222222222", Password: testPassword, UserType: mod.GUARD, Code: "12345678",
+	}, "")
+	s.expect(rec, http.StatusBadRequest)
+
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusCreated)
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusConflict)
+
+	var tenents mod.TenentsToRegister
+	rec = s.do("GET", "/v1/auth/fetch-tenents/2222222222", nil, "")
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &tenents)
+	if len(tenents.Tenents) != 1 || tenents.Tenents[0].Tenent != tenent {
+		t.Fatalf("unexpected tenents %+v", tenents)
+	}
+
+	code := s.sms.code("2222222222")
+	rec = s.do("POST", "/v1/auth/register-guard", mod.GuardRegistration{
+		Tenent: tenent, Name: "bob", Phone: "2222222222", Password: testPassword, UserType: mod.GUARD, Code: code,
+	}, "")
+	s.expect(rec, http.StatusCreated)
+
+	//registration is single shot
+	rec = s.do("POST", "/v1/auth/register-guard", mod.GuardRegistration{
+		Tenent: tenent, Name: "eve", Phone: "2222222222", Password: "attacker12", UserType: mod.GUARD, Code: code,
+	}, "")
+	s.expect(rec, http.StatusBadRequest)
+
+	rec = s.do("POST", "/v1/auth/login-guard-password", mod.GuardPasswordLogin{
+		Tenent: tenent, Phone: "2222222222", Password: "attacker12", UserType: mod.GUARD,
+	}, "")
+	s.expect(rec, http.StatusUnauthorized)
+
+	rec = s.do("POST", "/v1/auth/login-guard-password", mod.GuardPasswordLogin{
+		Tenent: tenent, Phone: "2222222222", Password: testPassword, UserType: mod.GUARD,
+	}, "")
+	s.expect(rec, http.StatusOK)
+	tokenCookie(t, rec)
+}
+
+func TestUnifiedLogin(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	s.guard(owner, tenent, "2222222222")
+
+	rec := s.do("POST", "/v1/auth/login", mod.PasswordLogin{Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR}, "")
+	s.expect(rec, http.StatusOK)
+	var tokens mod.TokenResponse
+	decode(t, rec, &tokens)
+	for _, c := range rec.Result().Cookies() {
+		if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode {
+			t.Fatalf("cookie %v not hardened", c.Name)
+		}
+	}
+	if tokens.AccessToken == "" || tokens.AccessToken != tokenCookie(t, rec) {
+		t.Fatalf("token not returned in body and cookie")
+	}
+
+	//guard in a single tenent, the tenent is resolved
+	guard := mod.PasswordLogin{Phone: "2222222222", Password: testPassword, UserType: mod.GUARD}
+	rec = s.do("POST", "/v1/auth/login", guard, "")
+	s.expect(rec, http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, tokenCookie(t, rec)), http.StatusOK)
+
+	//in a second tenent the guard has to choose
+	other, otherTenent := s.proprietor("3333333333", "beta")
+	s.guard(other, otherTenent, "2222222222")
+	rec = s.do("POST", "/v1/auth/login", guard, "")
+	s.expect(rec, http.StatusMultipleChoices)
+	var choices mod.TenentChoices
+	decode(t, rec, &choices)
+	if len(choices.Tenents) != 2 {
+		t.Fatalf("expected 2 tenents, got %+v", choices)
+	}
+	guard.Tenent = otherTenent
+	s.expect(s.do("POST", "/v1/auth/login", guard, ""), http.StatusOK)
+
+	//choices are not revealed without the password
+	guard.Tenent = ""
+	guard.Password = "wrongpass1"
+	s.expect(s.do("POST", "/v1/auth/login", guard, ""), http.StatusUnauthorized)
+
+	if err := db.SeedAdmin(s.store, mod.Admin{Name: "root", Phone: "9000000000", Password: testPassword}); err != nil {
+		t.Fatalf("seed admin: %v", err)
+	}
+	rec = s.do("POST", "/v1/auth/login", mod.PasswordLogin{Phone: "9000000000", Password: testPassword, UserType: mod.ADMIN}, "")
+	s.expect(rec, http.StatusOK)
+	s.expect(s.do("GET", "/v1/admin/tenents", nil, tokenCookie(t, rec)), http.StatusOK)
+}
+
+func (s *testServer) loginOwner(phone string) mod.TokenResponse {
+	s.t.Helper()
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: phone, Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+	var tokens mod.TokenResponse
+	decode(s.t, rec, &tokens)
+	return tokens
+}
+
+func (s *testServer) refresh(token string, status int) mod.TokenResponse {
+	s.t.Helper()
+	rec := s.do("POST", "/v1/auth/token-refresh", mod.RefreshRequest{RefreshToken: token}, "")
+	s.expect(rec, status)
+	var tokens mod.TokenResponse
+	if status == http.StatusOK {
+		decode(s.t, rec, &tokens)
+	}
+	return tokens
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	login := s.loginOwner("1111111111")
+	if login.AccessToken == "" || login.RefreshToken == "" || login.ExpiresIn <= 0 {
+		t.Fatalf("login did not return a token pair: %+v", login)
+	}
+
+	refreshed := s.refresh(login.RefreshToken, http.StatusOK)
+	if refreshed.RefreshToken == login.RefreshToken {
+		t.Fatalf("refresh token was not rotated")
+	}
+	s.expect(s.do("GET", "/v1/companies", nil, refreshed.AccessToken), http.StatusOK)
+
+	//access tokens can not be used to refresh.
+	s.refresh(login.AccessToken, http.StatusUnauthorized)
+	s.refresh("garbage", http.StatusUnauthorized)
+	s.expect(s.do("POST", "/v1/auth/token-refresh", nil, ""), http.StatusBadRequest)
+}
+
+func TestRefreshTokenCookie(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	rec := s.do("POST", "/v1/auth/login-proprietor-password", mod.ProprietorPasswordLogin{
+		Phone: "1111111111", Password: testPassword, UserType: mod.PROPRIETOR,
+	}, "")
+	s.expect(rec, http.StatusOK)
+
+	req := httptest.NewRequest("POST", "/v1/auth/token-refresh", nil)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "refresh_token" {
+			if !c.HttpOnly || c.Path != "/v1/auth" {
+				t.Fatalf("refresh cookie not restricted: %+v", c)
+			}
+			req.AddCookie(c)
+		}
+	}
+	out := httptest.NewRecorder()
+	s.router.ServeHTTP(out, req)
+	s.expect(out, http.StatusOK)
+	tokenCookie(t, out)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	login := s.loginOwner("1111111111")
+	other := s.loginOwner("1111111111")
+
+	next := s.refresh(login.RefreshToken, http.StatusOK)
+	//replaying the used token kills the family, including the token issued after it.
+	s.refresh(login.RefreshToken, http.StatusUnauthorized)
+	s.refresh(next.RefreshToken, http.StatusUnauthorized)
+
+	//sessions on other devices are separate families.
+	s.refresh(other.RefreshToken, http.StatusOK)
+}
+
+func TestRefreshTokenSessionLifetime(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	login := s.loginOwner("1111111111")
+
+	config.Current.Auth.SessionLifetime = config.Duration{Duration: time.Nanosecond}
+	s.refresh(login.RefreshToken, http.StatusUnauthorized)
+}
+
+func TestRefreshTokenSuspendedTenent(t *testing.T) {
+	s := newTestServer(t)
+	_, tenent := s.proprietor("1111111111", "alpha")
+	login := s.loginOwner("1111111111")
+
+	if err := s.store.Proprietors.SetActive(context.Background(), tenent, false); err != nil {
+		t.Fatal(err)
+	}
+	s.refresh(login.RefreshToken, http.StatusUnauthorized)
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t)
+	s.proprietor("1111111111", "alpha")
+	first := s.loginOwner("1111111111")
+	second := s.loginOwner("1111111111")
+	third := s.loginOwner("1111111111")
+
+	s.expect(s.do("POST", "/v1/auth/logout", nil, first.AccessToken), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, first.AccessToken), http.StatusUnauthorized)
+	s.refresh(first.RefreshToken, http.StatusUnauthorized)
+
+	//other sessions are not affected by logout, but are by logout-all
+	s.expect(s.do("GET", "/v1/companies", nil, second.AccessToken), http.StatusOK)
+	s.expect(s.do("POST", "/v1/auth/logout-all", nil, second.AccessToken), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, second.AccessToken), http.StatusUnauthorized)
+	s.refresh(third.RefreshToken, http.StatusUnauthorized)
+}
@@ -12,10 +12,7 @@ import (
 	db "github.com/monitor_security/db"
 	mod "github.com/monitor_security/model"
 	"github.com/monitor_security/util"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/validator.v2"
 )
 
@@ -62,25 +59,28 @@ func AddGuard(w http.ResponseWriter, r *http.Request) {
 
 	user.Tenent = claims["tenent"].(string)
 	user.Group = claims["group"].(string)
-	user.Password = "123456789"
-	index := mongo.IndexModel{
-		Keys:    bson.D{{"phone", 1}, {"tenent", 1}},
-		Options: options.Index().SetUnique(true),
+	err = store.Guards.Create(ctx, user)
+	if err == db.ErrDuplicate {
+		//a deleted guard is invited again under the same record, the history stays with it.
+		err = store.Guards.Restore(ctx, user.Tenent, user.Phone)
 	}
-	_, err = db.GuardDB.Indexes().CreateOne(ctx, index)
 	if err != nil {
-		util.Log.Printf("Unable to create unique index : %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		util.Log.Printf("Unable to insert document : %v", err)
+		w.WriteHeader(http.StatusConflict)
 		return
 	}
-	_, err = db.GuardDB.InsertOne(ctx, user)
+
+	//The guard registers with the invitation code, there is no default password.
+	inv, err := sendInvitation(ctx, user.Tenent, user.Group, user.Phone)
 	if err != nil {
-		util.Log.Printf("Unable to insert document : %v", err)
-		w.WriteHeader(http.StatusConflict)
+		util.Log.Printf("Unable to send invitation : %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Guard added, unable to send invitation, pls resend."})
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
 }
 
 /*
@@ -96,10 +96,7 @@ func GetValidTenentsToRegister(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"phone": phone, "active": true, "registered": false}
-	cursor, err := db.GuardDB.Find(ctx, filter)
-	defer cursor.Close(ctx)
-
+	guards, err := store.Guards.ListByPhone(ctx, phone)
 	if err != nil {
 		util.Log.Printf("Unable to find user : %v", err)
 		w.WriteHeader(http.StatusNotFound)
@@ -108,10 +105,10 @@ func GetValidTenentsToRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var c []mod.TenentGroup
-	for cursor.Next(ctx) {
-		tmp := mod.Guard{}
-		cursor.Decode(&tmp)
-		c = append(c, mod.TenentGroup{Group: tmp.Group, Tenent: tmp.Tenent})
+	for _, tmp := range guards {
+		if tmp.Active && !tmp.Registered {
+			c = append(c, mod.TenentGroup{Group: tmp.Group, Tenent: tmp.Tenent})
+		}
 	}
 
 	json.NewEncoder(w).Encode(mod.TenentsToRegister{Tenents: c})
@@ -122,30 +119,34 @@ func GetAllGuardsByOwner(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header()["Date"] = nil
 
+	//deleted guards are only listed when asked for.
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "all" && !validGuardStatus(status) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Unknown guard status: " + status})
+		return
+	}
+
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.M{"tenent": claims["tenent"].(string)}
-	cursor, err := db.GuardDB.Find(ctx, filter)
-	defer cursor.Close(ctx)
-
+	c, err := store.Guards.List(ctx, claims["tenent"].(string))
 	if err != nil {
 		util.Log.Printf("Unable to find guards: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-
-	c := []mod.Guard{}
-	for cursor.Next(ctx) {
-		tmp := mod.Guard{}
-		cursor.Decode(&tmp)
-		c = append(c, tmp)
+	guards := mod.Guards{Guards: []mod.Guard{}}
+	for _, g := range c {
+		g.Password = ""
+		g.Status = guardStatus(g)
+		if status == g.Status || status == "all" || (status == "" && !g.Deleted) {
+			guards.Guards = append(guards.Guards, g)
+		}
 	}
-	var guards mod.Guards
-	guards.Guards = c
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(guards)
@@ -170,15 +171,51 @@ func GetGuardById(w http.ResponseWriter, r *http.Request) {
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	var guard mod.Guard
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
-
-	err = db.GuardDB.FindOne(ctx, filter).Decode(&guard)
+	guard, err := store.Guards.FindById(ctx, claims["tenent"].(string), objID)
 	if err != nil {
 		util.Log.Printf("Unable to find guard: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	guard.Password = ""
+	guard.Status = guardStatus(guard)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(guard)
+}
+
+/*
+ * Partial update of a guard's profile, the phone is the login and can not be changed.
+ */
+func UpdateGuard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		util.Log.Printf("Wrong id: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	version, fields, err := decodePatch(r, mod.Guard{}, "name", "group", "image")
+	if err != nil {
+		writeBadPatch(w, err)
+		return
+	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	guard, err := store.Guards.Update(ctx, claims["tenent"].(string), objID, version, fields)
+	if err != nil {
+		writeUpdateError(w, err, "Guard", id)
+		return
+	}
+	guard.Password = ""
+	guard.Status = guardStatus(guard)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(guard)
 }
@@ -202,16 +239,110 @@ func DeleteGuardById(w http.ResponseWriter, r *http.Request) {
 	dat := r.Context().Value("user-claim")
 	claims := dat.(jwt.MapClaims)
 
-	filter := bson.M{"_id": objID, "tenent": claims["tenent"].(string)}
+	//the guard is kept as a tombstone, its patrols and incidents still name it.
+	guard, err := store.Guards.FindById(ctx, claims["tenent"].(string), objID)
+	if err == nil {
+		err = store.Guards.MarkDeleted(ctx, guard.Tenent, objID, time.Now())
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(mod.DeleteResult{DeletedCount: 0})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to delete guard: %v", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	//Revoke every token issued to the deleted guard.
+	err = revokeAllSessions(ctx, mod.GUARD, guard.Tenent, guard.Phone)
+	if err != nil {
+		util.Log.Printf("Unable to revoke guard tokens: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(mod.DeleteResult{DeletedCount: 1})
+}
+
+func SuspendGuard(w http.ResponseWriter, r *http.Request) {
+	setGuardActive(w, r, false)
+}
+
+func ReactivateGuard(w http.ResponseWriter, r *http.Request) {
+	setGuardActive(w, r, true)
+}
 
-	result, err := db.GuardDB.DeleteOne(ctx, filter)
+/*
+ * Suspend or reactivate a guard of the tenent, a suspended guard is logged out
+ * everywhere and keeps the record. Deleted guards can not be reactivated.
+ */
+func setGuardActive(w http.ResponseWriter, r *http.Request, active bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header()["Date"] = nil
+
+	id := mux.Vars(r)["Id"]
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		util.Log.Printf("Unable to find guard: %v", err.Error())
+		util.Log.Printf("Wrong id: %v", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+
+	claims := r.Context().Value("user-claim").(jwt.MapClaims)
+	tenent := claims["tenent"].(string)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	guard, err := store.Guards.FindById(ctx, tenent, objID)
+	if err == nil {
+		err = store.Guards.SetActive(ctx, tenent, objID, active)
+	}
+	if err == db.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(mod.ErrorResponse{Error: "Guard not found: " + id})
+		return
+	}
+	if err != nil {
+		util.Log.Printf("Unable to update guard: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	status := "Guard reactivated."
+	if !active {
+		status = "Guard suspended."
+		if err := revokeAllSessions(ctx, mod.GUARD, tenent, guard.Phone); err != nil {
+			util.Log.Printf("Unable to revoke guard tokens: %v", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	util.Log.Printf("%v %v", status, guard.Phone)
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(mod.SuccessResponse{Status: status})
+}
+
+func guardStatus(g mod.Guard) string {
+	switch {
+	case g.Deleted:
+		return mod.GUARD_DELETED
+	case !g.Active:
+		return mod.GUARD_SUSPENDED
+	case !g.Registered:
+		return mod.GUARD_INVITED
+	}
+	return mod.GUARD_ACTIVE
+}
+
+func validGuardStatus(status string) bool {
+	switch status {
+	case mod.GUARD_INVITED, mod.GUARD_ACTIVE, mod.GUARD_SUSPENDED, mod.GUARD_DELETED:
+		return true
+	}
+	return false
 }
 
 //------------------------------------------------------------------
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mod "github.com/monitor_security/model"
+)
+
+func TestGuardManagement(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guardToken := s.guard(owner, tenent, "2222222222")
+
+	var guards mod.Guards
+	rec := s.do("GET", "/v1/guards", nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &guards)
+	if len(guards.Guards) != 1 || guards.Guards[0].Password != "" {
+		t.Fatalf("unexpected guards %+v", guards)
+	}
+	id := guards.Guards[0].Id.Hex()
+
+	var guard mod.Guard
+	rec = s.do("GET", "/v1/guard/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &guard)
+	if guard.Phone != "2222222222" || guard.Password != "" {
+		t.Fatalf("unexpected guard %+v", guard)
+	}
+	s.expect(s.do("GET", "/v1/guard/not-an-id", nil, owner), http.StatusBadRequest)
+
+	var result mod.DeleteResult
+	rec = s.do("DELETE", "/v1/guard/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &result)
+	if result.DeletedCount != 1 {
+		t.Fatalf("guard not deleted %+v", result)
+	}
+	rec = s.do("GET", "/v1/guard/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &guard)
+	if guard.Status != mod.GUARD_DELETED || guard.Phone != "2222222222" || guard.DeletedAt == nil {
+		t.Fatalf("deleted guard not kept %+v", guard)
+	}
+	rec = s.do("DELETE", "/v1/guard/"+id, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &result)
+	if result.DeletedCount != 0 {
+		t.Fatalf("guard deleted twice %+v", result)
+	}
+
+	//tokens of the deleted guard are revoked, even on routes without a role check
+	s.expect(s.do("POST", "/v1/auth/logout", nil, guardToken), http.StatusUnauthorized)
+}
+
+func TestGuardCrossTenentIsolation(t *testing.T) {
+	s := newTestServer(t)
+	ownerA, tenentA := s.proprietor("1111111111", "alpha")
+	ownerB, _ := s.proprietor("3333333333", "beta")
+	guardA := s.guard(ownerA, tenentA, "2222222222")
+
+	var guards mod.Guards
+	rec := s.do("GET", "/v1/guards", nil, ownerA)
+	decode(t, rec, &guards)
+	id := guards.Guards[0].Id.Hex()
+
+	rec = s.do("GET", "/v1/guards", nil, ownerB)
+	s.expect(rec, http.StatusOK)
+	guards = mod.Guards{}
+	decode(t, rec, &guards)
+	if len(guards.Guards) != 0 {
+		t.Fatalf("guards of another tenent listed %+v", guards)
+	}
+	s.expect(s.do("GET", "/v1/guard/"+id, nil, ownerB), http.StatusBadRequest)
+
+	var result mod.DeleteResult
+	rec = s.do("DELETE", "/v1/guard/"+id, nil, ownerB)
+	decode(t, rec, &result)
+	if result.DeletedCount != 0 {
+		t.Fatalf("guard deleted by another tenent")
+	}
+	s.expect(s.do("GET", "/v1/companies", nil, guardA), http.StatusOK)
+}
+
+func (s *testServer) guardsByStatus(token, status string) []mod.Guard {
+	s.t.Helper()
+	var guards mod.Guards
+	rec := s.do("GET", "/v1/guards?status="+status, nil, token)
+	s.expect(rec, http.StatusOK)
+	decode(s.t, rec, &guards)
+	return guards.Guards
+}
+
+func TestGuardSuspendAndDelete(t *testing.T) {
+	s := newTestServer(t)
+	owner, tenent := s.proprietor("1111111111", "alpha")
+	guardToken := s.guard(owner, tenent, "2222222222")
+	s.guard(owner, tenent, "3333333333")
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "4444444444"}, owner), http.StatusCreated)
+	company := s.company(owner, "acme")
+	s.expect(s.do("POST", "/v1/patrol/company/"+company, mod.Patrol{GPS: "12.9,77.5", RFData: "tag-1"}, guardToken), http.StatusCreated)
+
+	g, err := s.store.Guards.FindByPhone(context.Background(), tenent, "2222222222")
+	if err != nil {
+		t.Fatalf("find guard: %v", err)
+	}
+	id := g.Id.Hex()
+
+	s.expect(s.do("PUT", "/v1/guard/"+id+"/suspend", nil, guardToken), http.StatusUnauthorized)
+	s.expect(s.do("PUT", "/v1/guard/"+id+"/suspend", nil, owner), http.StatusOK)
+	s.expect(s.do("GET", "/v1/companies", nil, guardToken), http.StatusUnauthorized)
+	login := mod.PasswordLogin{Phone: "2222222222", Password: testPassword, UserType: mod.GUARD, Tenent: tenent}
+	s.expect(s.do("POST", "/v1/auth/login", login, ""), http.StatusUnauthorized)
+	if c := s.guardsByStatus(owner, mod.GUARD_SUSPENDED); len(c) != 1 || c[0].Phone != "2222222222" {
+		t.Fatalf("unexpected suspended guards %+v", c)
+	}
+
+	s.expect(s.do("PUT", "/v1/guard/"+id+"/reactivate", nil, owner), http.StatusOK)
+	rec := s.do("POST", "/v1/auth/login", login, "")
+	s.expect(rec, http.StatusOK)
+	guardToken = tokenCookie(t, rec)
+	s.expect(s.do("GET", "/v1/companies", nil, guardToken), http.StatusOK)
+
+	s.expect(s.do("DELETE", "/v1/guard/"+id, nil, owner), http.StatusOK)
+	s.expect(s.do("PUT", "/v1/guard/"+id+"/reactivate", nil, owner), http.StatusNotFound)
+	s.expect(s.do("PUT", "/v1/guard/"+id+"/suspend", nil, owner), http.StatusNotFound)
+	s.expect(s.do("PATCH", "/v1/guard/"+id, patch{"version": 0, "name": "night shift"}, owner), http.StatusNotFound)
+	s.expect(s.do("POST", "/v1/auth/login", login, ""), http.StatusUnauthorized)
+
+	if c := s.guardsByStatus(owner, ""); len(c) != 2 {
+		t.Fatalf("deleted guard listed %+v", c)
+	}
+	if c := s.guardsByStatus(owner, mod.GUARD_DELETED); len(c) != 1 || c[0].Phone != "2222222222" {
+		t.Fatalf("unexpected deleted guards %+v", c)
+	}
+	if c := s.guardsByStatus(owner, mod.GUARD_INVITED); len(c) != 1 || c[0].Phone != "4444444444" {
+		t.Fatalf("unexpected invited guards %+v", c)
+	}
+	if c := s.guardsByStatus(owner, "all"); len(c) != 3 {
+		t.Fatalf("unexpected guards %+v", c)
+	}
+	s.expect(s.do("GET", "/v1/guards?status=retired", nil, owner), http.StatusBadRequest)
+
+	//the patrol history still names the deleted guard
+	var patrols mod.Patrols
+	rec = s.do("GET", "/v1/patrol/company/"+company, nil, owner)
+	s.expect(rec, http.StatusOK)
+	decode(t, rec, &patrols)
+	if len(patrols.Patrols) != 1 || patrols.Patrols[0].Phone != "2222222222" {
+		t.Fatalf("unexpected patrols %+v", patrols)
+	}
+
+	//inviting the phone again reuses the record
+	s.expect(s.do("POST", "/v1/guard", mod.RegisterGuard{Phone: "2222222222"}, owner), http.StatusCreated)
+	if c := s.guardsByStatus(owner, mod.GUARD_INVITED); len(c) != 2 || c[0].Id.Hex() != id && c[1].Id.Hex() != id {
+		t.Fatalf("deleted guard not invited again %+v", c)
+	}
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Duration accepts "90m" or "60h" style strings in the config file.
+ */
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+type DBConfig struct {
+	URI        string `json:"uri"`
+	Name       string `json:"name"`
+	AuthSource string `json:"auth_source"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+type AuthConfig struct {
+	//HS256 signs with jwt_key, RS256 and ES256 use generated keys rotated every key_rotation.
+	SigningAlg  string   `json:"signing_alg"`
+	KeyRotation Duration `json:"key_rotation"`
+	JWTKey      string   `json:"jwt_key"`
+	//access token lifetime, kept short since access tokens are only revoked by jti.
+	TokenLifetime        Duration `json:"token_lifetime"`
+	RefreshTokenLifetime Duration `json:"refresh_token_lifetime"`
+	//absolute lifetime of a login, refresh tokens are never issued past it.
+	SessionLifetime Duration `json:"session_lifetime"`
+	//token cookies are only sent over https, turn off for local http development.
+	CookieSecure bool `json:"cookie_secure"`
+}
+
+type AdminConfig struct {
+	Name     string `json:"name"`
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+}
+
+type Config struct {
+	ListenAddr  string      `json:"listen_addr"`
+	MediaDir    string      `json:"media_dir"`
+	CorsOrigins []string    `json:"cors_origins"`
+	LogLevel    string      `json:"log_level"`
+	TrustProxy  bool        `json:"trust_proxy"` //use X-Forwarded-For, only when behind a reverse proxy
+	DB          DBConfig    `json:"db"`
+	Auth        AuthConfig  `json:"auth"`
+	Admin       AdminConfig `json:"admin"`
+	//json role to permissions table replacing the built in policy, see policy.Load.
+	PolicyFile string `json:"policy_file"`
+}
+
+//Development only key, main warns when HS256 signs with it.
+const DefaultJWTKey = "e0b1a2bc-1dfd-11ec-87f3-38baf832d723"
+
+//Loaded configuration, set by Load.
+var Current = Default()
+
+func Default() *Config {
+	return &Config{
+		ListenAddr:  ":8080",
+		MediaDir:    "./media",
+		CorsOrigins: []string{"*"},
+		LogLevel:    "info",
+		DB: DBConfig{
+			URI:  "mongodb://localhost:27017/?ssl=false",
+			Name: "testdb",
+		},
+		Auth: AuthConfig{
+			SigningAlg:           "RS256",
+			KeyRotation:          Duration{30 * 24 * time.Hour},
+			JWTKey:               DefaultJWTKey,
+			TokenLifetime:        Duration{15 * time.Minute},
+			RefreshTokenLifetime: Duration{7 * 24 * time.Hour},
+			SessionLifetime:      Duration{30 * 24 * time.Hour},
+			CookieSecure:         true,
+		},
+	}
+}
+
+/*
+ * Load defaults, then the json file at path ( if any ), then MONITOR_* environment overrides.
+ */
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read config file: %v", err)
+		}
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file %v: %v", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	Current = cfg
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	strs := map[string]*string{
+		"MONITOR_LISTEN_ADDR":    &c.ListenAddr,
+		"MONITOR_MEDIA_DIR":      &c.MediaDir,
+		"MONITOR_LOG_LEVEL":      &c.LogLevel,
+		"MONITOR_DB_URI":         &c.DB.URI,
+		"MONITOR_DB_NAME":        &c.DB.Name,
+		"MONITOR_DB_AUTH_SOURCE": &c.DB.AuthSource,
+		"MONITOR_DB_USERNAME":    &c.DB.Username,
+		"MONITOR_DB_PASSWORD":    &c.DB.Password,
+		"MONITOR_JWT_KEY":        &c.Auth.JWTKey,
+		"MONITOR_SIGNING_ALG":    &c.Auth.SigningAlg,
+		"MONITOR_ADMIN_NAME":     &c.Admin.Name,
+		"MONITOR_ADMIN_PHONE":    &c.Admin.Phone,
+		"MONITOR_ADMIN_PASSWORD": &c.Admin.Password,
+		"MONITOR_POLICY_FILE":    &c.PolicyFile,
+	}
+	for env, ptr := range strs {
+		if v, ok := os.LookupEnv(env); ok {
+			*ptr = v
+		}
+	}
+
+	durations := map[string]*Duration{
+		"MONITOR_TOKEN_LIFETIME":         &c.Auth.TokenLifetime,
+		"MONITOR_REFRESH_TOKEN_LIFETIME": &c.Auth.RefreshTokenLifetime,
+		"MONITOR_SESSION_LIFETIME":       &c.Auth.SessionLifetime,
+		"MONITOR_KEY_ROTATION":           &c.Auth.KeyRotation,
+	}
+	for env, ptr := range durations {
+		if v, ok := os.LookupEnv(env); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("Invalid %v: %v", env, err)
+			}
+			ptr.Duration = d
+		}
+	}
+
+	bools := map[string]*bool{
+		"MONITOR_TRUST_PROXY":   &c.TrustProxy,
+		"MONITOR_COOKIE_SECURE": &c.Auth.CookieSecure,
+	}
+	for env, ptr := range bools {
+		if v, ok := os.LookupEnv(env); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("Invalid %v: %v", env, err)
+			}
+			*ptr = b
+		}
+	}
+
+	if v, ok := os.LookupEnv("MONITOR_CORS_ORIGINS"); ok {
+		c.CorsOrigins = strings.Split(v, ",")
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.ListenAddr == "" {
+		return fmt.Errorf("listen_addr is required")
+	}
+	if !strings.HasPrefix(c.DB.URI, "mongodb://") && !strings.HasPrefix(c.DB.URI, "mongodb+srv://") {
+		return fmt.Errorf("db.uri must be a mongodb:// or mongodb+srv:// uri")
+	}
+	if c.DB.Name == "" {
+		return fmt.Errorf("db.name is required")
+	}
+	if (c.DB.Username == "") != (c.DB.Password == "") {
+		return fmt.Errorf("db.username and db.password must be set together")
+	}
+	switch c.Auth.SigningAlg {
+	case "HS256":
+		if len(c.Auth.JWTKey) < 32 {
+			return fmt.Errorf("auth.jwt_key must be at least 32 characters")
+		}
+	case "RS256", "ES256":
+		if c.Auth.KeyRotation.Duration < time.Hour {
+			return fmt.Errorf("auth.key_rotation must be at least 1h")
+		}
+	default:
+		return fmt.Errorf("auth.signing_alg must be HS256, RS256 or ES256")
+	}
+	if c.Auth.TokenLifetime.Duration <= 0 {
+		return fmt.Errorf("auth.token_lifetime must be positive")
+	}
+	if c.Auth.RefreshTokenLifetime.Duration <= 0 {
+		return fmt.Errorf("auth.refresh_token_lifetime must be positive")
+	}
+	if c.Auth.SessionLifetime.Duration <= 0 {
+		return fmt.Errorf("auth.session_lifetime must be positive")
+	}
+	if c.MediaDir == "" {
+		return fmt.Errorf("media_dir is required")
+	}
+	if len(c.CorsOrigins) == 0 {
+		return fmt.Errorf("cors_origins must have at least one origin")
+	}
+	if c.LogLevel != "debug" && c.LogLevel != "info" {
+		return fmt.Errorf("log_level must be debug or info")
+	}
+	if c.Admin.Phone != "" && c.Admin.Password == "" {
+		return fmt.Errorf("admin.password is required to seed admin")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AdminStore interface {
+	Create(ctx context.Context, admin mod.Admin) error
+	FindByPhone(ctx context.Context, phone string) (mod.Admin, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoAdminStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoAdminStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoAdminStore) Create(ctx context.Context, admin mod.Admin) error {
+	_, err := s.coll.InsertOne(ctx, admin)
+	return mongoErr(err)
+}
+
+func (s *mongoAdminStore) FindByPhone(ctx context.Context, phone string) (mod.Admin, error) {
+	var admin mod.Admin
+	err := s.coll.FindOne(ctx, bson.M{"phone": phone}).Decode(&admin)
+	return admin, mongoErr(err)
+}
+
+func (s *mongoAdminStore) Count(ctx context.Context) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{})
+}
+
+//------------------------------- memory --------------------------------
+type memAdminStore struct {
+	mu     sync.Mutex
+	admins map[string]mod.Admin
+}
+
+func newMemAdminStore() *memAdminStore {
+	return &memAdminStore{admins: map[string]mod.Admin{}}
+}
+
+func (s *memAdminStore) Create(ctx context.Context, admin mod.Admin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.admins {
+		if a.Phone == admin.Phone {
+			return ErrDuplicate
+		}
+	}
+	if admin.Id == "" {
+		admin.Id = primitive.NewObjectID().Hex()
+	}
+	s.admins[admin.Id] = admin
+	return nil
+}
+
+func (s *memAdminStore) FindByPhone(ctx context.Context, phone string) (mod.Admin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.admins {
+		if a.Phone == phone {
+			return a, nil
+		}
+	}
+	return mod.Admin{}, ErrNotFound
+}
+
+func (s *memAdminStore) Count(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.admins)), nil
+}
@@ -0,0 +1,189 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * Checkpoints of the companies of a tenent, a tag is a checkpoint of one company only.
+ */
+type CheckpointStore interface {
+	Create(ctx context.Context, c mod.Checkpoint) (mod.Checkpoint, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Checkpoint, error)
+	FindByTag(ctx context.Context, tenent, companyId, tag string) (mod.Checkpoint, error)
+	// List returns the checkpoints of the company in the expected order.
+	List(ctx context.Context, tenent, companyId string) ([]mod.Checkpoint, error)
+	// Update sets the fields if the checkpoint is still at version, ErrVersionConflict if not.
+	Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Checkpoint, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+	Count(ctx context.Context, tenent, companyId string) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoCheckpointStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoCheckpointStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenent", Value: 1}, {Key: "tag", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoCheckpointStore) Create(ctx context.Context, c mod.Checkpoint) (mod.Checkpoint, error) {
+	if c.Id.IsZero() {
+		c.Id = primitive.NewObjectID()
+	}
+	_, err := s.coll.InsertOne(ctx, c)
+	return c, mongoErr(err)
+}
+
+func (s *mongoCheckpointStore) findOne(ctx context.Context, filter bson.M) (mod.Checkpoint, error) {
+	var c mod.Checkpoint
+	err := s.coll.FindOne(ctx, filter).Decode(&c)
+	return c, mongoErr(err)
+}
+
+func (s *mongoCheckpointStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Checkpoint, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "tenent": tenent})
+}
+
+func (s *mongoCheckpointStore) FindByTag(ctx context.Context, tenent, companyId, tag string) (mod.Checkpoint, error) {
+	return s.findOne(ctx, bson.M{"tenent": tenent, "companyid": companyId, "tag": tag})
+}
+
+func (s *mongoCheckpointStore) List(ctx context.Context, tenent, companyId string) ([]mod.Checkpoint, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent, "companyid": companyId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Checkpoint{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoCheckpointStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Checkpoint, error) {
+	var c mod.Checkpoint
+	err := updateVersioned(ctx, s.coll, bson.M{"_id": id, "tenent": tenent}, version, fields, &c)
+	return c, err
+}
+
+func (s *mongoCheckpointStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	return mongoErr(s.coll.FindOneAndDelete(ctx, bson.M{"_id": id, "tenent": tenent}).Err())
+}
+
+func (s *mongoCheckpointStore) Count(ctx context.Context, tenent, companyId string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"tenent": tenent, "companyid": companyId})
+}
+
+//------------------------------- memory --------------------------------
+type memCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[primitive.ObjectID]mod.Checkpoint
+}
+
+func newMemCheckpointStore() *memCheckpointStore {
+	return &memCheckpointStore{checkpoints: map[primitive.ObjectID]mod.Checkpoint{}}
+}
+
+func (s *memCheckpointStore) Create(ctx context.Context, c mod.Checkpoint) (mod.Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.checkpoints {
+		if v.Tenent == c.Tenent && v.Tag == c.Tag {
+			return mod.Checkpoint{}, ErrDuplicate
+		}
+	}
+	if c.Id.IsZero() {
+		c.Id = primitive.NewObjectID()
+	}
+	s.checkpoints[c.Id] = c
+	return c, nil
+}
+
+func (s *memCheckpointStore) filter(match func(mod.Checkpoint) bool) []mod.Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Checkpoint{}
+	for _, v := range s.checkpoints {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool {
+		if c[i].Order != c[j].Order {
+			return c[i].Order < c[j].Order
+		}
+		return c[i].Id.Hex() < c[j].Id.Hex()
+	})
+	return c
+}
+
+func (s *memCheckpointStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Checkpoint, error) {
+	c := s.filter(func(v mod.Checkpoint) bool { return v.Tenent == tenent && v.Id == id })
+	if len(c) == 0 {
+		return mod.Checkpoint{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memCheckpointStore) FindByTag(ctx context.Context, tenent, companyId, tag string) (mod.Checkpoint, error) {
+	c := s.filter(func(v mod.Checkpoint) bool { return v.Tenent == tenent && v.CompanyId == companyId && v.Tag == tag })
+	if len(c) == 0 {
+		return mod.Checkpoint{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memCheckpointStore) List(ctx context.Context, tenent, companyId string) ([]mod.Checkpoint, error) {
+	return s.filter(func(v mod.Checkpoint) bool { return v.Tenent == tenent && v.CompanyId == companyId }), nil
+}
+
+func (s *memCheckpointStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.checkpoints[id]
+	if !ok || v.Tenent != tenent {
+		return mod.Checkpoint{}, ErrNotFound
+	}
+	if v.Version != version {
+		return mod.Checkpoint{}, ErrVersionConflict
+	}
+	if err := setFields(&v, fields); err != nil {
+		return mod.Checkpoint{}, err
+	}
+	v.Version++
+	s.checkpoints[id] = v
+	return v, nil
+}
+
+func (s *memCheckpointStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.checkpoints[id]; ok && v.Tenent == tenent {
+		delete(s.checkpoints, id)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *memCheckpointStore) Count(ctx context.Context, tenent, companyId string) (int64, error) {
+	c, _ := s.List(ctx, tenent, companyId)
+	return int64(len(c)), nil
+}
@@ -0,0 +1,168 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * Client logins are found by phone alone, a phone is the client of one company only.
+ */
+type ClientStore interface {
+	Create(ctx context.Context, c mod.Client) error
+	FindByPhone(ctx context.Context, phone string) (mod.Client, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Client, error)
+	List(ctx context.Context, tenent string) ([]mod.Client, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+	// UpdatePassword replaces the password only if it still equals old.
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoClientStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoClientStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoClientStore) Create(ctx context.Context, c mod.Client) error {
+	_, err := s.coll.InsertOne(ctx, c)
+	return mongoErr(err)
+}
+
+func (s *mongoClientStore) findOne(ctx context.Context, filter bson.M) (mod.Client, error) {
+	var c mod.Client
+	err := s.coll.FindOne(ctx, filter).Decode(&c)
+	return c, mongoErr(err)
+}
+
+func (s *mongoClientStore) FindByPhone(ctx context.Context, phone string) (mod.Client, error) {
+	return s.findOne(ctx, bson.M{"phone": phone})
+}
+
+func (s *mongoClientStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Client, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "tenent": tenent})
+}
+
+func (s *mongoClientStore) List(ctx context.Context, tenent string) ([]mod.Client, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent})
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Client{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoClientStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	return mongoErr(s.coll.FindOneAndDelete(ctx, bson.M{"_id": id, "tenent": tenent}).Err())
+}
+
+func (s *mongoClientStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": id, "password": old}, bson.M{"$set": bson.M{"password": password}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//------------------------------- memory --------------------------------
+type memClientStore struct {
+	mu      sync.Mutex
+	clients map[primitive.ObjectID]mod.Client
+}
+
+func newMemClientStore() *memClientStore {
+	return &memClientStore{clients: map[primitive.ObjectID]mod.Client{}}
+}
+
+func (s *memClientStore) Create(ctx context.Context, c mod.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.clients {
+		if v.Phone == c.Phone {
+			return ErrDuplicate
+		}
+	}
+	if c.Id.IsZero() {
+		c.Id = primitive.NewObjectID()
+	}
+	s.clients[c.Id] = c
+	return nil
+}
+
+func (s *memClientStore) filter(match func(mod.Client) bool) []mod.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Client{}
+	for _, v := range s.clients {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c
+}
+
+func (s *memClientStore) FindByPhone(ctx context.Context, phone string) (mod.Client, error) {
+	c := s.filter(func(v mod.Client) bool { return v.Phone == phone })
+	if len(c) == 0 {
+		return mod.Client{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memClientStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Client, error) {
+	c := s.filter(func(v mod.Client) bool { return v.Tenent == tenent && v.Id == id })
+	if len(c) == 0 {
+		return mod.Client{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memClientStore) List(ctx context.Context, tenent string) ([]mod.Client, error) {
+	return s.filter(func(v mod.Client) bool { return v.Tenent == tenent }), nil
+}
+
+func (s *memClientStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.clients[id]; ok && v.Tenent == tenent {
+		delete(s.clients, id)
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *memClientStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.clients[id]
+	if !ok || v.Password != old {
+		return ErrNotFound
+	}
+	v.Password = password
+	s.clients[id] = v
+	return nil
+}
@@ -0,0 +1,216 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CompanyStore interface {
+	Create(ctx context.Context, c mod.Company) error
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Company, error)
+	List(ctx context.Context, tenent string) ([]mod.Company, error)
+	// Update sets the fields if the company is still at version, ErrVersionConflict if not.
+	Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Company, error)
+	// SetGeofence replaces the geofence, nil removes it. The version is incremented.
+	SetGeofence(ctx context.Context, tenent string, id primitive.ObjectID, g *mod.Geofence) (mod.Company, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error)
+	DeleteAll(ctx context.Context, tenent string) (int64, error)
+	Count(ctx context.Context, tenent string) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoCompanyStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoCompanyStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}, {Key: "tenent", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoCompanyStore) Create(ctx context.Context, c mod.Company) error {
+	_, err := s.coll.InsertOne(ctx, c)
+	return mongoErr(err)
+}
+
+func (s *mongoCompanyStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Company, error) {
+	var c mod.Company
+	err := s.coll.FindOne(ctx, bson.M{"_id": id, "tenent": tenent}).Decode(&c)
+	return c, mongoErr(err)
+}
+
+func (s *mongoCompanyStore) List(ctx context.Context, tenent string) ([]mod.Company, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent})
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Company{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoCompanyStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Company, error) {
+	var c mod.Company
+	err := updateVersioned(ctx, s.coll, bson.M{"_id": id, "tenent": tenent}, version, fields, &c)
+	return c, err
+}
+
+func (s *mongoCompanyStore) SetGeofence(ctx context.Context, tenent string, id primitive.ObjectID, g *mod.Geofence) (mod.Company, error) {
+	update := bson.M{"$set": bson.M{"geofence": g}, "$inc": bson.M{"version": 1}}
+	if g == nil {
+		update = bson.M{"$unset": bson.M{"geofence": ""}, "$inc": bson.M{"version": 1}}
+	}
+	var c mod.Company
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "tenent": tenent}, update, opts).Decode(&c)
+	return c, mongoErr(err)
+}
+
+func (s *mongoCompanyStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error) {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id, "tenent": tenent})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (s *mongoCompanyStore) DeleteAll(ctx context.Context, tenent string) (int64, error) {
+	result, err := s.coll.DeleteMany(ctx, bson.M{"tenent": tenent})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (s *mongoCompanyStore) Count(ctx context.Context, tenent string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"tenent": tenent})
+}
+
+//------------------------------- memory --------------------------------
+type memCompanyStore struct {
+	mu        sync.Mutex
+	companies map[primitive.ObjectID]mod.Company
+}
+
+func newMemCompanyStore() *memCompanyStore {
+	return &memCompanyStore{companies: map[primitive.ObjectID]mod.Company{}}
+}
+
+func (s *memCompanyStore) Create(ctx context.Context, c mod.Company) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.companies {
+		if v.Name == c.Name && v.Tenent == c.Tenent {
+			return ErrDuplicate
+		}
+	}
+	if c.Id.IsZero() {
+		c.Id = primitive.NewObjectID()
+	}
+	s.companies[c.Id] = c
+	return nil
+}
+
+func (s *memCompanyStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Company, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.companies[id]; ok && v.Tenent == tenent {
+		return v, nil
+	}
+	return mod.Company{}, ErrNotFound
+}
+
+func (s *memCompanyStore) List(ctx context.Context, tenent string) ([]mod.Company, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Company{}
+	for _, v := range s.companies {
+		if v.Tenent == tenent {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c, nil
+}
+
+func (s *memCompanyStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Company, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.companies[id]
+	if !ok || v.Tenent != tenent {
+		return mod.Company{}, ErrNotFound
+	}
+	if v.Version != version {
+		return mod.Company{}, ErrVersionConflict
+	}
+	if err := setFields(&v, fields); err != nil {
+		return mod.Company{}, err
+	}
+	for _, c := range s.companies {
+		if c.Id != id && c.Name == v.Name && c.Tenent == v.Tenent {
+			return mod.Company{}, ErrDuplicate
+		}
+	}
+	v.Version++
+	s.companies[id] = v
+	return v, nil
+}
+
+func (s *memCompanyStore) SetGeofence(ctx context.Context, tenent string, id primitive.ObjectID, g *mod.Geofence) (mod.Company, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.companies[id]
+	if !ok || v.Tenent != tenent {
+		return mod.Company{}, ErrNotFound
+	}
+	v.Geofence = g
+	v.Version++
+	s.companies[id] = v
+	return v, nil
+}
+
+func (s *memCompanyStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.companies[id]; ok && v.Tenent == tenent {
+		delete(s.companies, id)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (s *memCompanyStore) DeleteAll(ctx context.Context, tenent string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, v := range s.companies {
+		if v.Tenent == tenent {
+			delete(s.companies, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memCompanyStore) Count(ctx context.Context, tenent string) (int64, error) {
+	c, _ := s.List(ctx, tenent)
+	return int64(len(c)), nil
+}
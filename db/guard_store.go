@@ -0,0 +1,323 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type GuardStore interface {
+	Create(ctx context.Context, g mod.Guard) error
+	FindByPhone(ctx context.Context, tenent, phone string) (mod.Guard, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Guard, error)
+	List(ctx context.Context, tenent string) ([]mod.Guard, error)
+	// ListByPhone returns the guard record of phone in every tenent.
+	ListByPhone(ctx context.Context, phone string) ([]mod.Guard, error)
+	// Register completes registration of an active, not yet registered guard.
+	Register(ctx context.Context, tenent, phone, name, password string) error
+	// Update sets the fields if the guard is still at version, ErrVersionConflict if not.
+	Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Guard, error)
+	// SetActive suspends or reactivates a guard which is not deleted.
+	SetActive(ctx context.Context, tenent string, id primitive.ObjectID, active bool) error
+	// MarkDeleted keeps the guard as an inactive tombstone without a password.
+	MarkDeleted(ctx context.Context, tenent string, id primitive.ObjectID, at time.Time) error
+	// Restore turns the tombstone of phone into a guard yet to register.
+	Restore(ctx context.Context, tenent, phone string) error
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error)
+	// UpdatePassword replaces the password only if it still equals old.
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error
+	// Count excludes deleted guards.
+	Count(ctx context.Context, tenent string) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoGuardStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoGuardStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}, {Key: "tenent", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoGuardStore) Create(ctx context.Context, g mod.Guard) error {
+	_, err := s.coll.InsertOne(ctx, g)
+	return mongoErr(err)
+}
+
+func (s *mongoGuardStore) findOne(ctx context.Context, filter bson.M) (mod.Guard, error) {
+	var g mod.Guard
+	err := s.coll.FindOne(ctx, filter).Decode(&g)
+	return g, mongoErr(err)
+}
+
+func (s *mongoGuardStore) find(ctx context.Context, filter bson.M) ([]mod.Guard, error) {
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Guard{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoGuardStore) FindByPhone(ctx context.Context, tenent, phone string) (mod.Guard, error) {
+	return s.findOne(ctx, bson.M{"phone": phone, "tenent": tenent})
+}
+
+func (s *mongoGuardStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Guard, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "tenent": tenent})
+}
+
+func (s *mongoGuardStore) List(ctx context.Context, tenent string) ([]mod.Guard, error) {
+	return s.find(ctx, bson.M{"tenent": tenent})
+}
+
+func (s *mongoGuardStore) ListByPhone(ctx context.Context, phone string) ([]mod.Guard, error) {
+	return s.find(ctx, bson.M{"phone": phone})
+}
+
+func (s *mongoGuardStore) Register(ctx context.Context, tenent, phone, name, password string) error {
+	filter := bson.M{"phone": phone, "tenent": tenent, "active": true, "registered": false}
+	update := bson.M{"$set": bson.M{"name": name, "registered": true, "password": password}}
+	return mongoErr(s.coll.FindOneAndUpdate(ctx, filter, update).Err())
+}
+
+func (s *mongoGuardStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Guard, error) {
+	var g mod.Guard
+	filter := bson.M{"_id": id, "tenent": tenent, "deleted": bson.M{"$ne": true}}
+	err := updateVersioned(ctx, s.coll, filter, version, fields, &g)
+	return g, err
+}
+
+func (s *mongoGuardStore) updateOne(ctx context.Context, filter, update bson.M) error {
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoGuardStore) SetActive(ctx context.Context, tenent string, id primitive.ObjectID, active bool) error {
+	filter := bson.M{"_id": id, "tenent": tenent, "deleted": bson.M{"$ne": true}}
+	return s.updateOne(ctx, filter, bson.M{"$set": bson.M{"active": active}})
+}
+
+func (s *mongoGuardStore) MarkDeleted(ctx context.Context, tenent string, id primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": id, "tenent": tenent, "deleted": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"deleted": true, "deletedat": at, "active": false, "password": ""}}
+	return s.updateOne(ctx, filter, update)
+}
+
+func (s *mongoGuardStore) Restore(ctx context.Context, tenent, phone string) error {
+	filter := bson.M{"phone": phone, "tenent": tenent, "deleted": true}
+	update := bson.M{
+		"$set":   bson.M{"deleted": false, "active": true, "registered": false, "password": ""},
+		"$unset": bson.M{"deletedat": ""},
+	}
+	return s.updateOne(ctx, filter, update)
+}
+
+func (s *mongoGuardStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error) {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id, "tenent": tenent})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (s *mongoGuardStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": id, "password": old}, bson.M{"$set": bson.M{"password": password}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoGuardStore) Count(ctx context.Context, tenent string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"tenent": tenent, "deleted": bson.M{"$ne": true}})
+}
+
+//------------------------------- memory --------------------------------
+type memGuardStore struct {
+	mu     sync.Mutex
+	guards map[primitive.ObjectID]mod.Guard
+}
+
+func newMemGuardStore() *memGuardStore {
+	return &memGuardStore{guards: map[primitive.ObjectID]mod.Guard{}}
+}
+
+func (s *memGuardStore) Create(ctx context.Context, g mod.Guard) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.guards {
+		if v.Phone == g.Phone && v.Tenent == g.Tenent {
+			return ErrDuplicate
+		}
+	}
+	if g.Id.IsZero() {
+		g.Id = primitive.NewObjectID()
+	}
+	s.guards[g.Id] = g
+	return nil
+}
+
+func (s *memGuardStore) filter(match func(mod.Guard) bool) []mod.Guard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Guard{}
+	for _, v := range s.guards {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c
+}
+
+func (s *memGuardStore) FindByPhone(ctx context.Context, tenent, phone string) (mod.Guard, error) {
+	c := s.filter(func(g mod.Guard) bool { return g.Tenent == tenent && g.Phone == phone })
+	if len(c) == 0 {
+		return mod.Guard{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memGuardStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Guard, error) {
+	c := s.filter(func(g mod.Guard) bool { return g.Tenent == tenent && g.Id == id })
+	if len(c) == 0 {
+		return mod.Guard{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memGuardStore) List(ctx context.Context, tenent string) ([]mod.Guard, error) {
+	return s.filter(func(g mod.Guard) bool { return g.Tenent == tenent }), nil
+}
+
+func (s *memGuardStore) ListByPhone(ctx context.Context, phone string) ([]mod.Guard, error) {
+	return s.filter(func(g mod.Guard) bool { return g.Phone == phone }), nil
+}
+
+func (s *memGuardStore) Register(ctx context.Context, tenent, phone, name, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, v := range s.guards {
+		if v.Tenent == tenent && v.Phone == phone && v.Active && !v.Registered {
+			v.Name = name
+			v.Registered = true
+			v.Password = password
+			s.guards[id] = v
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memGuardStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Guard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.guards[id]
+	if !ok || v.Tenent != tenent || v.Deleted {
+		return mod.Guard{}, ErrNotFound
+	}
+	if v.Version != version {
+		return mod.Guard{}, ErrVersionConflict
+	}
+	if err := setFields(&v, fields); err != nil {
+		return mod.Guard{}, err
+	}
+	v.Version++
+	s.guards[id] = v
+	return v, nil
+}
+
+func (s *memGuardStore) update(match func(mod.Guard) bool, fn func(*mod.Guard)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, v := range s.guards {
+		if match(v) {
+			fn(&v)
+			s.guards[id] = v
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memGuardStore) SetActive(ctx context.Context, tenent string, id primitive.ObjectID, active bool) error {
+	match := func(g mod.Guard) bool { return g.Id == id && g.Tenent == tenent && !g.Deleted }
+	return s.update(match, func(g *mod.Guard) { g.Active = active })
+}
+
+func (s *memGuardStore) MarkDeleted(ctx context.Context, tenent string, id primitive.ObjectID, at time.Time) error {
+	match := func(g mod.Guard) bool { return g.Id == id && g.Tenent == tenent && !g.Deleted }
+	return s.update(match, func(g *mod.Guard) {
+		g.Deleted = true
+		g.DeletedAt = &at
+		g.Active = false
+		g.Password = ""
+	})
+}
+
+func (s *memGuardStore) Restore(ctx context.Context, tenent, phone string) error {
+	match := func(g mod.Guard) bool { return g.Phone == phone && g.Tenent == tenent && g.Deleted }
+	return s.update(match, func(g *mod.Guard) {
+		g.Deleted = false
+		g.DeletedAt = nil
+		g.Active = true
+		g.Registered = false
+		g.Password = ""
+	})
+}
+
+func (s *memGuardStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.guards[id]; ok && v.Tenent == tenent {
+		delete(s.guards, id)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (s *memGuardStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.guards[id]
+	if !ok || v.Password != old {
+		return ErrNotFound
+	}
+	v.Password = password
+	s.guards[id] = v
+	return nil
+}
+
+func (s *memGuardStore) Count(ctx context.Context, tenent string) (int64, error) {
+	return int64(len(s.filter(func(g mod.Guard) bool { return g.Tenent == tenent && !g.Deleted }))), nil
+}
@@ -0,0 +1,177 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type IncidentStore interface {
+	Create(ctx context.Context, i mod.Incident) (string, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Incident, error)
+	// AddMedia adds media paths to the incident, paths already present are skipped.
+	AddMedia(ctx context.Context, tenent string, id primitive.ObjectID, media []string) error
+	List(ctx context.Context, tenent string) ([]mod.Incident, error)
+	ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Incident, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+	Count(ctx context.Context, tenent string) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoIncidentStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoIncidentStore) Create(ctx context.Context, i mod.Incident) (string, error) {
+	result, err := s.coll.InsertOne(ctx, i)
+	if err != nil {
+		return "", mongoErr(err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return id.Hex(), nil
+	}
+	return i.Id, nil
+}
+
+func (s *mongoIncidentStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Incident, error) {
+	var i mod.Incident
+	err := s.coll.FindOne(ctx, bson.M{"_id": id, "tenent": tenent}).Decode(&i)
+	return i, mongoErr(err)
+}
+
+func (s *mongoIncidentStore) AddMedia(ctx context.Context, tenent string, id primitive.ObjectID, media []string) error {
+	filter := bson.M{"_id": id, "tenent": tenent}
+	update := bson.M{"$addToSet": bson.M{"media": bson.M{"$each": media}}}
+	return mongoErr(s.coll.FindOneAndUpdate(ctx, filter, update).Err())
+}
+
+func (s *mongoIncidentStore) find(ctx context.Context, filter bson.M) ([]mod.Incident, error) {
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Incident{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoIncidentStore) List(ctx context.Context, tenent string) ([]mod.Incident, error) {
+	return s.find(ctx, bson.M{"tenent": tenent})
+}
+
+func (s *mongoIncidentStore) ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Incident, error) {
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId})
+}
+
+func (s *mongoIncidentStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	return mongoErr(s.coll.FindOneAndDelete(ctx, bson.M{"_id": id, "tenent": tenent}).Err())
+}
+
+func (s *mongoIncidentStore) Count(ctx context.Context, tenent string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"tenent": tenent})
+}
+
+//------------------------------- memory --------------------------------
+type memIncidentStore struct {
+	mu        sync.Mutex
+	incidents []mod.Incident
+}
+
+func newMemIncidentStore() *memIncidentStore {
+	return &memIncidentStore{}
+}
+
+func (s *memIncidentStore) Create(ctx context.Context, i mod.Incident) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i.Id == "" {
+		i.Id = primitive.NewObjectID().Hex()
+	}
+	i.Media = append([]string{}, i.Media...)
+	s.incidents = append(s.incidents, i)
+	return i.Id, nil
+}
+
+func (s *memIncidentStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Incident, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.incidents {
+		if v.Tenent == tenent && v.Id == id.Hex() {
+			v.Media = append([]string{}, v.Media...)
+			return v, nil
+		}
+	}
+	return mod.Incident{}, ErrNotFound
+}
+
+func (s *memIncidentStore) AddMedia(ctx context.Context, tenent string, id primitive.ObjectID, media []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, v := range s.incidents {
+		if v.Tenent != tenent || v.Id != id.Hex() {
+			continue
+		}
+		for _, m := range media {
+			found := false
+			for _, e := range v.Media {
+				if e == m {
+					found = true
+					break
+				}
+			}
+			if !found {
+				v.Media = append(v.Media, m)
+			}
+		}
+		s.incidents[n] = v
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *memIncidentStore) filter(match func(mod.Incident) bool) []mod.Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Incident{}
+	for _, v := range s.incidents {
+		if match(v) {
+			v.Media = append([]string{}, v.Media...)
+			c = append(c, v)
+		}
+	}
+	return c
+}
+
+func (s *memIncidentStore) List(ctx context.Context, tenent string) ([]mod.Incident, error) {
+	return s.filter(func(i mod.Incident) bool { return i.Tenent == tenent }), nil
+}
+
+func (s *memIncidentStore) ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Incident, error) {
+	return s.filter(func(i mod.Incident) bool { return i.Tenent == tenent && i.CompanyId == companyId }), nil
+}
+
+func (s *memIncidentStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, v := range s.incidents {
+		if v.Tenent == tenent && v.Id == id.Hex() {
+			s.incidents = append(s.incidents[:n], s.incidents[n+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memIncidentStore) Count(ctx context.Context, tenent string) (int64, error) {
+	c, _ := s.List(ctx, tenent)
+	return int64(len(c)), nil
+}
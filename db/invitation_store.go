@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * InvitationStore keeps at most one invitation per tenent and phone.
+ */
+type InvitationStore interface {
+	// Save replaces any existing invitation for the same tenent and phone.
+	Save(ctx context.Context, inv mod.Invitation) error
+	Find(ctx context.Context, tenent, phone string) (mod.Invitation, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Invitation, error)
+	// List returns the invitations of the tenent which were not used.
+	List(ctx context.Context, tenent string) ([]mod.Invitation, error)
+	// CountAttempt counts an attempt at the code and returns the updated record,
+	// ErrNotFound when the invitation is used or already had max attempts.
+	CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Invitation, error)
+	// Consume marks the invitation used, ErrNotFound if it was already used.
+	Consume(ctx context.Context, id primitive.ObjectID) error
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoInvitationStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoInvitationStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenent", Value: 1}, {Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoInvitationStore) Save(ctx context.Context, inv mod.Invitation) error {
+	filter := bson.M{"tenent": inv.Tenent, "phone": inv.Phone}
+	_, err := s.coll.ReplaceOne(ctx, filter, inv, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoInvitationStore) Find(ctx context.Context, tenent, phone string) (mod.Invitation, error) {
+	var inv mod.Invitation
+	err := s.coll.FindOne(ctx, bson.M{"tenent": tenent, "phone": phone}).Decode(&inv)
+	return inv, mongoErr(err)
+}
+
+func (s *mongoInvitationStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Invitation, error) {
+	var inv mod.Invitation
+	err := s.coll.FindOne(ctx, bson.M{"_id": id, "tenent": tenent}).Decode(&inv)
+	return inv, mongoErr(err)
+}
+
+func (s *mongoInvitationStore) List(ctx context.Context, tenent string) ([]mod.Invitation, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent, "used": false})
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Invitation{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoInvitationStore) CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Invitation, error) {
+	filter := bson.M{"_id": id, "used": false, "attempts": bson.M{"$lt": max}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var inv mod.Invitation
+	err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}}, opts).Decode(&inv)
+	return inv, mongoErr(err)
+}
+
+func (s *mongoInvitationStore) Consume(ctx context.Context, id primitive.ObjectID) error {
+	result := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "used": false}, bson.M{"$set": bson.M{"used": true}})
+	return mongoErr(result.Err())
+}
+
+func (s *mongoInvitationStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id, "tenent": tenent})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//------------------------------- memory --------------------------------
+type memInvitationStore struct {
+	mu          sync.Mutex
+	invitations map[string]mod.Invitation
+}
+
+func newMemInvitationStore() *memInvitationStore {
+	return &memInvitationStore{invitations: map[string]mod.Invitation{}}
+}
+
+func (s *memInvitationStore) Save(ctx context.Context, inv mod.Invitation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//like ReplaceOne, a resent invitation keeps its id.
+	key := inv.Tenent + "/" + inv.Phone
+	if old, ok := s.invitations[key]; ok {
+		inv.Id = old.Id
+	} else {
+		inv.Id = primitive.NewObjectID()
+	}
+	s.invitations[key] = inv
+	return nil
+}
+
+func (s *memInvitationStore) Find(ctx context.Context, tenent, phone string) (mod.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if inv, ok := s.invitations[tenent+"/"+phone]; ok {
+		return inv, nil
+	}
+	return mod.Invitation{}, ErrNotFound
+}
+
+func (s *memInvitationStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.invitations {
+		if v.Id == id && v.Tenent == tenent {
+			return v, nil
+		}
+	}
+	return mod.Invitation{}, ErrNotFound
+}
+
+func (s *memInvitationStore) List(ctx context.Context, tenent string) ([]mod.Invitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Invitation{}
+	for _, v := range s.invitations {
+		if v.Tenent == tenent && !v.Used {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c, nil
+}
+
+func (s *memInvitationStore) update(id primitive.ObjectID, fn func(*mod.Invitation) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.invitations {
+		if v.Id == id {
+			if err := fn(&v); err != nil {
+				return err
+			}
+			s.invitations[k] = v
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memInvitationStore) CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Invitation, error) {
+	var inv mod.Invitation
+	err := s.update(id, func(v *mod.Invitation) error {
+		if v.Used || v.Attempts >= max {
+			return ErrNotFound
+		}
+		v.Attempts++
+		inv = *v
+		return nil
+	})
+	return inv, err
+}
+
+func (s *memInvitationStore) Consume(ctx context.Context, id primitive.ObjectID) error {
+	return s.update(id, func(inv *mod.Invitation) error {
+		if inv.Used {
+			return ErrNotFound
+		}
+		inv.Used = true
+		return nil
+	})
+}
+
+func (s *memInvitationStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.invitations {
+		if v.Id == id && v.Tenent == tenent {
+			delete(s.invitations, k)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * LoginAttemptStore counts login attempts per key, an attempt is counted before the
+ * credentials are checked and taken back when they pass. Records disappear once they expire.
+ */
+type LoginAttemptStore interface {
+	Find(ctx context.Context, key string) (mod.LoginAttempt, error)
+	// CountAttempt counts an attempt made at now and returns the record as it was before,
+	// an empty record when there was none.
+	CountAttempt(ctx context.Context, key string, now, expires time.Time) (mod.LoginAttempt, error)
+	// Refund takes back the attempt counted at counted, the time of the last attempt goes
+	// back to previous unless a later attempt was counted meanwhile.
+	Refund(ctx context.Context, key string, counted, previous time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoLoginAttemptStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoLoginAttemptStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoLoginAttemptStore) Find(ctx context.Context, key string) (mod.LoginAttempt, error) {
+	var a mod.LoginAttempt
+	err := s.coll.FindOne(ctx, bson.M{"_id": key, "expires": bson.M{"$gt": time.Now()}}).Decode(&a)
+	return a, mongoErr(err)
+}
+
+func (s *mongoLoginAttemptStore) CountAttempt(ctx context.Context, key string, now, expires time.Time) (mod.LoginAttempt, error) {
+	//an expired record which was not removed yet starts counting again.
+	if _, err := s.coll.DeleteOne(ctx, bson.M{"_id": key, "expires": bson.M{"$lte": now}}); err != nil {
+		return mod.LoginAttempt{}, err
+	}
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"last": now, "expires": expires},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	var a mod.LoginAttempt
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return mod.LoginAttempt{Id: key}, nil
+	}
+	return a, mongoErr(err)
+}
+
+func (s *mongoLoginAttemptStore) Refund(ctx context.Context, key string, counted, previous time.Time) error {
+	filter := bson.M{"_id": key, "failures": bson.M{"$gt": 0}, "last": counted}
+	update := bson.M{"$inc": bson.M{"failures": -1}, "$set": bson.M{"last": previous}}
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil || result.MatchedCount > 0 {
+		return err
+	}
+	_, err = s.coll.UpdateOne(ctx, bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}})
+	return err
+}
+
+func (s *mongoLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+//------------------------------- memory --------------------------------
+type memLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]mod.LoginAttempt
+}
+
+func newMemLoginAttemptStore() *memLoginAttemptStore {
+	return &memLoginAttemptStore{attempts: map[string]mod.LoginAttempt{}}
+}
+
+func (s *memLoginAttemptStore) Find(ctx context.Context, key string) (mod.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok && a.Expires.After(time.Now()) {
+		return a, nil
+	}
+	return mod.LoginAttempt{}, ErrNotFound
+}
+
+func (s *memLoginAttemptStore) CountAttempt(ctx context.Context, key string, now, expires time.Time) (mod.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok || !a.Expires.After(now) {
+		a = mod.LoginAttempt{Id: key}
+	}
+	before := a
+	a.Failures++
+	a.Last = now
+	a.Expires = expires
+	s.attempts[key] = a
+	return before, nil
+}
+
+func (s *memLoginAttemptStore) Refund(ctx context.Context, key string, counted, previous time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok || a.Failures == 0 {
+		return nil
+	}
+	a.Failures--
+	if a.Last.Equal(counted) {
+		a.Last = previous
+	}
+	s.attempts[key] = a
+	return nil
+}
+
+func (s *memLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * Missed patrol exceptions, one per schedule window.
+ */
+type MissedPatrolStore interface {
+	// Create returns ErrDuplicate when the window was already recorded.
+	Create(ctx context.Context, m mod.MissedPatrol) error
+	// List returns the exceptions of the company with a window starting in [from, to), oldest first.
+	List(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.MissedPatrol, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoMissedPatrolStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoMissedPatrolStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "scheduleid", Value: 1}, {Key: "from", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoMissedPatrolStore) Create(ctx context.Context, m mod.MissedPatrol) error {
+	_, err := s.coll.InsertOne(ctx, m)
+	return mongoErr(err)
+}
+
+func (s *mongoMissedPatrolStore) List(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.MissedPatrol, error) {
+	filter := bson.M{"tenent": tenent, "companyid": companyId, "from": bson.M{"$gte": from, "$lt": to}}
+	cursor, err := s.coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "from", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.MissedPatrol{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+//------------------------------- memory --------------------------------
+type memMissedPatrolStore struct {
+	mu     sync.Mutex
+	missed map[primitive.ObjectID]mod.MissedPatrol
+}
+
+func newMemMissedPatrolStore() *memMissedPatrolStore {
+	return &memMissedPatrolStore{missed: map[primitive.ObjectID]mod.MissedPatrol{}}
+}
+
+func (s *memMissedPatrolStore) Create(ctx context.Context, m mod.MissedPatrol) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.missed {
+		if v.ScheduleId == m.ScheduleId && v.From.Equal(m.From) {
+			return ErrDuplicate
+		}
+	}
+	if m.Id.IsZero() {
+		m.Id = primitive.NewObjectID()
+	}
+	s.missed[m.Id] = m
+	return nil
+}
+
+func (s *memMissedPatrolStore) List(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.MissedPatrol, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.MissedPatrol{}
+	for _, v := range s.missed {
+		if v.Tenent == tenent && v.CompanyId == companyId && !v.From.Before(from) && v.From.Before(to) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].From.Before(c[j].From) })
+	return c, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/monitor_security/config"
 	"github.com/monitor_security/util"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -12,41 +13,42 @@ import (
 var Client *mongo.Client
 var ctx context.Context
 
-var UserDB *mongo.Collection //<FIXME: Delete>
-
-var ProprietorDB *mongo.Collection
-var GuardDB *mongo.Collection
-var CompanyDB *mongo.Collection
-
-var IncidentDB *mongo.Collection
-var PatrolDB *mongo.Collection
-
-func Init_Mongo() error {
-	clientOptions := options.Client().ApplyURI("mongodb://localhost:27017/?ssl=false").
-		SetAuth(options.Credential{
-			AuthSource: "testdb", Username: "user1", Password: "passw0rd",
+func Init_Mongo(cfg config.DBConfig) (*Store, error) {
+	clientOptions := options.Client().ApplyURI(cfg.URI)
+	if cfg.Username != "" {
+		authSource := cfg.AuthSource
+		if authSource == "" {
+			authSource = cfg.Name
+		}
+		clientOptions.SetAuth(options.Credential{
+			AuthSource: authSource, Username: cfg.Username, Password: cfg.Password,
 		})
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	Client, _ = mongo.Connect(ctx, clientOptions)
-	err := Client.Ping(ctx, nil)
+	var err error
+	Client, err = mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		util.Log.Printf("mongo connection error %v", err)
-		return err
+		return nil, err
+	}
+	err = Client.Ping(ctx, nil)
+	if err != nil {
+		util.Log.Printf("mongo connection error %v", err)
+		return nil, err
 	}
 
-	UserDB = Client.Database("testdb").Collection("users") // <FIXME :Delete>
-
-	ProprietorDB = Client.Database("testdb").Collection("proprietors")
-	GuardDB = Client.Database("testdb").Collection("guards")
-	CompanyDB = Client.Database("testdb").Collection("companies")
-	IncidentDB = Client.Database("testdb").Collection("incidents")
-	PatrolDB = Client.Database("testdb").Collection("patrols")
+	store := NewMongoStore(Client.Database(cfg.Name))
+	err = store.EnsureIndexes(ctx)
+	if err != nil {
+		util.Log.Printf("mongo index creation error %v", err)
+		return nil, err
+	}
 
 	util.Log.Println("done mongodb init ....")
-	return nil
+	return store, nil
 
 }
 
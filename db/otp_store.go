@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * OtpStore keeps at most one code per tenent, phone, usertype and purpose.
+ */
+type OtpStore interface {
+	Find(ctx context.Context, tenent, phone, usertype, purpose string) (mod.Otp, error)
+	// Save replaces any existing code for the same tenent, phone, usertype and purpose.
+	Save(ctx context.Context, otp mod.Otp) error
+	// CountAttempt counts an attempt at the code and returns the updated record,
+	// ErrNotFound when the code is used or already had max attempts.
+	CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Otp, error)
+	// Consume marks the code used, ErrNotFound if it was already used.
+	Consume(ctx context.Context, id primitive.ObjectID) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoOtpStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoOtpStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func otpKey(tenent, phone, usertype, purpose string) bson.M {
+	return bson.M{"tenent": tenent, "phone": phone, "usertype": usertype, "purpose": purpose}
+}
+
+func (s *mongoOtpStore) Find(ctx context.Context, tenent, phone, usertype, purpose string) (mod.Otp, error) {
+	var otp mod.Otp
+	err := s.coll.FindOne(ctx, otpKey(tenent, phone, usertype, purpose)).Decode(&otp)
+	return otp, mongoErr(err)
+}
+
+func (s *mongoOtpStore) Save(ctx context.Context, otp mod.Otp) error {
+	filter := otpKey(otp.Tenent, otp.Phone, otp.UserType, otp.Purpose)
+	_, err := s.coll.ReplaceOne(ctx, filter, otp, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoOtpStore) CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Otp, error) {
+	filter := bson.M{"_id": id, "used": false, "attempts": bson.M{"$lt": max}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var otp mod.Otp
+	err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"attempts": 1}}, opts).Decode(&otp)
+	return otp, mongoErr(err)
+}
+
+func (s *mongoOtpStore) Consume(ctx context.Context, id primitive.ObjectID) error {
+	result := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "used": false}, bson.M{"$set": bson.M{"used": true}})
+	return mongoErr(result.Err())
+}
+
+//------------------------------- memory --------------------------------
+type memOtpStore struct {
+	mu   sync.Mutex
+	otps map[string]mod.Otp
+}
+
+func newMemOtpStore() *memOtpStore {
+	return &memOtpStore{otps: map[string]mod.Otp{}}
+}
+
+func (s *memOtpStore) Find(ctx context.Context, tenent, phone, usertype, purpose string) (mod.Otp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if otp, ok := s.otps[tenent+"/"+phone+"/"+usertype+"/"+purpose]; ok {
+		return otp, nil
+	}
+	return mod.Otp{}, ErrNotFound
+}
+
+func (s *memOtpStore) Save(ctx context.Context, otp mod.Otp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	otp.Id = primitive.NewObjectID()
+	s.otps[otp.Tenent+"/"+otp.Phone+"/"+otp.UserType+"/"+otp.Purpose] = otp
+	return nil
+}
+
+func (s *memOtpStore) update(id primitive.ObjectID, fn func(*mod.Otp) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.otps {
+		if v.Id == id {
+			if err := fn(&v); err != nil {
+				return err
+			}
+			s.otps[k] = v
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memOtpStore) CountAttempt(ctx context.Context, id primitive.ObjectID, max int) (mod.Otp, error) {
+	var otp mod.Otp
+	err := s.update(id, func(o *mod.Otp) error {
+		if o.Used || o.Attempts >= max {
+			return ErrNotFound
+		}
+		o.Attempts++
+		otp = *o
+		return nil
+	})
+	return otp, err
+}
+
+func (s *memOtpStore) Consume(ctx context.Context, id primitive.ObjectID) error {
+	return s.update(id, func(o *mod.Otp) error {
+		if o.Used {
+			return ErrNotFound
+		}
+		o.Used = true
+		return nil
+	})
+}
@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type PatrolStore interface {
+	Create(ctx context.Context, p mod.Patrol) (string, error)
+	ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Patrol, error)
+	// ListBetween returns the patrols made in [from, to).
+	ListBetween(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.Patrol, error)
+	// ListNear returns the patrols within radius meters of lat,lng, nearest first.
+	ListNear(ctx context.Context, tenent, companyId string, lat, lng, radius float64) ([]mod.Patrol, error)
+	// ListWithin returns the patrols inside the box from south,west to north,east, compared
+	// as flat coordinates, west must not be greater than east.
+	ListWithin(ctx context.Context, tenent, companyId string, south, west, north, east float64) ([]mod.Patrol, error)
+	Count(ctx context.Context, tenent string) (int64, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoPatrolStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoPatrolStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{Keys: bson.D{{Key: "location", Value: "2dsphere"}}}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoPatrolStore) Create(ctx context.Context, p mod.Patrol) (string, error) {
+	result, err := s.coll.InsertOne(ctx, p)
+	if err != nil {
+		return "", mongoErr(err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return id.Hex(), nil
+	}
+	return p.Id, nil
+}
+
+func (s *mongoPatrolStore) find(ctx context.Context, filter bson.M) ([]mod.Patrol, error) {
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Patrol{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoPatrolStore) ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Patrol, error) {
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId})
+}
+
+func (s *mongoPatrolStore) ListBetween(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.Patrol, error) {
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId, "date": bson.M{"$gte": from, "$lt": to}})
+}
+
+func (s *mongoPatrolStore) ListNear(ctx context.Context, tenent, companyId string, lat, lng, radius float64) ([]mod.Patrol, error) {
+	near := bson.M{"$geometry": util.GeoPoint(lat, lng), "$maxDistance": radius}
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId, "location": bson.M{"$nearSphere": near}})
+}
+
+func (s *mongoPatrolStore) ListWithin(ctx context.Context, tenent, companyId string, south, west, north, east float64) ([]mod.Patrol, error) {
+	//$box compares flat coordinates, a GeoJSON polygon would follow the curve of the earth.
+	box := bson.A{bson.A{west, south}, bson.A{east, north}}
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId, "location": bson.M{"$geoWithin": bson.M{"$box": box}}})
+}
+
+func (s *mongoPatrolStore) Count(ctx context.Context, tenent string) (int64, error) {
+	return s.coll.CountDocuments(ctx, bson.M{"tenent": tenent})
+}
+
+//------------------------------- memory --------------------------------
+type memPatrolStore struct {
+	mu      sync.Mutex
+	patrols []mod.Patrol
+}
+
+func newMemPatrolStore() *memPatrolStore {
+	return &memPatrolStore{}
+}
+
+func (s *memPatrolStore) Create(ctx context.Context, p mod.Patrol) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.Id == "" {
+		p.Id = primitive.NewObjectID().Hex()
+	}
+	s.patrols = append(s.patrols, p)
+	return p.Id, nil
+}
+
+func (s *memPatrolStore) filter(match func(mod.Patrol) bool) []mod.Patrol {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Patrol{}
+	for _, v := range s.patrols {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	return c
+}
+
+func (s *memPatrolStore) ListByCompany(ctx context.Context, tenent, companyId string) ([]mod.Patrol, error) {
+	return s.filter(func(p mod.Patrol) bool { return p.Tenent == tenent && p.CompanyId == companyId }), nil
+}
+
+func (s *memPatrolStore) ListBetween(ctx context.Context, tenent, companyId string, from, to time.Time) ([]mod.Patrol, error) {
+	return s.filter(func(p mod.Patrol) bool {
+		return p.Tenent == tenent && p.CompanyId == companyId && !p.Date.Before(from) && p.Date.Before(to)
+	}), nil
+}
+
+func (s *memPatrolStore) ListNear(ctx context.Context, tenent, companyId string, lat, lng, radius float64) ([]mod.Patrol, error) {
+	distance := func(p mod.Patrol) float64 {
+		return util.Distance(lat, lng, p.Location.Coordinates[1], p.Location.Coordinates[0])
+	}
+	c := s.filter(func(p mod.Patrol) bool {
+		return p.Tenent == tenent && p.CompanyId == companyId && p.Location != nil && distance(p) <= radius
+	})
+	sort.SliceStable(c, func(i, j int) bool { return distance(c[i]) < distance(c[j]) })
+	return c, nil
+}
+
+func (s *memPatrolStore) ListWithin(ctx context.Context, tenent, companyId string, south, west, north, east float64) ([]mod.Patrol, error) {
+	return s.filter(func(p mod.Patrol) bool {
+		if p.Tenent != tenent || p.CompanyId != companyId || p.Location == nil {
+			return false
+		}
+		lng, lat := p.Location.Coordinates[0], p.Location.Coordinates[1]
+		return lat >= south && lat <= north && lng >= west && lng <= east
+	}), nil
+}
+
+func (s *memPatrolStore) Count(ctx context.Context, tenent string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, v := range s.patrols {
+		if v.Tenent == tenent {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,389 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * ProprietorStore keeps the proprietor side users, the owner of each tenent and its staff.
+ */
+type ProprietorStore interface {
+	Create(ctx context.Context, p mod.Proprietor) error
+	FindByPhone(ctx context.Context, phone string) (mod.Proprietor, error)
+	// FindByTenent returns the owner of the tenent.
+	FindByTenent(ctx context.Context, tenent string) (mod.Proprietor, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Proprietor, error)
+	// List returns the owner of every tenent.
+	List(ctx context.Context) ([]mod.Proprietor, error)
+	// ListByTenent returns the owner and staff of the tenent.
+	ListByTenent(ctx context.Context, tenent string) ([]mod.Proprietor, error)
+	// SetActive suspends or reactivates every user of the tenent.
+	SetActive(ctx context.Context, tenent string, active bool) error
+	SetRole(ctx context.Context, tenent string, id primitive.ObjectID, role string) error
+	// Update sets the profile fields if the user is still at version, ErrVersionConflict if not.
+	Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Proprietor, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+	// UpdatePassword replaces the password only if it still equals old.
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error
+	UpdateTotp(ctx context.Context, id primitive.ObjectID, totp mod.Totp) error
+	// UseTotpStep records the accepted step, ErrNotFound if it is not newer than the last one.
+	UseTotpStep(ctx context.Context, id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes the hashed code, ErrNotFound if it is not there.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error
+	SetRequire2FA(ctx context.Context, tenent string, required bool) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoProprietorStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoProprietorStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoProprietorStore) Create(ctx context.Context, p mod.Proprietor) error {
+	_, err := s.coll.InsertOne(ctx, p)
+	return mongoErr(err)
+}
+
+func (s *mongoProprietorStore) FindByPhone(ctx context.Context, phone string) (mod.Proprietor, error) {
+	var p mod.Proprietor
+	err := s.coll.FindOne(ctx, bson.M{"phone": phone}).Decode(&p)
+	return p, mongoErr(err)
+}
+
+//owners registered before staff existed have no role.
+var ownerFilter = bson.M{"$in": bson.A{nil, "", mod.STAFF_OWNER}}
+
+func (s *mongoProprietorStore) FindByTenent(ctx context.Context, tenent string) (mod.Proprietor, error) {
+	var p mod.Proprietor
+	err := s.coll.FindOne(ctx, bson.M{"tenent": tenent, "role": ownerFilter}).Decode(&p)
+	return p, mongoErr(err)
+}
+
+func (s *mongoProprietorStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Proprietor, error) {
+	var p mod.Proprietor
+	err := s.coll.FindOne(ctx, bson.M{"_id": id, "tenent": tenent}).Decode(&p)
+	return p, mongoErr(err)
+}
+
+func (s *mongoProprietorStore) find(ctx context.Context, filter bson.M) ([]mod.Proprietor, error) {
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Proprietor{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoProprietorStore) List(ctx context.Context) ([]mod.Proprietor, error) {
+	return s.find(ctx, bson.M{"role": ownerFilter})
+}
+
+func (s *mongoProprietorStore) ListByTenent(ctx context.Context, tenent string) ([]mod.Proprietor, error) {
+	return s.find(ctx, bson.M{"tenent": tenent})
+}
+
+func (s *mongoProprietorStore) SetActive(ctx context.Context, tenent string, active bool) error {
+	result, err := s.coll.UpdateMany(ctx, bson.M{"tenent": tenent}, bson.M{"$set": bson.M{"active": active}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoProprietorStore) SetRole(ctx context.Context, tenent string, id primitive.ObjectID, role string) error {
+	return s.updateOne(ctx, bson.M{"_id": id, "tenent": tenent}, bson.M{"$set": bson.M{"role": role}})
+}
+
+func (s *mongoProprietorStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	result, err := s.coll.DeleteOne(ctx, bson.M{"_id": id, "tenent": tenent})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoProprietorStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	result, err := s.coll.UpdateOne(ctx, bson.M{"_id": id, "password": old}, bson.M{"$set": bson.M{"password": password}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoProprietorStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Proprietor, error) {
+	var p mod.Proprietor
+	err := updateVersioned(ctx, s.coll, bson.M{"_id": id, "tenent": tenent}, version, fields, &p)
+	return p, err
+}
+
+func (s *mongoProprietorStore) updateOne(ctx context.Context, filter, update bson.M) error {
+	result, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoProprietorStore) UpdateTotp(ctx context.Context, id primitive.ObjectID, totp mod.Totp) error {
+	return s.updateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"totp": totp}})
+}
+
+func (s *mongoProprietorStore) UseTotpStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	filter := bson.M{"_id": id, "totp.laststep": bson.M{"$lt": step}}
+	return s.updateOne(ctx, filter, bson.M{"$set": bson.M{"totp.laststep": step}})
+}
+
+func (s *mongoProprietorStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	filter := bson.M{"_id": id, "totp.recoverycodes": hash}
+	return s.updateOne(ctx, filter, bson.M{"$pull": bson.M{"totp.recoverycodes": hash}})
+}
+
+func (s *mongoProprietorStore) SetRequire2FA(ctx context.Context, tenent string, required bool) error {
+	result, err := s.coll.UpdateMany(ctx, bson.M{"tenent": tenent}, bson.M{"$set": bson.M{"require2fa": required}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//------------------------------- memory --------------------------------
+type memProprietorStore struct {
+	mu          sync.Mutex
+	proprietors map[primitive.ObjectID]mod.Proprietor
+}
+
+func newMemProprietorStore() *memProprietorStore {
+	return &memProprietorStore{proprietors: map[primitive.ObjectID]mod.Proprietor{}}
+}
+
+func (s *memProprietorStore) Create(ctx context.Context, p mod.Proprietor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.proprietors {
+		if v.Phone == p.Phone {
+			return ErrDuplicate
+		}
+	}
+	if p.Id.IsZero() {
+		p.Id = primitive.NewObjectID()
+	}
+	s.proprietors[p.Id] = p
+	return nil
+}
+
+func (s *memProprietorStore) find(match func(mod.Proprietor) bool) (mod.Proprietor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.proprietors {
+		if match(v) {
+			return v, nil
+		}
+	}
+	return mod.Proprietor{}, ErrNotFound
+}
+
+func (s *memProprietorStore) FindByPhone(ctx context.Context, phone string) (mod.Proprietor, error) {
+	return s.find(func(p mod.Proprietor) bool { return p.Phone == phone })
+}
+
+func isOwner(p mod.Proprietor) bool {
+	return p.Role == "" || p.Role == mod.STAFF_OWNER
+}
+
+func (s *memProprietorStore) FindByTenent(ctx context.Context, tenent string) (mod.Proprietor, error) {
+	return s.find(func(p mod.Proprietor) bool { return p.Tenent == tenent && isOwner(p) })
+}
+
+func (s *memProprietorStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Proprietor, error) {
+	return s.find(func(p mod.Proprietor) bool { return p.Id == id && p.Tenent == tenent })
+}
+
+func (s *memProprietorStore) filter(match func(mod.Proprietor) bool) []mod.Proprietor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Proprietor{}
+	for _, v := range s.proprietors {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c
+}
+
+func (s *memProprietorStore) List(ctx context.Context) ([]mod.Proprietor, error) {
+	return s.filter(isOwner), nil
+}
+
+func (s *memProprietorStore) ListByTenent(ctx context.Context, tenent string) ([]mod.Proprietor, error) {
+	return s.filter(func(p mod.Proprietor) bool { return p.Tenent == tenent }), nil
+}
+
+func (s *memProprietorStore) SetActive(ctx context.Context, tenent string, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for id, v := range s.proprietors {
+		if v.Tenent == tenent {
+			v.Active = active
+			s.proprietors[id] = v
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *memProprietorStore) SetRole(ctx context.Context, tenent string, id primitive.ObjectID, role string) error {
+	return s.update(id, func(p *mod.Proprietor) error {
+		if p.Tenent != tenent {
+			return ErrNotFound
+		}
+		p.Role = role
+		return nil
+	})
+}
+
+func (s *memProprietorStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.proprietors[id]
+	if !ok || v.Tenent != tenent {
+		return ErrNotFound
+	}
+	delete(s.proprietors, id)
+	return nil
+}
+
+func (s *memProprietorStore) UpdatePassword(ctx context.Context, id primitive.ObjectID, old, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.proprietors[id]
+	if !ok || v.Password != old {
+		return ErrNotFound
+	}
+	v.Password = password
+	s.proprietors[id] = v
+	return nil
+}
+
+func (s *memProprietorStore) update(id primitive.ObjectID, fn func(*mod.Proprietor) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.proprietors[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := fn(&v); err != nil {
+		return err
+	}
+	s.proprietors[id] = v
+	return nil
+}
+
+func (s *memProprietorStore) Update(ctx context.Context, tenent string, id primitive.ObjectID, version int64, fields bson.M) (mod.Proprietor, error) {
+	var p mod.Proprietor
+	err := s.update(id, func(v *mod.Proprietor) error {
+		if v.Tenent != tenent {
+			return ErrNotFound
+		}
+		if v.Version != version {
+			return ErrVersionConflict
+		}
+		if err := setFields(v, fields); err != nil {
+			return err
+		}
+		v.Version++
+		p = *v
+		return nil
+	})
+	return p, err
+}
+
+func (s *memProprietorStore) UpdateTotp(ctx context.Context, id primitive.ObjectID, totp mod.Totp) error {
+	return s.update(id, func(p *mod.Proprietor) error {
+		p.Totp = totp
+		return nil
+	})
+}
+
+func (s *memProprietorStore) UseTotpStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	return s.update(id, func(p *mod.Proprietor) error {
+		if p.Totp.LastStep >= step {
+			return ErrNotFound
+		}
+		p.Totp.LastStep = step
+		return nil
+	})
+}
+
+func (s *memProprietorStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	return s.update(id, func(p *mod.Proprietor) error {
+		for i, c := range p.Totp.RecoveryCodes {
+			if c == hash {
+				p.Totp.RecoveryCodes = append(p.Totp.RecoveryCodes[:i:i], p.Totp.RecoveryCodes[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+}
+
+func (s *memProprietorStore) SetRequire2FA(ctx context.Context, tenent string, required bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for id, v := range s.proprietors {
+		if v.Tenent == tenent {
+			v.Require2FA = required
+			s.proprietors[id] = v
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package driver
+
+import (
+	"context"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * RefreshTokenStore keeps the hashed refresh tokens, one family per login session.
+ */
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token mod.RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (mod.RefreshToken, error)
+	// Rotate marks the token used, ErrNotFound if it was already used or revoked.
+	Rotate(ctx context.Context, id primitive.ObjectID) error
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeSubject(ctx context.Context, usertype, tenent, phone string) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoRefreshTokenStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoRefreshTokenStore) ensureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "family", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err := s.coll.Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (s *mongoRefreshTokenStore) Create(ctx context.Context, token mod.RefreshToken) error {
+	_, err := s.coll.InsertOne(ctx, token)
+	return mongoErr(err)
+}
+
+func (s *mongoRefreshTokenStore) FindByHash(ctx context.Context, hash string) (mod.RefreshToken, error) {
+	var token mod.RefreshToken
+	err := s.coll.FindOne(ctx, bson.M{"hash": hash}).Decode(&token)
+	return token, mongoErr(err)
+}
+
+func (s *mongoRefreshTokenStore) Rotate(ctx context.Context, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id, "used": false, "revoked": false}
+	result := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used": true}})
+	return mongoErr(result.Err())
+}
+
+func (s *mongoRefreshTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	_, err := s.coll.UpdateMany(ctx, bson.M{"family": family}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (s *mongoRefreshTokenStore) RevokeSubject(ctx context.Context, usertype, tenent, phone string) error {
+	filter := bson.M{"usertype": usertype, "tenent": tenent, "phone": phone}
+	_, err := s.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+//------------------------------- memory --------------------------------
+type memRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]mod.RefreshToken
+}
+
+func newMemRefreshTokenStore() *memRefreshTokenStore {
+	return &memRefreshTokenStore{tokens: map[string]mod.RefreshToken{}}
+}
+
+func (s *memRefreshTokenStore) Create(ctx context.Context, token mod.RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.Hash]; ok {
+		return ErrDuplicate
+	}
+	token.Id = primitive.NewObjectID()
+	s.tokens[token.Hash] = token
+	return nil
+}
+
+func (s *memRefreshTokenStore) FindByHash(ctx context.Context, hash string) (mod.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.tokens[hash]; ok {
+		return token, nil
+	}
+	return mod.RefreshToken{}, ErrNotFound
+}
+
+func (s *memRefreshTokenStore) Rotate(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.tokens {
+		if v.Id == id {
+			if v.Used || v.Revoked {
+				return ErrNotFound
+			}
+			v.Used = true
+			s.tokens[k] = v
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memRefreshTokenStore) revokeWhere(match func(mod.RefreshToken) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, v := range s.tokens {
+		if match(v) {
+			v.Revoked = true
+			s.tokens[k] = v
+		}
+	}
+}
+
+func (s *memRefreshTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	s.revokeWhere(func(t mod.RefreshToken) bool { return t.Family == family })
+	return nil
+}
+
+func (s *memRefreshTokenStore) RevokeSubject(ctx context.Context, usertype, tenent, phone string) error {
+	s.revokeWhere(func(t mod.RefreshToken) bool {
+		return t.UserType == usertype && t.Tenent == tenent && t.Phone == phone
+	})
+	return nil
+}
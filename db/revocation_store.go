@@ -0,0 +1,116 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * RevocationStore is the server side denylist consulted on every authenticated request.
+ */
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, expires time.Time) error
+	// RevokeSubject revokes every token of subject issued at or before before.
+	RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error
+	IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error)
+}
+
+func jtiKey(jti string) string {
+	return "jti:" + jti
+}
+
+func subjectKey(subject string) string {
+	return "sub:" + subject
+}
+
+func revoked(r mod.Revocation, jti, subject string, issuedAt time.Time) bool {
+	if jti != "" && r.Id == jtiKey(jti) {
+		return true
+	}
+	return r.Id == subjectKey(subject) && !issuedAt.After(r.Before)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoRevocationStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoRevocationStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoRevocationStore) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	r := mod.Revocation{Id: jtiKey(jti), Before: time.Now(), Expires: expires}
+	_, err := s.coll.ReplaceOne(ctx, bson.M{"_id": r.Id}, r, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoRevocationStore) RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error {
+	r := mod.Revocation{Id: subjectKey(subject), Before: before, Expires: expires}
+	_, err := s.coll.ReplaceOne(ctx, bson.M{"_id": r.Id}, r, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoRevocationStore) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"_id": bson.M{"$in": []string{jtiKey(jti), subjectKey(subject)}}})
+	if err != nil {
+		return false, err
+	}
+	c := []mod.Revocation{}
+	if err := cursor.All(ctx, &c); err != nil {
+		return false, err
+	}
+	for _, r := range c {
+		if revoked(r, jti, subject, issuedAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//------------------------------- memory --------------------------------
+type memRevocationStore struct {
+	mu          sync.Mutex
+	revocations map[string]mod.Revocation
+}
+
+func newMemRevocationStore() *memRevocationStore {
+	return &memRevocationStore{revocations: map[string]mod.Revocation{}}
+}
+
+func (s *memRevocationStore) RevokeToken(ctx context.Context, jti string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revocations[jtiKey(jti)] = mod.Revocation{Id: jtiKey(jti), Before: time.Now(), Expires: expires}
+	return nil
+}
+
+func (s *memRevocationStore) RevokeSubject(ctx context.Context, subject string, before, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revocations[subjectKey(subject)] = mod.Revocation{Id: subjectKey(subject), Before: before, Expires: expires}
+	return nil
+}
+
+func (s *memRevocationStore) IsRevoked(ctx context.Context, jti, subject string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range []string{jtiKey(jti), subjectKey(subject)} {
+		if r, ok := s.revocations[key]; ok && revoked(r, jti, subject, issuedAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,140 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+ * Patrol schedules of the companies of a tenent.
+ */
+type ScheduleStore interface {
+	Create(ctx context.Context, s mod.PatrolSchedule) (mod.PatrolSchedule, error)
+	List(ctx context.Context, tenent, companyId string) ([]mod.PatrolSchedule, error)
+	// ListByTenent returns the schedules of every company of the tenent.
+	ListByTenent(ctx context.Context, tenent string) ([]mod.PatrolSchedule, error)
+	// ListAll returns the schedules of every tenent, for the missed patrol evaluator.
+	ListAll(ctx context.Context) ([]mod.PatrolSchedule, error)
+	SetEvaluated(ctx context.Context, id primitive.ObjectID, until time.Time) error
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoScheduleStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoScheduleStore) Create(ctx context.Context, p mod.PatrolSchedule) (mod.PatrolSchedule, error) {
+	if p.Id.IsZero() {
+		p.Id = primitive.NewObjectID()
+	}
+	_, err := s.coll.InsertOne(ctx, p)
+	return p, mongoErr(err)
+}
+
+func (s *mongoScheduleStore) find(ctx context.Context, filter bson.M) ([]mod.PatrolSchedule, error) {
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.PatrolSchedule{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoScheduleStore) List(ctx context.Context, tenent, companyId string) ([]mod.PatrolSchedule, error) {
+	return s.find(ctx, bson.M{"tenent": tenent, "companyid": companyId})
+}
+
+func (s *mongoScheduleStore) ListByTenent(ctx context.Context, tenent string) ([]mod.PatrolSchedule, error) {
+	return s.find(ctx, bson.M{"tenent": tenent})
+}
+
+func (s *mongoScheduleStore) ListAll(ctx context.Context) ([]mod.PatrolSchedule, error) {
+	return s.find(ctx, bson.M{})
+}
+
+func (s *mongoScheduleStore) SetEvaluated(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"evaluateduntil": until}})
+	return err
+}
+
+func (s *mongoScheduleStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	return mongoErr(s.coll.FindOneAndDelete(ctx, bson.M{"_id": id, "tenent": tenent}).Err())
+}
+
+//------------------------------- memory --------------------------------
+type memScheduleStore struct {
+	mu        sync.Mutex
+	schedules map[primitive.ObjectID]mod.PatrolSchedule
+}
+
+func newMemScheduleStore() *memScheduleStore {
+	return &memScheduleStore{schedules: map[primitive.ObjectID]mod.PatrolSchedule{}}
+}
+
+func (s *memScheduleStore) Create(ctx context.Context, p mod.PatrolSchedule) (mod.PatrolSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.Id.IsZero() {
+		p.Id = primitive.NewObjectID()
+	}
+	s.schedules[p.Id] = p
+	return p, nil
+}
+
+func (s *memScheduleStore) filter(match func(mod.PatrolSchedule) bool) []mod.PatrolSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.PatrolSchedule{}
+	for _, v := range s.schedules {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c
+}
+
+func (s *memScheduleStore) List(ctx context.Context, tenent, companyId string) ([]mod.PatrolSchedule, error) {
+	return s.filter(func(v mod.PatrolSchedule) bool { return v.Tenent == tenent && v.CompanyId == companyId }), nil
+}
+
+func (s *memScheduleStore) ListByTenent(ctx context.Context, tenent string) ([]mod.PatrolSchedule, error) {
+	return s.filter(func(v mod.PatrolSchedule) bool { return v.Tenent == tenent }), nil
+}
+
+func (s *memScheduleStore) ListAll(ctx context.Context) ([]mod.PatrolSchedule, error) {
+	return s.filter(func(v mod.PatrolSchedule) bool { return true }), nil
+}
+
+func (s *memScheduleStore) SetEvaluated(ctx context.Context, id primitive.ObjectID, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.schedules[id]; ok {
+		v.EvaluatedUntil = until
+		s.schedules[id] = v
+	}
+	return nil
+}
+
+func (s *memScheduleStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.schedules[id]; ok && v.Tenent == tenent {
+		delete(s.schedules, id)
+		return nil
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,87 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * SigningKeyStore shares the token signing keys between instances.
+ */
+type SigningKeyStore interface {
+	Create(ctx context.Context, key mod.SigningKey) error
+	// List returns the unexpired keys of alg, oldest first.
+	List(ctx context.Context, alg string) ([]mod.SigningKey, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoSigningKeyStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoSigningKeyStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoSigningKeyStore) Create(ctx context.Context, key mod.SigningKey) error {
+	_, err := s.coll.InsertOne(ctx, key)
+	return mongoErr(err)
+}
+
+func (s *mongoSigningKeyStore) List(ctx context.Context, alg string) ([]mod.SigningKey, error) {
+	filter := bson.M{"alg": alg, "expires": bson.M{"$gt": time.Now()}}
+	cursor, err := s.coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.SigningKey{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+//------------------------------- memory --------------------------------
+type memSigningKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]mod.SigningKey
+}
+
+func newMemSigningKeyStore() *memSigningKeyStore {
+	return &memSigningKeyStore{keys: map[string]mod.SigningKey{}}
+}
+
+func (s *memSigningKeyStore) Create(ctx context.Context, key mod.SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.Kid]; ok {
+		return ErrDuplicate
+	}
+	s.keys[key.Kid] = key
+	return nil
+}
+
+func (s *memSigningKeyStore) List(ctx context.Context, alg string) ([]mod.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.SigningKey{}
+	for _, k := range s.keys {
+		if k.Alg == alg && k.Expires.After(time.Now()) {
+			c = append(c, k)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Created.Before(c[j].Created) })
+	return c, nil
+}
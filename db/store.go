@@ -0,0 +1,242 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/validator.v2"
+)
+
+var ErrNotFound = errors.New("document not found")
+var ErrDuplicate = errors.New("duplicate document")
+var ErrVersionConflict = errors.New("document version changed")
+
+/*
+ * Store groups the persistence used by the api handlers, NewMongoStore is used in
+ * production and NewMemoryStore in tests.
+ */
+type Store struct {
+	Admins        AdminStore
+	Proprietors   ProprietorStore
+	Guards        GuardStore
+	Clients       ClientStore
+	Companies     CompanyStore
+	Checkpoints   CheckpointStore
+	Patrols       PatrolStore
+	Schedules     ScheduleStore
+	MissedPatrols MissedPatrolStore
+	TourRoutes    TourRouteStore
+	Tours         TourStore
+	Incidents     IncidentStore
+	Otps          OtpStore
+	Revocations   RevocationStore
+	RefreshTokens RefreshTokenStore
+	SigningKeys   SigningKeyStore
+	Invitations   InvitationStore
+	LoginAttempts LoginAttemptStore
+}
+
+func NewMongoStore(database *mongo.Database) *Store {
+	return &Store{
+		Admins:        &mongoAdminStore{database.Collection("admins")},
+		Proprietors:   &mongoProprietorStore{database.Collection("proprietors")},
+		Guards:        &mongoGuardStore{database.Collection("guards")},
+		Clients:       &mongoClientStore{database.Collection("clients")},
+		Companies:     &mongoCompanyStore{database.Collection("companies")},
+		Checkpoints:   &mongoCheckpointStore{database.Collection("checkpoints")},
+		Patrols:       &mongoPatrolStore{database.Collection("patrols")},
+		Schedules:     &mongoScheduleStore{database.Collection("schedules")},
+		MissedPatrols: &mongoMissedPatrolStore{database.Collection("missedpatrols")},
+		TourRoutes:    &mongoTourRouteStore{database.Collection("tourroutes")},
+		Tours:         &mongoTourStore{database.Collection("tours")},
+		Incidents:     &mongoIncidentStore{database.Collection("incidents")},
+		Otps:          &mongoOtpStore{database.Collection("otps")},
+		Revocations:   &mongoRevocationStore{database.Collection("revocations")},
+		RefreshTokens: &mongoRefreshTokenStore{database.Collection("refreshtokens")},
+		SigningKeys:   &mongoSigningKeyStore{database.Collection("signingkeys")},
+		Invitations:   &mongoInvitationStore{database.Collection("invitations")},
+		LoginAttempts: &mongoLoginAttemptStore{database.Collection("loginattempts")},
+	}
+}
+
+func NewMemoryStore() *Store {
+	return &Store{
+		Admins:        newMemAdminStore(),
+		Proprietors:   newMemProprietorStore(),
+		Guards:        newMemGuardStore(),
+		Clients:       newMemClientStore(),
+		Companies:     newMemCompanyStore(),
+		Checkpoints:   newMemCheckpointStore(),
+		Patrols:       newMemPatrolStore(),
+		Schedules:     newMemScheduleStore(),
+		MissedPatrols: newMemMissedPatrolStore(),
+		TourRoutes:    newMemTourRouteStore(),
+		Tours:         newMemTourStore(),
+		Incidents:     newMemIncidentStore(),
+		Otps:          newMemOtpStore(),
+		Revocations:   newMemRevocationStore(),
+		RefreshTokens: newMemRefreshTokenStore(),
+		SigningKeys:   newMemSigningKeyStore(),
+		Invitations:   newMemInvitationStore(),
+		LoginAttempts: newMemLoginAttemptStore(),
+	}
+}
+
+/*
+ * indexer is implemented by stores which need indexes created at startup.
+ */
+type indexer interface {
+	ensureIndexes(ctx context.Context) error
+}
+
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	stores := []interface{}{s.Admins, s.Proprietors, s.Guards, s.Clients, s.Companies, s.Checkpoints, s.Patrols, s.Schedules, s.MissedPatrols, s.TourRoutes, s.Tours, s.Incidents, s.Otps, s.Revocations, s.RefreshTokens, s.SigningKeys, s.Invitations, s.LoginAttempts}
+	for _, st := range stores {
+		if i, ok := st.(indexer); ok {
+			if err := i.ensureIndexes(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ * Create the first platform admin, nothing is done once any admin exists.
+ */
+func SeedAdmin(s *Store, admin mod.Admin) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := s.Admins.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	admin.UserType = mod.ADMIN
+	if err := validator.NewValidator().Validate(admin); err != nil {
+		return err
+	}
+	admin.Password, err = util.HashPassword(admin.Password)
+	if err != nil {
+		return err
+	}
+	if err := s.Admins.Create(ctx, admin); err != nil {
+		return err
+	}
+	util.Log.Printf("Seeded platform admin : %v", admin.Phone)
+	return nil
+}
+
+//Time a new key is published before it signs, longer than the JWKS cache max-age.
+const KeyPublishDelay = 10 * time.Minute
+
+/*
+ * Create a signing key when the newest one is due for rotation and install the
+ * keyring. A new key only signs once it is KeyPublishDelay old, so every instance
+ * and JWKS consumer already knows it, older keys keep verifying until they expire.
+ * Called at startup and then every minute.
+ */
+func RotateSigningKeys(s *Store, alg string, rotation, tokenLifetime time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys, err := s.SigningKeys.List(ctx, alg)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if len(keys) == 0 || !keys[len(keys)-1].Created.After(now.Add(-rotation+KeyPublishDelay)) {
+		key, err := util.GenerateSigningKey(alg)
+		if err != nil {
+			return err
+		}
+		key.Expires = key.Created.Add(rotation + KeyPublishDelay + tokenLifetime)
+		if err := s.SigningKeys.Create(ctx, key); err != nil {
+			return err
+		}
+		util.Log.Printf("Created %v signing key : %v", alg, key.Kid)
+		keys = append(keys, key)
+	}
+
+	//newest published key signs, the first key signs right away.
+	signing := keys[0]
+	for _, k := range keys {
+		if !k.Created.After(now.Add(-KeyPublishDelay)) {
+			signing = k
+		}
+	}
+	return util.SetSigningKeys(signing, keys)
+}
+
+func mongoErr(err error) error {
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+/*
+ * Set fields ( keyed by bson name ) of the document matching filter if it is still at
+ * version, the version is incremented and the updated document decoded into out.
+ * Documents written before versioning have no version and count as version 0.
+ */
+func updateVersioned(ctx context.Context, coll *mongo.Collection, filter bson.M, version int64, fields bson.M, out interface{}) error {
+	versioned := bson.M{"version": version}
+	if version == 0 {
+		versioned["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	for k, v := range filter {
+		versioned[k] = v
+	}
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(ctx, versioned, update, opts).Decode(out)
+	if err != mongo.ErrNoDocuments {
+		return mongoErr(err)
+	}
+	count, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+/*
+ * Memory store counterpart of $set, doc is a pointer to the document.
+ */
+func setFields(doc interface{}, fields bson.M) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	m := bson.M{}
+	if err := bson.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		m[k] = v
+	}
+	if raw, err = bson.Marshal(m); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(doc).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return bson.Unmarshal(raw, doc)
+}
@@ -0,0 +1,111 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+ * Tour routes of the companies of a tenent.
+ */
+type TourRouteStore interface {
+	Create(ctx context.Context, r mod.TourRoute) (mod.TourRoute, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.TourRoute, error)
+	List(ctx context.Context, tenent, companyId string) ([]mod.TourRoute, error)
+	DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoTourRouteStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoTourRouteStore) Create(ctx context.Context, r mod.TourRoute) (mod.TourRoute, error) {
+	if r.Id.IsZero() {
+		r.Id = primitive.NewObjectID()
+	}
+	_, err := s.coll.InsertOne(ctx, r)
+	return r, mongoErr(err)
+}
+
+func (s *mongoTourRouteStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.TourRoute, error) {
+	var r mod.TourRoute
+	err := s.coll.FindOne(ctx, bson.M{"_id": id, "tenent": tenent}).Decode(&r)
+	return r, mongoErr(err)
+}
+
+func (s *mongoTourRouteStore) List(ctx context.Context, tenent, companyId string) ([]mod.TourRoute, error) {
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent, "companyid": companyId})
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.TourRoute{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoTourRouteStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	return mongoErr(s.coll.FindOneAndDelete(ctx, bson.M{"_id": id, "tenent": tenent}).Err())
+}
+
+//------------------------------- memory --------------------------------
+type memTourRouteStore struct {
+	mu     sync.Mutex
+	routes map[primitive.ObjectID]mod.TourRoute
+}
+
+func newMemTourRouteStore() *memTourRouteStore {
+	return &memTourRouteStore{routes: map[primitive.ObjectID]mod.TourRoute{}}
+}
+
+func (s *memTourRouteStore) Create(ctx context.Context, r mod.TourRoute) (mod.TourRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Id.IsZero() {
+		r.Id = primitive.NewObjectID()
+	}
+	s.routes[r.Id] = r
+	return r, nil
+}
+
+func (s *memTourRouteStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.TourRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.routes[id]; ok && r.Tenent == tenent {
+		return r, nil
+	}
+	return mod.TourRoute{}, ErrNotFound
+}
+
+func (s *memTourRouteStore) List(ctx context.Context, tenent, companyId string) ([]mod.TourRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.TourRoute{}
+	for _, v := range s.routes {
+		if v.Tenent == tenent && v.CompanyId == companyId {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Id.Hex() < c[j].Id.Hex() })
+	return c, nil
+}
+
+func (s *memTourRouteStore) DeleteById(ctx context.Context, tenent string, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.routes[id]; ok && v.Tenent == tenent {
+		delete(s.routes, id)
+		return nil
+	}
+	return ErrNotFound
+}
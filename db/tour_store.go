@@ -0,0 +1,190 @@
+package driver
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	mod "github.com/monitor_security/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+ * Tours walked by the users of a tenent, a user has at most one active tour.
+ */
+type TourStore interface {
+	// Create returns ErrDuplicate when the user already has an active tour.
+	Create(ctx context.Context, t mod.Tour) (mod.Tour, error)
+	FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Tour, error)
+	FindActive(ctx context.Context, tenent, phone string) (mod.Tour, error)
+	// List returns the tours of the company, latest first.
+	List(ctx context.Context, tenent, companyId string) ([]mod.Tour, error)
+	// AddLeg appends a scan to the tour, ErrNotFound when it is no longer active.
+	AddLeg(ctx context.Context, tenent string, id primitive.ObjectID, leg mod.TourLeg) error
+	// Finish closes the active tour with the status.
+	Finish(ctx context.Context, tenent string, id primitive.ObjectID, status string, missing []string, finished time.Time) (mod.Tour, error)
+}
+
+//------------------------------- mongo ---------------------------------
+type mongoTourStore struct {
+	coll *mongo.Collection
+}
+
+func (s *mongoTourStore) ensureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenent", Value: 1}, {Key: "phone", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"status": mod.TOUR_ACTIVE}),
+	}
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (s *mongoTourStore) Create(ctx context.Context, t mod.Tour) (mod.Tour, error) {
+	if t.Id.IsZero() {
+		t.Id = primitive.NewObjectID()
+	}
+	_, err := s.coll.InsertOne(ctx, t)
+	return t, mongoErr(err)
+}
+
+func (s *mongoTourStore) findOne(ctx context.Context, filter bson.M) (mod.Tour, error) {
+	var t mod.Tour
+	err := s.coll.FindOne(ctx, filter).Decode(&t)
+	return t, mongoErr(err)
+}
+
+func (s *mongoTourStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Tour, error) {
+	return s.findOne(ctx, bson.M{"_id": id, "tenent": tenent})
+}
+
+func (s *mongoTourStore) FindActive(ctx context.Context, tenent, phone string) (mod.Tour, error) {
+	return s.findOne(ctx, bson.M{"tenent": tenent, "phone": phone, "status": mod.TOUR_ACTIVE})
+}
+
+func (s *mongoTourStore) List(ctx context.Context, tenent, companyId string) ([]mod.Tour, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "started", Value: -1}})
+	cursor, err := s.coll.Find(ctx, bson.M{"tenent": tenent, "companyid": companyId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := []mod.Tour{}
+	err = cursor.All(ctx, &c)
+	return c, err
+}
+
+func (s *mongoTourStore) AddLeg(ctx context.Context, tenent string, id primitive.ObjectID, leg mod.TourLeg) error {
+	filter := bson.M{"_id": id, "tenent": tenent, "status": mod.TOUR_ACTIVE}
+	result, err := s.coll.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"legs": leg}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoTourStore) Finish(ctx context.Context, tenent string, id primitive.ObjectID, status string, missing []string, finished time.Time) (mod.Tour, error) {
+	filter := bson.M{"_id": id, "tenent": tenent, "status": mod.TOUR_ACTIVE}
+	update := bson.M{"$set": bson.M{"status": status, "missing": missing, "finished": finished}}
+	var t mod.Tour
+	err := s.coll.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&t)
+	return t, mongoErr(err)
+}
+
+//------------------------------- memory --------------------------------
+type memTourStore struct {
+	mu    sync.Mutex
+	tours map[primitive.ObjectID]mod.Tour
+}
+
+func newMemTourStore() *memTourStore {
+	return &memTourStore{tours: map[primitive.ObjectID]mod.Tour{}}
+}
+
+func (s *memTourStore) Create(ctx context.Context, t mod.Tour) (mod.Tour, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range s.tours {
+		if v.Tenent == t.Tenent && v.Phone == t.Phone && v.Status == mod.TOUR_ACTIVE && t.Status == mod.TOUR_ACTIVE {
+			return mod.Tour{}, ErrDuplicate
+		}
+	}
+	if t.Id.IsZero() {
+		t.Id = primitive.NewObjectID()
+	}
+	s.tours[t.Id] = t
+	return t, nil
+}
+
+func (s *memTourStore) filter(match func(mod.Tour) bool) []mod.Tour {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := []mod.Tour{}
+	for _, v := range s.tours {
+		if match(v) {
+			c = append(c, v)
+		}
+	}
+	sort.Slice(c, func(i, j int) bool {
+		if !c[i].Started.Equal(c[j].Started) {
+			return c[i].Started.After(c[j].Started)
+		}
+		return c[i].Id.Hex() > c[j].Id.Hex()
+	})
+	return c
+}
+
+func (s *memTourStore) FindById(ctx context.Context, tenent string, id primitive.ObjectID) (mod.Tour, error) {
+	c := s.filter(func(v mod.Tour) bool { return v.Tenent == tenent && v.Id == id })
+	if len(c) == 0 {
+		return mod.Tour{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memTourStore) FindActive(ctx context.Context, tenent, phone string) (mod.Tour, error) {
+	c := s.filter(func(v mod.Tour) bool { return v.Tenent == tenent && v.Phone == phone && v.Status == mod.TOUR_ACTIVE })
+	if len(c) == 0 {
+		return mod.Tour{}, ErrNotFound
+	}
+	return c[0], nil
+}
+
+func (s *memTourStore) List(ctx context.Context, tenent, companyId string) ([]mod.Tour, error) {
+	return s.filter(func(v mod.Tour) bool { return v.Tenent == tenent && v.CompanyId == companyId }), nil
+}
+
+func (s *memTourStore) AddLeg(ctx context.Context, tenent string, id primitive.ObjectID, leg mod.TourLeg) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.tours[id]
+	if !ok || v.Tenent != tenent || v.Status != mod.TOUR_ACTIVE {
+		return ErrNotFound
+	}
+	v.Legs = append(append([]mod.TourLeg{}, v.Legs...), leg)
+	s.tours[id] = v
+	return nil
+}
+
+func (s *memTourStore) Finish(ctx context.Context, tenent string, id primitive.ObjectID, status string, missing []string, finished time.Time) (mod.Tour, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.tours[id]
+	if !ok || v.Tenent != tenent || v.Status != mod.TOUR_ACTIVE {
+		return mod.Tour{}, ErrNotFound
+	}
+	v.Status = status
+	v.Missing = missing
+	v.Finished = &finished
+	s.tours[id] = v
+	return v, nil
+}
@@ -8,5 +8,6 @@ require (
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	go.mongodb.org/mongo-driver v1.7.2
+	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
 	gopkg.in/validator.v2 v2.0.0-20210331031555-b37d688a7fb0
 )
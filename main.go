@@ -1,47 +1,108 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/handlers"
 	api "github.com/monitor_security/api"
+	"github.com/monitor_security/config"
 	mdb "github.com/monitor_security/db"
+	mod "github.com/monitor_security/model"
+	"github.com/monitor_security/policy"
 	util "github.com/monitor_security/util"
 )
 
 func main() {
+	configFile := flag.String("config", os.Getenv("MONITOR_CONFIG"), "path to json config file")
+	flag.Parse()
 
 	fmt.Println("initialize....monitor...")
-	label := false
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Invalid configuration :%v", err)
+	}
+	util.SetLogLevel(cfg.LogLevel)
+	if cfg.Auth.SigningAlg == "HS256" {
+		if cfg.Auth.JWTKey == config.DefaultJWTKey {
+			util.Log.Println("WARNING: using the default development jwt key, set auth.jwt_key")
+		}
+		util.InitAuth(cfg.Auth.JWTKey, cfg.Auth.TokenLifetime.Duration)
+	} else {
+		util.InitAuth("", cfg.Auth.TokenLifetime.Duration)
+	}
+
+	//Permissions of each role, the built in table unless a policy file is configured.
+	if cfg.PolicyFile != "" {
+		p, err := policy.Load(cfg.PolicyFile)
+		if err != nil {
+			log.Fatalf("Invalid policy :%v", err)
+		}
+		api.SetPolicy(p)
+	}
+
+	var store *mdb.Store
 	//Initialize mongodb and start.
 	for {
-		if label {
+		if store != nil {
 			break
 		}
-		err := mdb.Init_Mongo()
+		store, err = mdb.Init_Mongo(cfg.DB)
 		if err != nil {
 			util.Log.Printf("Error setting up mongoDB :%v", err)
 			time.Sleep(5 * time.Second)
-		} else {
-			label = true
 		}
 	}
-	router := api.NewRouter()
-	router.PathPrefix("/html").Handler(http.FileServer(http.Dir("./html/")))
 
-	fs := http.FileServer(http.Dir("./media/"))
-	router.PathPrefix("/media/").Handler(http.StripPrefix("/media/", fs))
+	//Asymmetric signing keys are shared through the store and rotated in the background.
+	if cfg.Auth.SigningAlg != "HS256" {
+		rotate := func() error {
+			return mdb.RotateSigningKeys(store, cfg.Auth.SigningAlg, cfg.Auth.KeyRotation.Duration, cfg.Auth.TokenLifetime.Duration)
+		}
+		if err := rotate(); err != nil {
+			log.Fatalf("Unable to load signing keys :%v", err)
+		}
+		go func() {
+			for range time.Tick(time.Minute) {
+				if err := rotate(); err != nil {
+					util.Log.Printf("Unable to rotate signing keys :%v", err)
+				}
+			}
+		}()
+	}
+
+	//Patrol schedules are checked for missed patrols in the background.
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			if err := api.EvaluateSchedules(store, now); err != nil {
+				util.Log.Printf("Unable to evaluate patrol schedules :%v", err)
+			}
+		}
+	}()
+
+	//Seed the first platform admin.
+	if cfg.Admin.Phone != "" {
+		admin := mod.Admin{Name: cfg.Admin.Name, Phone: cfg.Admin.Phone, Password: cfg.Admin.Password}
+		if err := mdb.SeedAdmin(store, admin); err != nil {
+			util.Log.Printf("Unable to seed admin :%v", err)
+		}
+	}
+
+	router := api.NewRouter(store)
+	router.PathPrefix("/html").Handler(http.FileServer(http.Dir("./html/")))
+	//incident media is only served by GetIncidentMedia, to users allowed to read the incident.
 
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
-	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE"})
-	origins := handlers.AllowedOrigins([]string{"*"})
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "X-Device-Id"})
+	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE"})
+	origins := handlers.AllowedOrigins(cfg.CorsOrigins)
 
-	log.Println("Running HTTP Server")
+	log.Printf("Running HTTP Server on %v", cfg.ListenAddr)
 
-	log.Fatal(http.ListenAndServe(":8080", handlers.CORS(origins, headers, methods)(router)))
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, handlers.CORS(origins, headers, methods)(router)))
 
 	mdb.Close_Mongo()
 }
@@ -10,19 +10,69 @@ const (
 	ADMIN      string = "admin"
 	PROPRIETOR string = "proprietor"
 	GUARD      string = "guard"
+	CLIENT     string = "client" //customer company, read only access to its own site
 	VIDEO      string = "video"
 	IMAGE      string = "image"
 )
 
+//Proprietor side sub-roles, highest first. Proprietors without a role are the owner.
+const (
+	STAFF_OWNER      string = "owner"
+	STAFF_MANAGER    string = "manager"
+	STAFF_SUPERVISOR string = "supervisor"
+	STAFF_AUDITOR    string = "auditor" //read only
+)
+
+//Otp purposes, a login code can not be used to reset a password and vice versa.
+const (
+	OTP_LOGIN string = "login"
+	OTP_RESET string = "reset"
+	OTP_MFA   string = "mfa" //second login step, the code is the mfa token
+)
+
+//Guard status, derived from the active, registered and deleted flags.
+const (
+	GUARD_INVITED   string = "invited" //yet to register
+	GUARD_ACTIVE    string = "active"
+	GUARD_SUSPENDED string = "suspended"
+	GUARD_DELETED   string = "deleted" //kept so patrols and incidents stay attributable
+)
+
+//Tour status, a closed tour is complete, partial ( tags missing ) or out-of-order.
+const (
+	TOUR_ACTIVE       string = "active"
+	TOUR_COMPLETE     string = "complete"
+	TOUR_PARTIAL      string = "partial"
+	TOUR_OUT_OF_ORDER string = "out-of-order"
+)
+
 type Proprietor struct {
 	Id       primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Tenent   string             `json:"tenent,omitempty" bson:"tenent"` //uuid
 	Group    string             `validate:"min=3,max=25" json:"group" bson:"group"`
 	Phone    string             `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone" bson:"phone"`
-	Password string             `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password" bson:"password"` //<FIXME> password chars
-	UserType string             `validate:"regexp=^proprietor$" json:"usertype" bson:"usertype"`                //only proprietor and gurard are allowed
+	Password string             `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password,omitempty" bson:"password"` //<FIXME> password chars
+	UserType string             `validate:"regexp=^proprietor$" json:"usertype" bson:"usertype"`                          //only proprietor and gurard are allowed
 	Image    string             `json:"image,omitempty" bson:"image,omitempty"`
 	Active   bool               `json:"active,omitempty" bson:"active"`
+	Role     string             `json:"role,omitempty" bson:"role,omitempty"` //staff sub-role
+	//tenent setting, every proprietor user of the tenent must use two factor login.
+	Require2FA bool `json:"require2fa,omitempty" bson:"require2fa"`
+	Totp       Totp `json:"-" bson:"totp"`
+	//incremented on every profile update, a stale version is rejected.
+	Version int64 `json:"version" bson:"version"`
+}
+
+/*
+ * RFC 6238 two factor settings, the pending secret becomes the secret once a code
+ * generated from it is verified.
+ */
+type Totp struct {
+	Secret        string   `bson:"secret,omitempty"`
+	PendingSecret string   `bson:"pendingsecret,omitempty"`
+	Enabled       bool     `bson:"enabled"`
+	LastStep      int64    `bson:"laststep"`                //last accepted time step, a code is never accepted twice
+	RecoveryCodes []string `bson:"recoverycodes,omitempty"` //sha256 of each unused code
 }
 
 type Guard struct {
@@ -31,19 +81,48 @@ type Guard struct {
 	Group      string             `json:"group" bson:"group"`
 	Name       string             `validate:"min=3,max=25" json:"name" bson:"name"`
 	Phone      string             `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone" bson:"phone"`
-	Password   string             `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password" bson:"password"` //<FIXME> password chars
-	UserType   string             `validate:"regexp=^guard$" json:"usertype" bson:"usertype"`                     //only proprietor and gurard are allowed
+	Password   string             `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password,omitempty" bson:"password"` //<FIXME> password chars
+	UserType   string             `validate:"regexp=^guard$" json:"usertype" bson:"usertype"`                               //only proprietor and gurard are allowed
 	Image      string             `json:"image,omitempty" bson:"image,omitempty"`
 	Active     bool               `json:"active,omitempty" bson:"active"`
 	Registered bool               `json:"registered,omitempty" bson:"registered"`
+	Version    int64              `json:"version" bson:"version"`
+	Deleted    bool               `json:"deleted,omitempty" bson:"deleted"`
+	DeletedAt  *time.Time         `json:"deletedat,omitempty" bson:"deletedat,omitempty"`
+	Status     string             `json:"status,omitempty" bson:"-"`
+}
+
+/*
+ * Login of a customer company, invited by a proprietor against one company of the tenent.
+ */
+type Client struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent      string             `json:"tenent" bson:"tenent"` //uuid
+	Group       string             `json:"group" bson:"group"`
+	CompanyId   string             `json:"companyid" bson:"companyid"`
+	CompanyName string             `json:"companyname" bson:"companyname"`
+	Name        string             `json:"name" bson:"name"`
+	Phone       string             `json:"phone" bson:"phone"`
+	Password    string             `json:"password,omitempty" bson:"password"`
+	UserType    string             `json:"usertype" bson:"usertype"`
+	Active      bool               `json:"active,omitempty" bson:"active"`
+}
+
+type ClientInvite struct {
+	Name  string `validate:"min=3,max=25" json:"name"`
+	Phone string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+}
+
+type Clients struct {
+	Clients []Client `json:"clients"`
 }
 
 type Admin struct {
 	Id       string `json:"id,omitempty" bson:"_id,omitempty"`
 	Name     string `validate:"min=3,max=25" json:"name" bson:"name"`
 	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone" bson:"phone"`
-	Password string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password" bson:"password"`
-	UserType string `validate:"regexp=^admin$ json:"usertype" bson:"usertype"`
+	Password string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password,omitempty" bson:"password"`
+	UserType string `validate:"regexp=^admin$" json:"usertype" bson:"usertype"`
 	Image    string `json:"image,omitempty" bson:"image,omitempty"`
 }
 
@@ -53,6 +132,12 @@ type ProprietorPasswordLogin struct {
 	UserType string `validate:"regexp=^proprietor$" json:"usertype"`
 }
 
+type AdminPasswordLogin struct {
+	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	Password string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password"`
+	UserType string `validate:"regexp=^admin$" json:"usertype"`
+}
+
 type GuardPasswordLogin struct {
 	Tenent   string `validate:"nonzero,nonnil" json:"tenent"` //uuid
 	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
@@ -64,6 +149,37 @@ type RegisterGuard struct {
 	Phone string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone" bson:"phone"`
 }
 
+/*
+ * Guard self registration, the code is the invitation code sent when the guard was added.
+ */
+type GuardRegistration struct {
+	Tenent   string `validate:"nonzero,nonnil" json:"tenent"`
+	Name     string `validate:"min=3,max=25" json:"name"`
+	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	Password string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password"`
+	UserType string `validate:"regexp=^guard$" json:"usertype"`
+	Code     string `validate:"regexp=^[0-9]{8}$" json:"code"`
+}
+
+/*
+ * Invitation for a guard to register in a tenent, one per tenent and phone.
+ */
+type Invitation struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Tenent   string             `json:"tenent" bson:"tenent"`
+	Phone    string             `json:"phone" bson:"phone"`
+	Code     string             `json:"-" bson:"code"` //sha256 of the code
+	Attempts int                `json:"-" bson:"attempts"`
+	Used     bool               `json:"-" bson:"used"`
+	Status   string             `json:"status" bson:"-"` //pending or expired
+	Created  time.Time          `json:"created" bson:"created"`
+	Expires  time.Time          `json:"expires" bson:"expires"`
+}
+
+type Invitations struct {
+	Invitations []Invitation `json:"invitations"`
+}
+
 //-------
 type TenentsToRegister struct {
 	Tenents []TenentGroup `json:"tenents"`
@@ -74,6 +190,31 @@ type TenentGroup struct {
 	Group  string
 }
 
+type StaffInvite struct {
+	Phone string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	Role  string `validate:"regexp=^(manager|supervisor|auditor)$" json:"role"`
+}
+
+type StaffRole struct {
+	Role string `validate:"regexp=^(manager|supervisor|auditor)$" json:"role"`
+}
+
+//Tenents a logged in guard is employed by, Current is the tenent of the token.
+type GuardTenents struct {
+	Current string        `json:"current"`
+	Tenents []TenentGroup `json:"tenents"`
+}
+
+type SwitchTenent struct {
+	Tenent string `validate:"nonzero" json:"tenent"` //uuid
+}
+
+//Effective permissions of a token, as "resource:action".
+type Permissions struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
 type Company struct {
 	Id      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Tenent  string             `json:"tenent,omitempty" bson:"tenent"` //uuid
@@ -81,7 +222,170 @@ type Company struct {
 	Address string             `validate:"nonzero,nonnil" json:"address" bson:"address"`
 	Phone   string             `validate:"min=8,regexp=^[0-9]+$" json:"phone" bson:"phone"`
 	Image   string             `json:"image,omitempty" bson:"image,omitempty"`
-	//Add Patrol frequency
+	Version int64              `json:"version" bson:"version"`
+	//site boundary, guard patrols and incidents are checked against it.
+	Geofence *Geofence `json:"geofence,omitempty" bson:"geofence,omitempty"`
+	//reject patrols scanning a tag which is not a checkpoint of the company, instead of flagging.
+	RejectUnknownTags bool `json:"rejectunknowntags" bson:"rejectunknowntags"`
+	//patrol frequency is set with PatrolSchedule.
+}
+
+/*
+ * Site boundary of a company, Radius meters around Center or the Polygon of [lng, lat]
+ * corners. Submissions from outside are flagged, or rejected when Enforce is set.
+ */
+type Geofence struct {
+	Center  *GeoPoint   `json:"center,omitempty" bson:"center,omitempty"`
+	Radius  float64     `json:"radius,omitempty" bson:"radius,omitempty"`
+	Polygon [][]float64 `json:"polygon,omitempty" bson:"polygon,omitempty"`
+	Enforce bool        `json:"enforce" bson:"enforce"`
+}
+
+/*
+ * NFC, RFID or QR tag placed at a company site, a patrol scan sends the tag as RFData.
+ * Order is the expected position of the checkpoint in a patrol round, 0 for any.
+ */
+type Checkpoint struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent    string             `json:"tenent,omitempty" bson:"tenent"` //uuid
+	CompanyId string             `json:"companyid" bson:"companyid"`
+	Name      string             `validate:"min=3,max=25" json:"name" bson:"name"`
+	Tag       string             `validate:"nonzero,max=128" json:"tag" bson:"tag"`
+	TagType   string             `validate:"regexp=^(nfc|rfid|qr)$" json:"tagtype" bson:"tagtype"`
+	GPS       string             `json:"gps,omitempty" bson:"gps,omitempty"` //"lat,lng"
+	Location  *GeoPoint          `json:"location,omitempty" bson:"location,omitempty"`
+	Order     int                `validate:"min=0" json:"order" bson:"order"`
+	Version   int64              `json:"version" bson:"version"`
+}
+
+type Checkpoints struct {
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+/*
+ * Patrols expected at a company. An interval rule expects a patrol in every Interval
+ * minutes from Start to End ( "HH:MM", End before Start runs past midnight ), a Cron rule
+ * expects one within Window minutes of every time it matches. Times are in TimeZone.
+ */
+type PatrolSchedule struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent    string             `json:"tenent,omitempty" bson:"tenent"` //uuid
+	CompanyId string             `json:"companyid" bson:"companyid"`
+	Name      string             `validate:"min=3,max=25" json:"name" bson:"name"` //shift
+	Start     string             `json:"start,omitempty" bson:"start,omitempty"`
+	End       string             `json:"end,omitempty" bson:"end,omitempty"`
+	Interval  int                `json:"interval,omitempty" bson:"interval,omitempty"`
+	Cron      string             `json:"cron,omitempty" bson:"cron,omitempty"`
+	Window    int                `json:"window,omitempty" bson:"window,omitempty"`
+	TimeZone  string             `json:"timezone" bson:"timezone"` //IANA name, UTC when empty
+	Created   time.Time          `json:"created" bson:"created"`
+	//windows ending before it were checked for missed patrols.
+	EvaluatedUntil time.Time `json:"-" bson:"evaluateduntil"`
+}
+
+type PatrolSchedules struct {
+	Schedules []PatrolSchedule `json:"schedules"`
+}
+
+/*
+ * Exception recorded when no patrol was made at the company in a window of a schedule.
+ */
+type MissedPatrol struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent      string             `json:"tenent,omitempty" bson:"tenent"` //uuid
+	CompanyId   string             `json:"companyid" bson:"companyid"`
+	CompanyName string             `json:"companyname" bson:"companyname"`
+	ScheduleId  string             `json:"scheduleid" bson:"scheduleid"`
+	Shift       string             `json:"shift" bson:"shift"`
+	From        time.Time          `json:"from" bson:"from"`
+	To          time.Time          `json:"to" bson:"to"`
+	Created     time.Time          `json:"created" bson:"created"`
+}
+
+type MissedPatrols struct {
+	MissedPatrols []MissedPatrol `json:"missedpatrols"`
+}
+
+/*
+ * Patrol windows of a shift ( schedule ) that ended in the period, and how many had a patrol.
+ */
+type ShiftCompliance struct {
+	ScheduleId string  `json:"scheduleid"`
+	Shift      string  `json:"shift"`
+	Expected   int     `json:"expected"`
+	Completed  int     `json:"completed"`
+	Missed     int     `json:"missed"`
+	Rate       float64 `json:"rate"` //completed / expected, 1 when nothing was expected
+}
+
+type Compliance struct {
+	CompanyId   string            `json:"companyid"`
+	CompanyName string            `json:"companyname"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Expected    int               `json:"expected"`
+	Completed   int               `json:"completed"`
+	Missed      int               `json:"missed"`
+	Rate        float64           `json:"rate"`
+	Shifts      []ShiftCompliance `json:"shifts"`
+}
+
+type Compliances struct {
+	Companies []Compliance `json:"companies"`
+}
+
+/*
+ * Ordered RF tags a guard scans on a patrol tour of the company.
+ */
+type TourRoute struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent    string             `json:"tenent,omitempty" bson:"tenent"` //uuid
+	CompanyId string             `json:"companyid" bson:"companyid"`
+	Name      string             `validate:"min=3,max=25" json:"name" bson:"name"`
+	Tags      []string           `validate:"min=1,max=100" json:"tags" bson:"tags"` //RFData values in order
+	Created   time.Time          `json:"created" bson:"created"`
+}
+
+type TourRoutes struct {
+	Routes []TourRoute `json:"routes"`
+}
+
+/*
+ * A guard walking a TourRoute, the scans made while it is active are its legs. Tags are
+ * copied from the route when the tour starts.
+ */
+type Tour struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Tenent      string             `json:"tenent,omitempty" bson:"tenent"` //uuid
+	CompanyId   string             `json:"companyid" bson:"companyid"`
+	CompanyName string             `json:"companyname" bson:"companyname"`
+	RouteId     string             `json:"routeid" bson:"routeid"`
+	Route       string             `json:"route" bson:"route"`
+	Tags        []string           `json:"tags" bson:"tags"`
+	Phone       string             `json:"phone" bson:"phone"`
+	Name        string             `json:"name" bson:"name"`
+	Status      string             `json:"status" bson:"status"`
+	Started     time.Time          `json:"started" bson:"started"`
+	Finished    *time.Time         `json:"finished,omitempty" bson:"finished,omitempty"`
+	Legs        []TourLeg          `json:"legs" bson:"legs"`
+	Missing     []string           `json:"missing,omitempty" bson:"missing,omitempty"` //route tags not scanned
+}
+
+/*
+ * A scan made on a tour, Position is the index of the tag in the route ( -1 when it is not
+ * on the route ) and Seconds the time since the previous scan or the start of the tour.
+ */
+type TourLeg struct {
+	Tag        string    `json:"tag" bson:"tag"`
+	Checkpoint string    `json:"checkpoint,omitempty" bson:"checkpoint,omitempty"`
+	Position   int       `json:"position" bson:"position"`
+	PatrolId   string    `json:"patrolid" bson:"patrolid"`
+	Scanned    time.Time `json:"scanned" bson:"scanned"`
+	Seconds    float64   `json:"seconds" bson:"seconds"`
+}
+
+type Tours struct {
+	Tours []Tour `json:"tours"`
 }
 
 type Companies struct {
@@ -100,10 +404,35 @@ type Incidents struct {
 	Incidents []Incident `json:"incidents"`
 }
 
+type Proprietors struct {
+	Proprietors []Proprietor `json:"proprietors"`
+}
+
+type TenentStats struct {
+	Tenent    string `json:"tenent"`
+	Group     string `json:"group"`
+	Active    bool   `json:"active"`
+	Guards    int64  `json:"guards"`
+	Companies int64  `json:"companies"`
+	Patrols   int64  `json:"patrols"`
+	Incidents int64  `json:"incidents"`
+}
+
+type TenentsStats struct {
+	Tenents []TenentStats `json:"tenents"`
+}
+
 type PasswordLogin struct {
+	Tenent   string `json:"tenent,omitempty"` //uuid, guard only, resolved from the phone when empty
 	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
 	Password string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"password"`
-	UserType string `validate:"regexp=^(admin|proprietor|guard)$" json:"usertype"`
+	UserType string `validate:"regexp=^(admin|proprietor|guard|client)$" json:"usertype"`
+}
+
+//Returned by the login of a guard registered in more than one tenent, login again with one of them.
+type TenentChoices struct {
+	Status  string        `json:"status"`
+	Tenents []TenentGroup `json:"tenents"`
 }
 
 type OwnerTokenData struct {
@@ -111,6 +440,15 @@ type OwnerTokenData struct {
 	Tenent   string
 	Phone    string
 	UserType string
+	Role     string
+	Session  string
+}
+
+type AdminTokenData struct {
+	Name     string
+	Phone    string
+	UserType string
+	Session  string
 }
 
 type GuardTokenData struct {
@@ -119,6 +457,95 @@ type GuardTokenData struct {
 	Name     string
 	Phone    string
 	UserType string
+	Session  string
+}
+
+type ClientTokenData struct {
+	Group     string
+	Tenent    string
+	CompanyId string
+	Name      string
+	Phone     string
+	UserType  string
+	Session   string
+}
+
+/*
+ * Revocation of a single token ( jti ) or of every token a subject was issued before a time.
+ */
+type Revocation struct {
+	Id      string    `bson:"_id"` //"jti:<jti>" or "sub:<subject>"
+	Before  time.Time `bson:"before"`
+	Expires time.Time `bson:"expires"`
+}
+
+/*
+ * Refresh token of one login session ( family ) on one device, only the sha256 of
+ * the opaque token is stored. Every refresh marks the token used and issues the
+ * next one in the same family.
+ */
+type RefreshToken struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty"`
+	Hash         string             `bson:"hash"`
+	Family       string             `bson:"family"`
+	Device       string             `bson:"device"`
+	UserType     string             `bson:"usertype"`
+	Tenent       string             `bson:"tenent"`
+	Phone        string             `bson:"phone"`
+	Used         bool               `bson:"used"`
+	Revoked      bool               `bson:"revoked"`
+	SessionStart time.Time          `bson:"sessionstart"`
+	Created      time.Time          `bson:"created"`
+	Expires      time.Time          `bson:"expires"`
+}
+
+/*
+ * Asymmetric token signing key, Expires is when tokens signed with it can no longer be valid.
+ */
+type SigningKey struct {
+	Kid        string    `bson:"_id"`
+	Alg        string    `bson:"alg"`
+	PrivateKey string    `bson:"privatekey"` //PEM
+	Created    time.Time `bson:"created"`
+	Expires    time.Time `bson:"expires"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	Status       string `json:"status"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+/*
+ * Login attempts of an account or a client address, Failures counts the attempts which
+ * did not pass, and any still being checked.
+ */
+type LoginAttempt struct {
+	Id       string    `bson:"_id"` //"acct:<subject>" or "ip:<address>"
+	Failures int       `bson:"failures"`
+	Last     time.Time `bson:"last"`
+	Expires  time.Time `bson:"expires"`
 }
 
 type ErrorResponse struct {
@@ -129,6 +556,14 @@ type SuccessResponse struct {
 	Status string `json:"status"`
 }
 
+type InsertResult struct {
+	InsertedID string `json:"InsertedID"`
+}
+
+type DeleteResult struct {
+	DeletedCount int64 `json:"DeletedCount"`
+}
+
 type Patrol struct {
 	Id          string    `json:"id,omitempty" bson:"_id,omitempty"`
 	Phone       string    `json:"phone" bson:"phone"`
@@ -139,8 +574,26 @@ type Patrol struct {
 	Date        time.Time `json:"-" bson:"date"`
 	Date_HR     string    `json:"date_hr" bson:"date_hr"`
 	Description string    `json:"description" bson:"description"`
-	GPS         string    `validate:"nonzero,nonnil" json:"gps" bson:"gps"`
+	GPS         string    `validate:"nonzero,nonnil" json:"gps" bson:"gps"` //"lat,lng" as sent
 	RFData      string    `validate:"nonzero,nonnil" json:"rfdata" bson:"rfdata"`
+	Location    *GeoPoint `json:"location,omitempty" bson:"location,omitempty"` //parsed GPS, 2dsphere indexed
+	//set when the company has a geofence, meters outside of it.
+	OutsideSite  bool    `json:"outsidesite,omitempty" bson:"outsidesite,omitempty"`
+	SiteDistance float64 `json:"sitedistance,omitempty" bson:"sitedistance,omitempty"`
+	//checkpoint the RFData resolved to, UnknownTag when the company has checkpoints and none matched.
+	CheckpointId string `json:"checkpointid,omitempty" bson:"checkpointid,omitempty"`
+	Checkpoint   string `json:"checkpoint,omitempty" bson:"checkpoint,omitempty"`
+	UnknownTag   bool   `json:"unknowntag,omitempty" bson:"unknowntag,omitempty"`
+	//active tour of the guard at the company when the scan was made.
+	TourId string `json:"tourid,omitempty" bson:"tourid,omitempty"`
+}
+
+/*
+ * GeoJSON Point, Coordinates are [longitude, latitude].
+ */
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
 }
 
 type Incident struct {
@@ -154,11 +607,85 @@ type Incident struct {
 	Date_HR     string    `json:"date_hr" bson:"date_hr"`
 	Description string    `json:"description" bson:"description"`
 	Media       []string  `json:"media" bson:"media"`
+	GPS         string    `json:"gps,omitempty" bson:"gps,omitempty"` //"lat,lng", required by geofenced companies
+	Location    *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	//set when the company has a geofence, meters outside of it.
+	OutsideSite  bool    `json:"outsidesite,omitempty" bson:"outsidesite,omitempty"`
+	SiteDistance float64 `json:"sitedistance,omitempty" bson:"sitedistance,omitempty"`
 }
 
 //-------------------------------------------------------------------------------------------------
 type OtpLogin struct {
+	Tenent   string `json:"tenent,omitempty"` //uuid, required for guard
 	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
 	Otp      string `validate:"min=6,max=6,regexp=^[0-9]+$" json:"otp"`
-	UserType string `validate:"nonzero,nonnil" json:"usertype"`
+	UserType string `validate:"regexp=^(proprietor|guard)$" json:"usertype"`
+}
+
+type OtpRequest struct {
+	Tenent   string `json:"tenent,omitempty"` //uuid, required for guard
+	Phone    string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	UserType string `validate:"regexp=^(proprietor|guard|client)$" json:"usertype"`
+}
+
+type ChangePassword struct {
+	OldPassword string `validate:"nonzero" json:"oldpassword"`
+	NewPassword string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"newpassword"`
+}
+
+type PasswordReset struct {
+	Tenent      string `json:"tenent,omitempty"` //uuid, required for guard
+	Phone       string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	UserType    string `validate:"regexp=^(proprietor|guard|client)$" json:"usertype"`
+	Code        string `validate:"min=6,max=6,regexp=^[0-9]+$" json:"code"`
+	NewPassword string `validate:"min=8,max=15,regexp=^[a-zA-Z0-9]+$" json:"newpassword"`
+}
+
+type TotpCode struct {
+	Code string `validate:"regexp=^[0-9]{6}$" json:"code"`
+}
+
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recoverycodes"`
+}
+
+type TotpRequired struct {
+	Required bool `json:"required"`
+}
+
+/*
+ * Returned by a proprietor login when a second factor is needed, Enroll is set
+ * when the tenent requires 2FA and the user still has to enrol with Secret.
+ */
+type MfaChallenge struct {
+	Status   string `json:"status"`
+	MfaToken string `json:"mfatoken"`
+	Enroll   bool   `json:"enroll,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	URI      string `json:"uri,omitempty"`
+}
+
+type MfaLogin struct {
+	Phone        string `validate:"min=8,max=15,regexp=^[0-9]+$" json:"phone"`
+	MfaToken     string `validate:"nonzero" json:"mfatoken"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recoverycode,omitempty"`
+}
+
+type Otp struct {
+	Id       primitive.ObjectID `bson:"_id,omitempty"`
+	Tenent   string             `bson:"tenent"`
+	Phone    string             `bson:"phone"`
+	UserType string             `bson:"usertype"`
+	Purpose  string             `bson:"purpose"`
+	Code     string             `bson:"code"` //sha256 of the otp
+	Attempts int                `bson:"attempts"`
+	Used     bool               `bson:"used"`
+	Created  time.Time          `bson:"created"`
+	Expires  time.Time          `bson:"expires"`
 }
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/monitor_security/util"
+)
+
+/*
+ * SMSSender delivers a text message to a phone number, plug in a real gateway by
+ * assigning notify.SMS at startup.
+ */
+type SMSSender interface {
+	Send(phone, message string) error
+}
+
+var SMS SMSSender = LogSender{}
+
+/*
+ * LogSender writes messages to the application log, no SMS is sent.
+ */
+type LogSender struct{}
+
+func (LogSender) Send(phone, message string) error {
+	util.Log.Printf("SMS to %v : %v", phone, message)
+	return nil
+}
+
+/*
+ * FileSender appends messages to a file, useful to read codes back in tests or staging.
+ */
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (f *FileSender) Send(phone, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%v %v %v\n", time.Now().Format(time.RFC3339), phone, message)
+	return err
+}
@@ -0,0 +1,285 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Resource string
+type Action string
+type Role string
+
+const (
+	ResourceTenents    Resource = "tenents"    //platform management of every tenent
+	ResourceSettings   Resource = "settings"   //settings of the user's own tenent
+	ResourceStaff      Resource = "staff"      //proprietor side users of the tenent
+	ResourceGuard      Resource = "guard"      //guards of the tenent
+	ResourceClient     Resource = "client"     //customer logins of the tenent's companies
+	ResourceInvitation Resource = "invitation" //guard invitations of the tenent
+	ResourceCompany    Resource = "company"
+	ResourceCheckpoint Resource = "checkpoint" //tags at a company site
+	ResourcePatrol     Resource = "patrol"
+	ResourceSchedule   Resource = "schedule"  //patrol schedules, missed patrols and compliance
+	ResourceTourRoute  Resource = "tourroute" //ordered tags of a patrol tour
+	ResourceTour       Resource = "tour"      //tours walked by guards
+	ResourceIncident   Resource = "incident"
+	ResourceMembership Resource = "membership" //tenents a guard belongs to
+	ResourceProfile    Resource = "profile"    //the proprietor user's own record
+	ResourceMfa        Resource = "mfa"        //the user's own second factor
+	ResourcePassword   Resource = "password"   //the user's own password
+	ResourceSession    Resource = "session"    //the token used for the request
+)
+
+const (
+	ActionRead      Action = "read"
+	ActionCreate    Action = "create"
+	ActionUpdate    Action = "update"
+	ActionDelete    Action = "delete"
+	ActionDeleteAll Action = "delete-all"
+	ActionUnlock    Action = "unlock"
+)
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleOwner      Role = "proprietor:owner"
+	RoleManager    Role = "proprietor:manager"
+	RoleSupervisor Role = "proprietor:supervisor"
+	RoleAuditor    Role = "proprietor:auditor"
+	RoleGuard      Role = "guard"
+	RoleClient     Role = "client"
+)
+
+var resources = []Resource{
+	ResourceTenents, ResourceSettings, ResourceStaff, ResourceGuard, ResourceClient, ResourceInvitation, ResourceCompany,
+	ResourceCheckpoint, ResourcePatrol, ResourceSchedule, ResourceTourRoute, ResourceTour, ResourceIncident, ResourceMembership, ResourceProfile, ResourceMfa, ResourcePassword,
+	ResourceSession,
+}
+
+var actions = []Action{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionDeleteAll, ActionUnlock}
+
+var roles = []Role{RoleAdmin, RoleOwner, RoleManager, RoleSupervisor, RoleAuditor, RoleGuard, RoleClient}
+
+type Permission struct {
+	Resource Resource
+	Action   Action
+}
+
+//Routes which need no token at all.
+var Public = Permission{Resource: "public"}
+
+func (p Permission) String() string {
+	return string(p.Resource) + ":" + string(p.Action)
+}
+
+/*
+ * Known permissions only, a typo in a route or policy file must not silently grant
+ * or deny access.
+ */
+func (p Permission) Validate() error {
+	if p == Public {
+		return nil
+	}
+	if !containsResource(p.Resource) || !containsAction(p.Action) {
+		return fmt.Errorf("Unknown permission %q", p.String())
+	}
+	return nil
+}
+
+func ParsePermission(s string) (Permission, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return Permission{}, fmt.Errorf("Permission %q is not resource:action", s)
+	}
+	p := Permission{Resource: Resource(parts[0]), Action: Action(parts[1])}
+	return p, p.Validate()
+}
+
+func containsResource(r Resource) bool {
+	for _, v := range resources {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAction(a Action) bool {
+	for _, v := range actions {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+func containsRole(r Role) bool {
+	for _, v := range roles {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Policy grants permissions to roles, anything not granted is denied.
+ */
+type Policy struct {
+	grants map[Role]map[Permission]bool
+}
+
+func New(table map[Role][]Permission) (*Policy, error) {
+	p := &Policy{grants: map[Role]map[Permission]bool{}}
+	for role, perms := range table {
+		if !containsRole(role) {
+			return nil, fmt.Errorf("Unknown role %q", role)
+		}
+		p.grants[role] = map[Permission]bool{}
+		for _, perm := range perms {
+			if perm == Public {
+				return nil, fmt.Errorf("Role %q can not be granted the public permission", role)
+			}
+			if err := perm.Validate(); err != nil {
+				return nil, fmt.Errorf("Role %q : %v", role, err)
+			}
+			p.grants[role][perm] = true
+		}
+	}
+	return p, nil
+}
+
+func (p *Policy) Allows(role Role, perm Permission) bool {
+	return perm == Public || p.grants[role][perm]
+}
+
+/*
+ * Effective permissions of the role, sorted.
+ */
+func (p *Policy) Permissions(role Role) []Permission {
+	c := []Permission{}
+	for perm := range p.grants[role] {
+		c = append(c, perm)
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].String() < c[j].String() })
+	return c
+}
+
+/*
+ * Load a policy file, a json object of role to "resource:action" permissions, eg.
+ * {"proprietor:auditor": ["guard:read", "company:read"]}. Roles left out get nothing.
+ */
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read policy file: %v", err)
+	}
+	var raw map[Role][]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("Unable to parse policy file %v: %v", path, err)
+	}
+	table := map[Role][]Permission{}
+	for role, perms := range raw {
+		for _, s := range perms {
+			perm, err := ParsePermission(s)
+			if err != nil {
+				return nil, fmt.Errorf("Role %q : %v", role, err)
+			}
+			table[role] = append(table[role], perm)
+		}
+	}
+	return New(table)
+}
+
+func grant(resource Resource, actions ...Action) []Permission {
+	c := make([]Permission, len(actions))
+	for i, a := range actions {
+		c[i] = Permission{Resource: resource, Action: a}
+	}
+	return c
+}
+
+func join(lists ...[]Permission) []Permission {
+	var c []Permission
+	for _, l := range lists {
+		c = append(c, l...)
+	}
+	return c
+}
+
+/*
+ * Built in policy, each proprietor sub-role gets the permissions of the one below it.
+ */
+func DefaultTable() map[Role][]Permission {
+	account := join(
+		grant(ResourceSession, ActionRead, ActionDelete),
+		grant(ResourcePassword, ActionUpdate),
+	)
+	auditor := join(account,
+		grant(ResourceProfile, ActionRead, ActionUpdate),
+		grant(ResourceMfa, ActionCreate, ActionUpdate, ActionDelete),
+		grant(ResourceGuard, ActionRead),
+		grant(ResourceClient, ActionRead),
+		grant(ResourceInvitation, ActionRead),
+		grant(ResourceCompany, ActionRead),
+		grant(ResourceCheckpoint, ActionRead),
+		grant(ResourcePatrol, ActionRead),
+		grant(ResourceSchedule, ActionRead),
+		grant(ResourceTourRoute, ActionRead),
+		grant(ResourceTour, ActionRead),
+		grant(ResourceIncident, ActionRead),
+	)
+	supervisor := join(auditor,
+		grant(ResourceGuard, ActionUnlock),
+		grant(ResourcePatrol, ActionCreate),
+		grant(ResourceTour, ActionCreate, ActionUpdate),
+		grant(ResourceIncident, ActionCreate, ActionUpdate),
+	)
+	manager := join(supervisor,
+		grant(ResourceStaff, ActionRead),
+		grant(ResourceGuard, ActionCreate, ActionUpdate, ActionDelete),
+		grant(ResourceClient, ActionCreate, ActionDelete),
+		grant(ResourceInvitation, ActionCreate, ActionDelete),
+		grant(ResourceCompany, ActionCreate, ActionUpdate, ActionDelete),
+		grant(ResourceCheckpoint, ActionCreate, ActionUpdate, ActionDelete),
+		grant(ResourceSchedule, ActionCreate, ActionDelete),
+		grant(ResourceTourRoute, ActionCreate, ActionDelete),
+		grant(ResourceIncident, ActionDelete),
+	)
+	owner := join(manager,
+		grant(ResourceSettings, ActionUpdate),
+		grant(ResourceStaff, ActionCreate, ActionUpdate, ActionDelete),
+		grant(ResourceCompany, ActionDeleteAll),
+	)
+	return map[Role][]Permission{
+		RoleAdmin:      join(grant(ResourceSession, ActionRead, ActionDelete), grant(ResourceTenents, ActionRead, ActionUpdate)),
+		RoleOwner:      owner,
+		RoleManager:    manager,
+		RoleSupervisor: supervisor,
+		RoleAuditor:    auditor,
+		RoleGuard: join(account,
+			grant(ResourceMembership, ActionRead, ActionUpdate),
+			grant(ResourceCompany, ActionRead),
+			grant(ResourceCheckpoint, ActionRead),
+			grant(ResourcePatrol, ActionCreate, ActionRead),
+			grant(ResourceTourRoute, ActionRead),
+			grant(ResourceTour, ActionCreate, ActionRead, ActionUpdate),
+			grant(ResourceIncident, ActionCreate, ActionRead, ActionUpdate),
+		),
+		//read only, handlers limit the reads to the client's own company.
+		RoleClient: join(account,
+			grant(ResourcePatrol, ActionRead),
+			grant(ResourceIncident, ActionRead),
+		),
+	}
+}
+
+func Default() *Policy {
+	p, err := New(DefaultTable())
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
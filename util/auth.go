@@ -2,69 +2,120 @@ package util
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	mod "github.com/monitor_security/model"
 )
 
-var jwtKey = []byte("e0b1a2bc-1dfd-11ec-87f3-38baf832d723")
+var tokenLifetime = time.Minute * 15
 
 /*
- * Generate a fresh token or refresh existing token.
+ * Set the HS256 key ( if any ) and access token lifetime, must be called before
+ * tokens are issued. Asymmetric keys are installed with SetSigningKeys.
+ */
+func InitAuth(key string, lifetime time.Duration) {
+	tokenLifetime = lifetime
+	if key == "" {
+		setKeyring(nil, map[string]*signingKey{})
+		return
+	}
+	k := &signingKey{method: jwt.SigningMethodHS256, sign: []byte(key), verify: []byte(key)}
+	setKeyring(k, map[string]*signingKey{"": k})
+}
+
+func TokenLifetime() time.Duration {
+	return tokenLifetime
+}
+
+/*
+ * Subject identifies the user a token was issued to, used to revoke all their tokens.
+ */
+func TokenSubject(usertype, tenent, phone string) string {
+	return usertype + "/" + tenent + "/" + phone
+}
+
+/*
+ * Every issued token gets a unique jti and issue time so it can be revoked. The issue
+ * time has microseconds, a login right after the user's tokens are revoked ( password
+ * reset ) must not fall in the same second as the revocation.
+ */
+func stampClaims(claims jwt.MapClaims) {
+	t := time.Now()
+	claims["jti"] = uuid.New().String()
+	claims["iat"] = float64(t.UnixNano()/int64(time.Microsecond)) / 1e6
+	claims["exp"] = t.Add(tokenLifetime).Unix()
+}
+
+/*
+ * Generate a signed access token, sid binds it to the refresh token family it was issued with.
  */
 func GenerateJWT(t interface{}) (string, error) {
 	var token *jwt.Token
 
+	signing := signingKeyForIssue()
+	if signing == nil {
+		return "", fmt.Errorf("No signing key")
+	}
+
 	if c, ok := t.(*mod.OwnerTokenData); ok {
-		tok := jwt.New(jwt.SigningMethodHS256)
+		tok := jwt.New(signing.method)
 		claims := tok.Claims.(jwt.MapClaims)
-		claims["exp"] = time.Now().Add(time.Minute * 3600).Unix()
+		stampClaims(claims)
 		claims["tenent"] = c.Tenent
 		claims["phone"] = c.Phone
 		claims["usertype"] = c.UserType
 		claims["group"] = c.Group
+		claims["role"] = c.Role
+		claims["sid"] = c.Session
 
 		token = tok
 	} else if c, ok := t.(*mod.GuardTokenData); ok {
-		tok := jwt.New(jwt.SigningMethodHS256)
+		tok := jwt.New(signing.method)
 		claims := tok.Claims.(jwt.MapClaims)
-		claims["exp"] = time.Now().Add(time.Minute * 3600).Unix()
+		stampClaims(claims)
 		claims["tenent"] = c.Tenent
 		claims["phone"] = c.Phone
 		claims["name"] = c.Name
 		claims["usertype"] = c.UserType
 		claims["group"] = c.Group
+		claims["sid"] = c.Session
 
 		token = tok
-	} else if c, ok := t.(string); ok {
-		tok, err := jwt.Parse(c, func(tk *jwt.Token) (interface{}, error) {
-			if _, ok := tk.Method.(*jwt.SigningMethodHMAC); !ok {
-				return "", fmt.Errorf("Wrong Singing algorithm")
-			}
-			return jwtKey, nil
-		})
-
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				return "", fmt.Errorf("Invalid Signature")
-			}
-			if v, ok := err.(*jwt.ValidationError); ok {
-				if v.Errors == jwt.ValidationErrorExpired {
-					Log.Println("Token has expired, hence refresh....")
-				} else if !token.Valid {
-					return "", fmt.Errorf("Malformed token.")
-				}
-			}
-		}
+	} else if c, ok := t.(*mod.ClientTokenData); ok {
+		tok := jwt.New(signing.method)
 		claims := tok.Claims.(jwt.MapClaims)
-		claims["exp"] = time.Now().Add(time.Minute * 3600).Unix()
+		stampClaims(claims)
+		claims["tenent"] = c.Tenent
+		claims["company"] = c.CompanyId
+		claims["phone"] = c.Phone
+		claims["name"] = c.Name
+		claims["usertype"] = c.UserType
+		claims["group"] = c.Group
+		claims["sid"] = c.Session
+
+		token = tok
+	} else if c, ok := t.(*mod.AdminTokenData); ok {
+		tok := jwt.New(signing.method)
+		claims := tok.Claims.(jwt.MapClaims)
+		stampClaims(claims)
+		claims["tenent"] = ""
+		claims["phone"] = c.Phone
+		claims["name"] = c.Name
+		claims["usertype"] = c.UserType
+		claims["sid"] = c.Session
+
 		token = tok
 	} else {
 		return "", fmt.Errorf("Unknown token")
 	}
 
-	tokenStr, err := token.SignedString(jwtKey)
+	if signing.kid != "" {
+		token.Header["kid"] = signing.kid
+	}
+	tokenStr, err := token.SignedString(signing.sign)
 	if err != nil {
 		Log.Println("Could not create a signed token")
 		return "", err
@@ -73,12 +124,7 @@ func GenerateJWT(t interface{}) (string, error) {
 }
 
 func ValidateToken(t string) bool {
-	token, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Wrong Singing algorithm")
-		}
-		return jwtKey, nil
-	})
+	token, err := jwt.Parse(t, verificationKey)
 	if err != nil || !token.Valid {
 		Log.Printf("Invalid token :%v", err)
 		return false
@@ -89,23 +135,15 @@ func ValidateToken(t string) bool {
 }
 
 func GetUserClaims(t string) (jwt.MapClaims, error) {
-	tok, err := jwt.Parse(t, func(tk *jwt.Token) (interface{}, error) {
-		if _, ok := tk.Method.(*jwt.SigningMethodHMAC); !ok {
-			return "", fmt.Errorf("Wrong Singing algorithm")
-		}
-		return jwtKey, nil
-	})
+	tok, err := jwt.Parse(t, verificationKey)
 
 	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
+		if v, ok := err.(*jwt.ValidationError); ok && v.Errors == jwt.ValidationErrorExpired {
+			Log.Println("Token has expired, hence refresh....")
+		} else if v, ok := err.(*jwt.ValidationError); ok && v.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
 			return nil, fmt.Errorf("Invalid Signature")
-		}
-		if v, ok := err.(*jwt.ValidationError); ok {
-			if v.Errors == jwt.ValidationErrorExpired {
-				Log.Println("Token has expired, hence refresh....")
-			} else if !tok.Valid {
-				return nil, fmt.Errorf("Malformed token.")
-			}
+		} else {
+			return nil, fmt.Errorf("Malformed token.")
 		}
 	}
 	claims := tok.Claims.(jwt.MapClaims)
@@ -121,3 +159,14 @@ func GetUserClaims(t string) (jwt.MapClaims, error) {
 
 	return claims, nil
 }
+
+/*
+ * Issue time of the token, zero when it has none.
+ */
+func ClaimsIssuedAt(claims jwt.MapClaims) time.Time {
+	iat, ok := claims["iat"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(math.Round(iat*1e6))*int64(time.Microsecond))
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	mod "github.com/monitor_security/model"
+)
+
+//Mean earth radius in meters.
+const earthRadius = 6371008.8
+
+/*
+ * Parse a "lat,lng" pair in decimal degrees, as sent by the guard app.
+ */
+func ParseLatLng(s string) (lat, lng float64, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Expected lat,lng got %q", s)
+	}
+	if lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return 0, 0, fmt.Errorf("Invalid latitude %q", parts[0])
+	}
+	if lng, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+		return 0, 0, fmt.Errorf("Invalid longitude %q", parts[1])
+	}
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("Latitude out of range %v", lat)
+	}
+	if math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return 0, 0, fmt.Errorf("Longitude out of range %v", lng)
+	}
+	return lat, lng, nil
+}
+
+/*
+ * GeoJSON point, coordinates are longitude first.
+ */
+func GeoPoint(lat, lng float64) *mod.GeoPoint {
+	return &mod.GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+/*
+ * Great circle ( haversine ) distance in meters.
+ */
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+/*
+ * A geofence is a circle ( Center and Radius ) or a polygon of at least 3 corners,
+ * a closing corner equal to the first is removed.
+ */
+func ValidateGeofence(g *mod.Geofence) error {
+	switch {
+	case g.Center != nil && len(g.Polygon) == 0:
+		if g.Center.Type != "Point" || len(g.Center.Coordinates) != 2 || !validLngLat(g.Center.Coordinates) {
+			return fmt.Errorf("center must be a GeoJSON Point")
+		}
+		if !(g.Radius > 0) || math.IsInf(g.Radius, 0) {
+			return fmt.Errorf("radius must be positive meters")
+		}
+	case g.Center == nil && len(g.Polygon) > 0:
+		if g.Radius != 0 {
+			return fmt.Errorf("radius is only used with center")
+		}
+		for _, p := range g.Polygon {
+			if len(p) != 2 || !validLngLat(p) {
+				return fmt.Errorf("polygon corners must be [lng, lat]")
+			}
+		}
+		if n := len(g.Polygon); n > 1 && g.Polygon[0][0] == g.Polygon[n-1][0] && g.Polygon[0][1] == g.Polygon[n-1][1] {
+			g.Polygon = g.Polygon[:n-1]
+		}
+		if len(g.Polygon) < 3 {
+			return fmt.Errorf("polygon needs at least 3 corners")
+		}
+	default:
+		return fmt.Errorf("geofence is either center and radius or polygon")
+	}
+	return nil
+}
+
+func validLngLat(p []float64) bool {
+	return p[0] >= -180 && p[0] <= 180 && p[1] >= -90 && p[1] <= 90
+}
+
+/*
+ * Meters from lat,lng to the geofence, zero inside it. Polygons are treated as flat,
+ * good enough for the size of a site. A stored geofence is validated again, an invalid
+ * one is an error.
+ */
+func GeofenceDistance(g mod.Geofence, lat, lng float64) (float64, error) {
+	if err := ValidateGeofence(&g); err != nil {
+		return 0, err
+	}
+	if g.Center != nil {
+		d := Distance(lat, lng, g.Center.Coordinates[1], g.Center.Coordinates[0]) - g.Radius
+		return math.Max(0, d), nil
+	}
+
+	//local plane in meters around the point.
+	k := earthRadius * math.Pi / 180
+	xy := func(p []float64) (float64, float64) {
+		return (p[0] - lng) * k * math.Cos(lat*math.Pi/180), (p[1] - lat) * k
+	}
+	inside := false
+	nearest := math.Inf(1)
+	for i := range g.Polygon {
+		ax, ay := xy(g.Polygon[i])
+		bx, by := xy(g.Polygon[(i+1)%len(g.Polygon)])
+		if (ay > 0) != (by > 0) && ax+(0-ay)*(bx-ax)/(by-ay) > 0 {
+			inside = !inside
+		}
+		nearest = math.Min(nearest, segmentDistance(ax, ay, bx, by))
+	}
+	if inside {
+		return 0, nil
+	}
+	return nearest, nil
+}
+
+//distance from the origin to the segment a-b.
+func segmentDistance(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	mod "github.com/monitor_security/model"
+)
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+/*
+ * keyring holds the key new tokens are signed with and every key ( by kid ) tokens
+ * are still accepted from. HS256 keys have an empty kid and are never published.
+ */
+var keyring = struct {
+	sync.RWMutex
+	signing *signingKey
+	verify  map[string]*signingKey
+}{verify: map[string]*signingKey{}}
+
+func setKeyring(signing *signingKey, verify map[string]*signingKey) {
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.signing = signing
+	keyring.verify = verify
+}
+
+func signingKeyForIssue() *signingKey {
+	keyring.RLock()
+	defer keyring.RUnlock()
+	return keyring.signing
+}
+
+/*
+ * Key lookup for jwt.Parse, the token alg must match the alg of the key named by kid.
+ */
+func verificationKey(tk *jwt.Token) (interface{}, error) {
+	kid, _ := tk.Header["kid"].(string)
+
+	keyring.RLock()
+	k, ok := keyring.verify[kid]
+	keyring.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key")
+	}
+	if tk.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("Wrong Singing algorithm")
+	}
+	return k.verify, nil
+}
+
+/*
+ * Generate a new RS256 or ES256 key, the private key is PEM encoded.
+ */
+func GenerateSigningKey(alg string) (mod.SigningKey, error) {
+	var block *pem.Block
+	switch alg {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return mod.SigningKey{}, err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case "ES256":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return mod.SigningKey{}, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return mod.SigningKey{}, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return mod.SigningKey{}, fmt.Errorf("Unsupported signing algorithm : %v", alg)
+	}
+	return mod.SigningKey{
+		Kid:        uuid.New().String(),
+		Alg:        alg,
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		Created:    time.Now(),
+	}, nil
+}
+
+func parseSigningKey(k mod.SigningKey) (*signingKey, error) {
+	switch k.Alg {
+	case "RS256":
+		key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(k.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{kid: k.Kid, method: jwt.SigningMethodRS256, sign: key, verify: &key.PublicKey}, nil
+	case "ES256":
+		key, err := jwt.ParseECPrivateKeyFromPEM([]byte(k.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{kid: k.Kid, method: jwt.SigningMethodES256, sign: key, verify: &key.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("Unsupported signing algorithm : %v", k.Alg)
+}
+
+/*
+ * Replace the keyring, tokens are signed with signing and accepted from any of verify.
+ */
+func SetSigningKeys(signing mod.SigningKey, verify []mod.SigningKey) error {
+	keys := map[string]*signingKey{}
+	for _, k := range append(verify, signing) {
+		parsed, err := parseSigningKey(k)
+		if err != nil {
+			return fmt.Errorf("Invalid signing key %v : %v", k.Kid, err)
+		}
+		keys[k.Kid] = parsed
+	}
+	setKeyring(keys[signing.Kid], keys)
+	return nil
+}
+
+/*
+ * Public keys of the keyring as a JSON Web Key Set.
+ */
+func JWKS() mod.JWKS {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	set := mod.JWKS{Keys: []mod.JWK{}}
+	for kid, k := range keyring.verify {
+		enc := base64.RawURLEncoding
+		switch pub := k.verify.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, mod.JWK{
+				Kty: "RSA", Use: "sig", Alg: k.method.Alg(), Kid: kid,
+				N: enc.EncodeToString(pub.N.Bytes()),
+				E: enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, mod.JWK{
+				Kty: "EC", Use: "sig", Alg: k.method.Alg(), Kid: kid, Crv: "P-256",
+				X: enc.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+				Y: enc.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -1,12 +1,26 @@
 package util
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
 )
 
 var Log *log.Logger
+var Debug *log.Logger
 
 func init() {
 	Log = log.New(os.Stdout, "security-gaurd : ", log.LstdFlags)
+	Debug = log.New(ioutil.Discard, "security-gaurd [debug] : ", log.LstdFlags)
+}
+
+/*
+ * Debug output is discarded unless level is "debug".
+ */
+func SetLogLevel(level string) {
+	if level == "debug" {
+		Debug.SetOutput(os.Stdout)
+	} else {
+		Debug.SetOutput(ioutil.Discard)
+	}
 }
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+//bcrypt work factor, tests lower it to keep the suite fast.
+var PasswordCost = bcrypt.DefaultCost
+
+/*
+ * Hash a plaintext password for storage.
+ */
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+/*
+ * Check a plaintext password against the stored value. Accounts created before
+ * hashing was introduced still hold the plaintext password, those are compared
+ * in constant time and reported as legacy so the caller can re-hash them.
+ */
+func CheckPassword(stored, password string) (match bool, legacy bool) {
+	if IsPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+}
+
+func IsPasswordHash(stored string) bool {
+	for _, p := range bcryptPrefixes {
+		if strings.HasPrefix(stored, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mod "github.com/monitor_security/model"
+)
+
+/*
+ * Time a patrol is expected in, from Start up to ( not including ) End.
+ */
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+/*
+ * A schedule is either an interval rule ( Start, End and Interval ) or a Cron rule with
+ * a Window, the time zone must be known.
+ */
+func ValidateSchedule(s mod.PatrolSchedule) error {
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return fmt.Errorf("Unknown timezone %q", s.TimeZone)
+	}
+	switch {
+	case s.Cron == "" && s.Window == 0:
+		if _, err := clock(s.Start); err != nil {
+			return err
+		}
+		if _, err := clock(s.End); err != nil {
+			return err
+		}
+		if s.Interval < 5 || s.Interval > 24*60 {
+			return fmt.Errorf("interval must be 5 to 1440 minutes")
+		}
+	case s.Start == "" && s.End == "" && s.Interval == 0:
+		if _, err := parseCron(s.Cron); err != nil {
+			return err
+		}
+		if s.Window < 1 || s.Window > 24*60 {
+			return fmt.Errorf("window must be 1 to 1440 minutes")
+		}
+	default:
+		return fmt.Errorf("schedule is either start, end and interval or cron and window")
+	}
+	return nil
+}
+
+/*
+ * Windows of a valid schedule starting in [from, to), in order.
+ */
+func ScheduleWindows(s mod.PatrolSchedule, from, to time.Time) []Window {
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil
+	}
+	if s.Cron != "" {
+		return cronWindows(s, loc, from, to)
+	}
+
+	start, _ := clock(s.Start)
+	end, _ := clock(s.End)
+	if end <= start {
+		end += 24 * time.Hour //runs past midnight
+	}
+	interval := time.Duration(s.Interval) * time.Minute
+
+	//a shift of the previous day may still be running at from.
+	var c []Window
+	f := from.In(loc)
+	for day := time.Date(f.Year(), f.Month(), f.Day()-1, 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		shiftStart := time.Date(day.Year(), day.Month(), day.Day(), int(start.Hours()), int(start.Minutes())%60, 0, 0, loc)
+		shiftEnd := shiftStart.Add(end - start)
+		for t := shiftStart; t.Before(shiftEnd); t = t.Add(interval) {
+			w := Window{Start: t, End: t.Add(interval)}
+			if w.End.After(shiftEnd) {
+				w.End = shiftEnd
+			}
+			if !w.Start.Before(from) && w.Start.Before(to) {
+				c = append(c, w)
+			}
+		}
+	}
+	return c
+}
+
+/*
+ * A window is patrolled when a patrol was made in it. Patrols flagged outside the site
+ * or with an unknown tag do not count.
+ */
+func Patrolled(w Window, patrols []mod.Patrol) bool {
+	for _, p := range patrols {
+		if p.OutsideSite || p.UnknownTag {
+			continue
+		}
+		if !p.Date.Before(w.Start) && p.Date.Before(w.End) {
+			return true
+		}
+	}
+	return false
+}
+
+func cronWindows(s mod.PatrolSchedule, loc *time.Location, from, to time.Time) []Window {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return nil
+	}
+	var c []Window
+	window := time.Duration(s.Window) * time.Minute
+	for t := from.In(loc).Truncate(time.Minute); t.Before(to); t = t.Add(time.Minute) {
+		if !t.Before(from) && spec.match(t) {
+			c = append(c, Window{Start: t, End: t.Add(window)})
+		}
+	}
+	return c
+}
+
+//"HH:MM" as the offset from midnight.
+func clock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Expected HH:MM got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+/*
+ * Five field cron rule ( minute hour day-of-month month day-of-week ), fields take *, n,
+ * a-b, lists and /step. Sunday is 0 or 7.
+ */
+type cronSpec struct {
+	minute, hour, dom, month, dow map[int]bool
+	anyDom, anyDow                bool
+}
+
+func parseCron(expr string) (cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron needs 5 fields got %q", expr)
+	}
+	var spec cronSpec
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := []*map[int]bool{&spec.minute, &spec.hour, &spec.dom, &spec.month, &spec.dow}
+	for i, f := range fields {
+		if *sets[i], err = cronField(f, bounds[i][0], bounds[i][1]); err != nil {
+			return cronSpec{}, fmt.Errorf("cron field %q: %v", f, err)
+		}
+	}
+	if spec.dow[7] {
+		spec.dow[0] = true
+	}
+	spec.anyDom, spec.anyDow = fields[2] == "*", fields[4] == "*"
+	return spec, nil
+}
+
+func cronField(f string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step")
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value")
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value")
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("out of range %v-%v", min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+//like cron, a restricted day-of-month or day-of-week matches either.
+func (c cronSpec) match(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}